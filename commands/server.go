@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"encoding/base64"
+	"fmt"
 	"github.com/urfave/cli/v2"
 	"globalflow/config"
 	"globalflow/globalflow"
@@ -27,12 +29,71 @@ var ServerCommand = &cli.Command{
 		&cli.StringFlag{
 			Name: "node-zone",
 		},
+		&cli.StringFlag{
+			Name: "node-tls-cert",
+		},
+		&cli.StringFlag{
+			Name: "node-tls-key",
+		},
+		&cli.StringFlag{
+			Name: "node-tls-ca",
+		},
 		&cli.StringFlag{
 			Name: "node-region",
 		},
+		&cli.StringFlag{
+			Name:    "gossip-key",
+			EnvVars: []string{"GLOBALFLOW_GOSSIP_KEY"},
+		},
 		&cli.IntFlag{
 			Name: "redis-port",
 		},
+		&cli.StringFlag{
+			Name: "redis-tls-cert",
+		},
+		&cli.StringFlag{
+			Name: "redis-tls-key",
+		},
+		&cli.StringFlag{
+			Name:    "redis-password",
+			EnvVars: []string{"GLOBALFLOW_REDIS_PASSWORD"},
+		},
+		&cli.DurationFlag{
+			Name: "expiry-sweep-interval",
+		},
+		&cli.IntFlag{
+			Name: "expiry-sweep-batch-size",
+		},
+		&cli.DurationFlag{
+			Name: "replication-gap-threshold",
+		},
+		&cli.DurationFlag{
+			Name: "wal-retention",
+		},
+		&cli.Int64Flag{
+			Name: "wal-max-size",
+		},
+		&cli.DurationFlag{
+			Name: "anti-entropy-interval",
+		},
+		&cli.DurationFlag{
+			Name: "tombstone-grace-period",
+		},
+		&cli.DurationFlag{
+			Name: "hint-retention",
+		},
+		&cli.Int64Flag{
+			Name: "hint-max-size",
+		},
+		&cli.DurationFlag{
+			Name: "clock-skew-warning",
+		},
+		&cli.DurationFlag{
+			Name: "clock-skew-limit",
+		},
+		&cli.DurationFlag{
+			Name: "clock-max-offset",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		container := &globalflow.Container{
@@ -67,6 +128,87 @@ var ServerCommand = &cli.Command{
 			container.Configuration.RedisPort = c.Int("redis-port")
 		}
 
+		if c.String("node-tls-cert") != "" {
+			container.Configuration.NodeTLSCertFile = c.String("node-tls-cert")
+		}
+
+		if c.String("node-tls-key") != "" {
+			container.Configuration.NodeTLSKeyFile = c.String("node-tls-key")
+		}
+
+		if c.String("node-tls-ca") != "" {
+			container.Configuration.NodeTLSCAFile = c.String("node-tls-ca")
+		}
+
+		if c.String("gossip-key") != "" {
+			key, err := base64.StdEncoding.DecodeString(c.String("gossip-key"))
+			if err != nil {
+				return fmt.Errorf("gossip key: %w", err)
+			}
+
+			container.Configuration.GossipKey = key
+		}
+
+		if c.String("redis-tls-cert") != "" {
+			container.Configuration.RedisTLSCertFile = c.String("redis-tls-cert")
+		}
+
+		if c.String("redis-tls-key") != "" {
+			container.Configuration.RedisTLSKeyFile = c.String("redis-tls-key")
+		}
+
+		if c.String("redis-password") != "" {
+			container.Configuration.RedisPassword = c.String("redis-password")
+		}
+
+		if c.IsSet("expiry-sweep-interval") {
+			container.Configuration.ExpirySweepInterval = c.Duration("expiry-sweep-interval")
+		}
+
+		if c.IsSet("expiry-sweep-batch-size") {
+			container.Configuration.ExpirySweepBatchSize = c.Int("expiry-sweep-batch-size")
+		}
+
+		if c.IsSet("replication-gap-threshold") {
+			container.Configuration.ReplicationGapThreshold = c.Duration("replication-gap-threshold")
+		}
+
+		if c.IsSet("wal-retention") {
+			container.Configuration.WALRetention = c.Duration("wal-retention")
+		}
+
+		if c.IsSet("wal-max-size") {
+			container.Configuration.WALMaxSize = c.Int64("wal-max-size")
+		}
+
+		if c.IsSet("anti-entropy-interval") {
+			container.Configuration.AntiEntropyInterval = c.Duration("anti-entropy-interval")
+		}
+
+		if c.IsSet("tombstone-grace-period") {
+			container.Configuration.TombstoneGracePeriod = c.Duration("tombstone-grace-period")
+		}
+
+		if c.IsSet("hint-retention") {
+			container.Configuration.HintRetention = c.Duration("hint-retention")
+		}
+
+		if c.IsSet("hint-max-size") {
+			container.Configuration.HintMaxSize = c.Int64("hint-max-size")
+		}
+
+		if c.IsSet("clock-skew-warning") {
+			container.Configuration.ClockSkewWarning = c.Duration("clock-skew-warning")
+		}
+
+		if c.IsSet("clock-skew-limit") {
+			container.Configuration.ClockSkewLimit = c.Duration("clock-skew-limit")
+		}
+
+		if c.IsSet("clock-max-offset") {
+			container.Configuration.ClockMaxOffset = c.Duration("clock-max-offset")
+		}
+
 		server := globalflow.NewServer(container)
 
 		sigs := make(chan os.Signal, 1)
@@ -1,6 +1,11 @@
 package config
 
-import "os"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
 
 // Configuration is a struct that contains the global configuration.
 type Configuration struct {
@@ -19,6 +24,18 @@ type Configuration struct {
 	// NodePeers is a list of peers.
 	NodePeers []string
 
+	// NodeTLSCertFile and NodeTLSKeyFile are the certificate and private key that the node presents to other nodes.
+	// The certificate must be issued for the node's name. Nodes connect to each other with mutual TLS if they are set.
+	NodeTLSCertFile string
+	NodeTLSKeyFile  string
+
+	// NodeTLSCAFile contains the certificates of the CAs that issue node certificates.
+	NodeTLSCAFile string
+
+	// GossipKey is the key that encrypts gossip between nodes and authenticates their HTTP requests to each other
+	// without TLS. It is 16, 24 or 32 bytes long, or empty to disable encryption.
+	GossipKey []byte
+
 	// NodeRegion is the region of the node.
 	NodeRegion string
 
@@ -30,6 +47,60 @@ type Configuration struct {
 
 	// RedisPort is the port to run the Redis server on.
 	RedisPort int
+
+	// RedisTLSCertFile and RedisTLSKeyFile are the certificate and private key of the Redis listener.
+	// The listener only accepts TLS connections if they are set.
+	RedisTLSCertFile string
+	RedisTLSKeyFile  string
+
+	// RedisPassword is the password of the default Redis user until it is redefined with ACL SETUSER.
+	// The default user requires no password if it is empty.
+	RedisPassword string
+
+	// ExpirySweepInterval is how often expired keys are reclaimed.
+	ExpirySweepInterval time.Duration
+
+	// ExpirySweepBatchSize is the maximum number of expired keys to delete in a single transaction.
+	ExpirySweepBatchSize int
+
+	// ReplicationGapThreshold is how long commands can be missing from another node before the gap is reported in
+	// the logs and in INFO. Zero reports every gap.
+	ReplicationGapThreshold time.Duration
+
+	// WALRetention is how long commands are kept in the write-ahead log. Zero keeps them regardless of age.
+	WALRetention time.Duration
+
+	// WALMaxSize is the maximum size of the write-ahead log in bytes, beyond which the oldest commands are removed.
+	// Zero does not limit its size.
+	WALMaxSize int64
+
+	// AntiEntropyInterval is how often the node compares its data with a random peer and repairs the keys that differ.
+	// Zero disables anti-entropy.
+	AntiEntropyInterval time.Duration
+
+	// TombstoneGracePeriod is how long the tombstones of deleted keys are kept at least, and until every live node has
+	// advertised that it has received every write made before them. Zero purges them as soon as every live node has.
+	TombstoneGracePeriod time.Duration
+
+	// HintRetention is how long messages that could not be delivered to a node are kept for it. Zero keeps them
+	// regardless of age.
+	HintRetention time.Duration
+
+	// HintMaxSize is the maximum size of the messages kept for nodes they could not be delivered to in bytes, beyond
+	// which further messages are dropped. Zero does not limit their size.
+	HintMaxSize int64
+
+	// ClockSkewWarning is how far the clock of a peer can be from the node's before a warning is logged. Zero disables
+	// the warning.
+	ClockSkewWarning time.Duration
+
+	// ClockSkewLimit is how far the node's clock can be from the cluster's before it refuses writes, so that it does not
+	// order them wrongly. Zero never refuses writes.
+	ClockSkewLimit time.Duration
+
+	// ClockMaxOffset is how far ahead of the node's wall clock the times of messages from other nodes can move its
+	// clock, against which keys expire. Zero does not limit it.
+	ClockMaxOffset time.Duration
 }
 
 // NewConfiguration creates a new configuration with default values.
@@ -47,5 +118,63 @@ func NewConfiguration() *Configuration {
 		NodeZone:     "local",
 		NodeHostname: hostname,
 		RedisPort:    63790,
+
+		ExpirySweepInterval:  time.Millisecond * 100,
+		ExpirySweepBatchSize: 100,
+
+		ReplicationGapThreshold: 10 * time.Second,
+
+		WALRetention: 24 * time.Hour,
+		WALMaxSize:   256 << 20,
+
+		AntiEntropyInterval: 30 * time.Second,
+
+		TombstoneGracePeriod: 24 * time.Hour,
+
+		HintRetention: 3 * time.Hour,
+		HintMaxSize:   64 << 20,
+
+		ClockSkewWarning: 500 * time.Millisecond,
+		ClockMaxOffset:   time.Minute,
+	}
+}
+
+// Validate returns an error if the configuration contains values that the node cannot run with.
+func (configuration *Configuration) Validate() error {
+	if configuration.ExpirySweepInterval <= 0 {
+		return errors.New("the expiry sweep interval must be positive")
 	}
+
+	if configuration.ExpirySweepBatchSize <= 0 {
+		return errors.New("the expiry sweep batch size must be positive")
+	}
+
+	// Zero disables or does not limit each of these settings.
+	settings := []struct {
+		name  string
+		value int64
+	}{
+		{"replication gap threshold", int64(configuration.ReplicationGapThreshold)},
+		{"write-ahead log retention", int64(configuration.WALRetention)},
+		{"write-ahead log size", configuration.WALMaxSize},
+		{"anti-entropy interval", int64(configuration.AntiEntropyInterval)},
+		{"tombstone grace period", int64(configuration.TombstoneGracePeriod)},
+		{"hint retention", int64(configuration.HintRetention)},
+		{"hint size", configuration.HintMaxSize},
+		{"clock skew warning", int64(configuration.ClockSkewWarning)},
+		{"clock skew limit", int64(configuration.ClockSkewLimit)},
+		{"maximum clock offset", int64(configuration.ClockMaxOffset)},
+	}
+
+	for _, setting := range settings {
+		if setting.value < 0 {
+			return fmt.Errorf("the %s must not be negative", setting.name)
+		}
+	}
+
+	if n := len(configuration.GossipKey); n != 0 && n != 16 && n != 24 && n != 32 {
+		return errors.New("the gossip key must be 16, 24 or 32 bytes long")
+	}
+
+	return nil
 }
@@ -0,0 +1,477 @@
+package globalflow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"globalflow/globalflow/db"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Anti-entropy repairs the differences that replication leaves behind, such as the writes a node missed while it was
+// down for longer than the write-ahead log is kept. Each round, a node compares the hash tree of its data with the
+// tree of a random peer, starting from the roots and descending only into the subtrees whose hashes differ, until it
+// reaches the leaves. The two nodes then compare the digests of the keys in the differing leaves, exchange the state
+// of the keys that differ, and merge it with the rules that resolve concurrent writes, so that both end up with the
+// same state.
+//
+// The hash trees of the logical databases are joined under a single root, so that a path through the forest starts
+// with the hex digit of the database index, followed by the path through the database's tree.
+
+// antiEntropyTreeTTL is how long a node keeps the hash tree of a round, after which the round is abandoned.
+const antiEntropyTreeTTL = time.Minute
+
+// antiEntropyRemoteRounds is how often a round is held with a peer in another region, rather than one in the same
+// region.
+const antiEntropyRemoteRounds = 4
+
+// antiEntropyMessageSize is the size of the entries sent in a single message, beyond which they are split.
+const antiEntropyMessageSize = 1 << 20
+
+// syncForest is the hash tree of every logical database, as built for a round of anti-entropy.
+type syncForest struct {
+	trees [db.Databases]*db.MerkleTree
+	root  []byte
+
+	builtAt time.Time
+}
+
+// buildSyncForest builds the hash tree of every logical database.
+func buildSyncForest(root *db.Database, now time.Time) (*syncForest, error) {
+	forest := &syncForest{builtAt: now}
+
+	h := sha256.New()
+	empty := true
+
+	for index := 0; index < db.Databases; index++ {
+		database, err := root.Select(index)
+		if err != nil {
+			return nil, err
+		}
+
+		tree, err := database.MerkleTree(db.Time(now.UnixMilli()))
+		if err != nil {
+			return nil, err
+		}
+
+		forest.trees[index] = tree
+
+		sum := tree.Hash("")
+		if sum == nil {
+			sum = make([]byte, sha256.Size)
+		} else {
+			empty = false
+		}
+
+		h.Write(sum)
+	}
+
+	if !empty {
+		forest.root = h.Sum(nil)
+	}
+
+	return forest, nil
+}
+
+// parseSyncPath splits a path through the forest into the database index and the path through the database's tree.
+// Returns false if the path is not a valid path below the root.
+func parseSyncPath(path string) (int, string, bool) {
+	if path == "" || len(path) > db.MerkleDepth+1 {
+		return 0, "", false
+	}
+
+	for _, c := range path {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return 0, "", false
+		}
+	}
+
+	index, err := strconv.ParseInt(path[:1], 16, 0)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return int(index), path[1:], true
+}
+
+// hash returns the hash of the node at a path, or nil if there are no keys beneath it.
+func (forest *syncForest) hash(path string) []byte {
+	if path == "" {
+		return forest.root
+	}
+
+	index, treePath, ok := parseSyncPath(path)
+	if !ok {
+		return nil
+	}
+
+	return forest.trees[index].Hash(treePath)
+}
+
+// leaf returns true if the path is a leaf of a database's tree.
+func (forest *syncForest) leaf(path string) bool {
+	return len(path) == db.MerkleDepth+1
+}
+
+// children returns the paths of the children of an internal node.
+func (forest *syncForest) children(path string) []string {
+	return db.MerkleChildren(path)
+}
+
+// digests returns the digests of the keys in the leaf at a path, by key.
+func (forest *syncForest) digests(path string) map[string][]byte {
+	index, treePath, ok := parseSyncPath(path)
+	if !ok {
+		return nil
+	}
+
+	return forest.trees[index].Digests(treePath)
+}
+
+// antiEntropy contains the state of the rounds of anti-entropy that a node takes part in, and their statistics.
+type antiEntropy struct {
+	// mutex serializes the handling of sync messages, and must be held when accessing forests.
+	mutex sync.Mutex
+
+	// forests contains the hash trees built for the rounds in progress, by round.
+	forests map[string]*syncForest
+
+	rounds        atomic.Uint64
+	bytesSent     atomic.Uint64
+	bytesReceived atomic.Uint64
+	keysRepaired  atomic.Uint64
+}
+
+func newAntiEntropy() *antiEntropy {
+	return &antiEntropy{
+		forests: make(map[string]*syncForest),
+	}
+}
+
+// forest returns the hash trees of a round, building them if the node has not yet taken part in it.
+// The mutex must be held.
+func (a *antiEntropy) forest(root *db.Database, round string, now time.Time) (*syncForest, error) {
+	for id, forest := range a.forests {
+		if now.Sub(forest.builtAt) > antiEntropyTreeTTL {
+			delete(a.forests, id)
+		}
+	}
+
+	if forest, ok := a.forests[round]; ok {
+		return forest, nil
+	}
+
+	forest, err := buildSyncForest(root, now)
+	if err != nil {
+		return nil, err
+	}
+
+	a.forests[round] = forest
+
+	return forest, nil
+}
+
+// AntiEntropy runs until shutdown, periodically holding a round of anti-entropy with a random peer.
+func (server *Server) AntiEntropy() {
+	interval := server.container.Configuration.AntiEntropyInterval
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.shutdownCh:
+			return
+
+		case <-ticker.C:
+			if server.loading.Load() {
+				continue
+			}
+
+			err := server.antiEntropyRound()
+			if err != nil {
+				logrus.WithError(err).Warn("failed to start anti-entropy round")
+			}
+		}
+	}
+}
+
+// antiEntropyPeer returns the peer to hold a round of anti-entropy with, or nil if there are no peers.
+// Most rounds are held with a peer in the same region, which is cheaper to reach, and every few rounds with a peer in
+// another region.
+func (server *Server) antiEntropyPeer(round uint64) *Node {
+	local, remote := server.LocalNodes(), server.RemoteNodes()
+
+	candidates := local
+	if len(local) == 0 || (len(remote) > 0 && round%antiEntropyRemoteRounds == 0) {
+		candidates = remote
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// antiEntropyRound starts a round of anti-entropy by sending the root of the node's hash tree to a random peer.
+func (server *Server) antiEntropyRound() error {
+	round := server.antiEntropy.rounds.Load() + 1
+
+	peer := server.antiEntropyPeer(round)
+	if peer == nil {
+		return nil
+	}
+
+	server.antiEntropy.rounds.Add(1)
+
+	nodeID := server.container.Configuration.NodeID
+	id := fmt.Sprintf("%s-%d-%d", nodeID, server.startedAt.UnixNano(), round)
+
+	server.antiEntropy.mutex.Lock()
+	forest, err := server.antiEntropy.forest(server.db, id, time.Now())
+	server.antiEntropy.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	logrus.WithField("node", peer.NodeID()).WithField("round", id).Debug("Starting anti-entropy round")
+
+	return server.sendSync(peer, &SyncMessage{
+		Round:  id,
+		Sender: nodeID,
+		Hashes: map[string][]byte{"": forest.hash("")},
+	})
+}
+
+// handleSync compares the hashes and digests that a peer sent during a round of anti-entropy with the node's own,
+// merges the state of the keys that it sent, and replies with whatever the peer needs to continue the round.
+func (server *Server) handleSync(message *SyncMessage) {
+	server.antiEntropy.bytesReceived.Add(uint64(message.size))
+
+	// A bootstrapping node would only compare its peer's data with a partial copy.
+	if server.loading.Load() {
+		return
+	}
+
+	server.antiEntropy.mutex.Lock()
+	defer server.antiEntropy.mutex.Unlock()
+
+	log := logrus.WithField("node", message.Sender).WithField("round", message.Round)
+
+	peer := server.Peer(message.Sender)
+	if peer == nil {
+		log.Debug("cannot take part in an anti-entropy round with an unknown node")
+		return
+	}
+
+	now := time.Now()
+
+	forest, err := server.antiEntropy.forest(server.db, message.Round, now)
+	if err != nil {
+		log.WithError(err).Warn("failed to build hash tree")
+		return
+	}
+
+	reply := &SyncMessage{
+		Round:   message.Round,
+		Sender:  server.container.Configuration.NodeID,
+		Hashes:  make(map[string][]byte),
+		Digests: make(map[string]map[string][]byte),
+	}
+
+	for path, theirs := range message.Hashes {
+		if path != "" {
+			if _, _, ok := parseSyncPath(path); !ok {
+				continue
+			}
+		}
+
+		if bytes.Equal(theirs, forest.hash(path)) {
+			continue
+		}
+
+		if forest.leaf(path) {
+			digests := forest.digests(path)
+			if digests == nil {
+				digests = make(map[string][]byte)
+			}
+
+			reply.Digests[path] = digests
+
+			continue
+		}
+
+		for _, child := range forest.children(path) {
+			reply.Hashes[child] = forest.hash(child)
+		}
+	}
+
+	send := make(map[int][]string)
+
+	for path, theirs := range message.Digests {
+		index, _, ok := parseSyncPath(path)
+		if !ok || !forest.leaf(path) {
+			continue
+		}
+
+		mine := forest.digests(path)
+
+		for key, digest := range theirs {
+			if !bytes.Equal(digest, mine[key]) {
+				reply.Want = append(reply.Want, SyncKey{DB: index, Key: key})
+			}
+		}
+
+		for key, digest := range mine {
+			if !bytes.Equal(digest, theirs[key]) {
+				send[index] = append(send[index], key)
+			}
+		}
+	}
+
+	for _, key := range message.Want {
+		send[key.DB] = append(send[key.DB], key.Key)
+	}
+
+	server.mergeSyncEntries(message.Entries, now)
+
+	for index, keys := range send {
+		entries, err := server.syncEntries(index, keys, now)
+		if err != nil {
+			log.WithError(err).Warn("failed to read keys to repair")
+			return
+		}
+
+		reply.Entries = append(reply.Entries, entries...)
+	}
+
+	if len(reply.Hashes) == 0 && len(reply.Digests) == 0 && len(reply.Entries) == 0 && len(reply.Want) == 0 {
+		return
+	}
+
+	err = server.sendSync(peer, reply)
+	if err != nil {
+		log.WithError(err).Warn("failed to send anti-entropy message")
+	}
+}
+
+// syncEntries reads the state of keys of a logical database to send to a peer.
+func (server *Server) syncEntries(index int, keys []string, now time.Time) ([]SyncEntry, error) {
+	if index < 0 || index >= db.Databases {
+		return nil, nil
+	}
+
+	database, err := server.db.Select(index)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := database.Entries(db.Time(now.UnixMilli()), keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	synced := make([]SyncEntry, 0, len(entries))
+	for _, entry := range entries {
+		synced = append(synced, SyncEntry{DB: index, Entry: entry})
+	}
+
+	return synced, nil
+}
+
+// mergeSyncEntries merges the state of keys sent by a peer, and notifies the clients watching or tracking the keys that
+// changed.
+func (server *Server) mergeSyncEntries(entries []SyncEntry, now time.Time) {
+	for _, entry := range entries {
+		database, err := server.db.Select(entry.DB)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to select database")
+			continue
+		}
+
+		// The clock is moved past the version of the repaired key, so that the node's own writes to it are newer.
+		server.clock.Set(Time(entry.Data.Version.Time))
+
+		// Watching clients are notified before the write is applied, like for any other write.
+		server.watches.Touch(entry.DB, entry.Key)
+
+		changed, err := database.Merge(db.Time(now.UnixMilli()), entry.Entry)
+		if err != nil {
+			logrus.WithError(err).WithField("key", entry.Key).Warn("failed to repair key")
+			continue
+		}
+
+		if changed {
+			server.antiEntropy.keysRepaired.Add(1)
+			server.deliver(server.tracking.Invalidate(nil, entry.Key))
+
+			logrus.WithField("db", entry.DB).WithField("key", entry.Key).Debug("Repaired key")
+		}
+	}
+}
+
+// sendSync sends a sync message to a peer, splitting its entries over several messages if they are too large to send
+// in one.
+func (server *Server) sendSync(peer *Node, message *SyncMessage) error {
+	messages := []*SyncMessage{message}
+
+	if len(message.Entries) > 0 {
+		entries := message.Entries
+		message.Entries = nil
+
+		size := 0
+
+		for _, entry := range entries {
+			encoded, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			last := messages[len(messages)-1]
+
+			if size > 0 && size+len(encoded) > antiEntropyMessageSize {
+				last = &SyncMessage{Round: message.Round, Sender: message.Sender}
+				messages = append(messages, last)
+				size = 0
+			}
+
+			last.Entries = append(last.Entries, entry)
+			size += len(encoded)
+		}
+	}
+
+	for _, m := range messages {
+		encoded, err := encodeMessage(m)
+		if err != nil {
+			return err
+		}
+
+		err = server.send(peer, encoded)
+		if err != nil {
+			return err
+		}
+
+		server.antiEntropy.bytesSent.Add(uint64(len(encoded)))
+	}
+
+	return nil
+}
+
+// AntiEntropyStats returns the number of rounds of anti-entropy that the node has started, the bytes it has sent and
+// received during rounds, and the number of keys it has repaired.
+func (server *Server) AntiEntropyStats() (rounds uint64, sent uint64, received uint64, repaired uint64) {
+	a := server.antiEntropy
+
+	return a.rounds.Load(), a.bytesSent.Load(), a.bytesReceived.Load(), a.keysRepaired.Load()
+}
@@ -0,0 +1,104 @@
+package globalflow
+
+import (
+	"bytes"
+	"globalflow/globalflow/db"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSyncPath(t *testing.T) {
+	tests := []struct {
+		path  string
+		index int
+		tree  string
+		ok    bool
+	}{
+		{path: "0", index: 0, tree: "", ok: true},
+		{path: "f12a", index: 15, tree: "12a", ok: true},
+		{path: "", ok: false},
+		{path: "f12ab", ok: false},
+		{path: "g", ok: false},
+		{path: "0A", ok: false},
+	}
+
+	for _, test := range tests {
+		index, tree, ok := parseSyncPath(test.path)
+		if ok != test.ok || (ok && (index != test.index || tree != test.tree)) {
+			t.Errorf("%q: expected %d %q %v, got %d %q %v", test.path, test.index, test.tree, test.ok, index, tree, ok)
+		}
+	}
+}
+
+func TestBuildSyncForest(t *testing.T) {
+	databases := make([]*db.Database, 2)
+
+	for i := range databases {
+		database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer database.Close()
+
+		databases[i] = database
+	}
+
+	now := time.Now()
+
+	for i, database := range databases {
+		selected, err := database.Select(3)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := selected.Set("a", db.Version{}, "value", 0); err != nil {
+			t.Fatal(err)
+		}
+
+		// The second database holds a different value for the key.
+		if _, err := selected.Set("b", db.Version{}, string(rune('x'+i)), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	forests := make([]*syncForest, 2)
+
+	for i, database := range databases {
+		forest, err := buildSyncForest(database, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		forests[i] = forest
+	}
+
+	// Descending through the differing children leads to the leaf of the differing key.
+	path := ""
+
+	for !forests[0].leaf(path) {
+		differing := make([]string, 0)
+
+		for _, child := range forests[0].children(path) {
+			if !bytes.Equal(forests[0].hash(child), forests[1].hash(child)) {
+				differing = append(differing, child)
+			}
+		}
+
+		if len(differing) != 1 {
+			t.Fatalf("expected a single differing child of %q, got %v", path, differing)
+		}
+
+		path = differing[0]
+	}
+
+	if path[0] != '3' {
+		t.Errorf("expected a leaf of database 3, got %q", path)
+	}
+
+	digests := forests[0].digests(path)
+	if len(digests) == 0 || digests["b"] == nil || bytes.Equal(digests["b"], forests[1].digests(path)["b"]) {
+		t.Errorf("expected differing digests for b, got %v", digests)
+	}
+}
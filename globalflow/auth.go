@@ -0,0 +1,567 @@
+package globalflow
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/tidwall/redcon"
+	"globalflow/globalflow/db"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ACL users are stored as the rules that define them, and are replicated like any other write. ACL SETUSER resolves its
+// rules against the user as this node knows it and replicates the complete definition, so that every node converges on
+// the most recent definition. Passwords are hashed before they are stored or replicated.
+
+var (
+	errWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	errNoAuth    = errors.New("NOAUTH Authentication required.")
+)
+
+// aclCategories returns the names of every ACL category, in sorted order.
+func aclCategories() []string {
+	seen := map[string]bool{"all": true}
+
+	for _, command := range clientCommands() {
+		for _, category := range command.categories() {
+			seen[category] = true
+		}
+	}
+
+	categories := make([]string, 0, len(seen))
+	for category := range seen {
+		categories = append(categories, category)
+	}
+
+	sort.Strings(categories)
+
+	return categories
+}
+
+// inCategory returns true if a command belongs to an ACL category.
+func inCategory(name string, category string) bool {
+	command := lookupCommand(name)
+
+	return command != nil && command.inCategory(category)
+}
+
+// aclUser is a user that connections authenticate as.
+// Users are not modified once they have been created, so they can be shared between connections.
+type aclUser struct {
+	Name string
+
+	// Enabled is true if connections can authenticate as the user.
+	Enabled bool
+
+	// NoPass is true if any password is accepted.
+	NoPass bool
+
+	// Passwords contains the hex encoded SHA-256 hashes of the user's passwords.
+	Passwords []string
+
+	// Keys contains the patterns of the keys the user can access.
+	Keys []string
+
+	// Channels contains the patterns of the Pub/Sub channels the user can access.
+	Channels []string
+
+	// Commands contains the rules that allow or deny commands and categories, such as "+@read" or "-flushall".
+	// Later rules take precedence over earlier ones.
+	Commands []string
+}
+
+// newUser creates a user from its rules, starting from a user that is disabled and can do nothing.
+func newUser(name string, rules []string) (*aclUser, error) {
+	return (&aclUser{Name: name}).with(rules)
+}
+
+// hashPassword returns the hex encoded SHA-256 hash of a password.
+func hashPassword(password string) string {
+	hash := sha256.Sum256([]byte(password))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// with returns a copy of the user with rules applied in order.
+func (user *aclUser) with(rules []string) (*aclUser, error) {
+	u := &aclUser{
+		Name:      user.Name,
+		Enabled:   user.Enabled,
+		NoPass:    user.NoPass,
+		Passwords: append([]string{}, user.Passwords...),
+		Keys:      append([]string{}, user.Keys...),
+		Channels:  append([]string{}, user.Channels...),
+		Commands:  append([]string{}, user.Commands...),
+	}
+
+	for _, rule := range rules {
+		err := u.apply(rule)
+		if err != nil {
+			return nil, fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': %s", rule, err)
+		}
+	}
+
+	return u, nil
+}
+
+// apply applies a single rule to the user.
+func (user *aclUser) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		user.Enabled = true
+		return nil
+
+	case "off":
+		user.Enabled = false
+		return nil
+
+	case "nopass":
+		user.NoPass, user.Passwords = true, nil
+		return nil
+
+	case "resetpass":
+		user.NoPass, user.Passwords = false, nil
+		return nil
+
+	case "allkeys":
+		user.Keys = []string{"*"}
+		return nil
+
+	case "resetkeys":
+		user.Keys = nil
+		return nil
+
+	case "allchannels":
+		user.Channels = []string{"*"}
+		return nil
+
+	case "resetchannels":
+		user.Channels = nil
+		return nil
+
+	case "allcommands":
+		return user.apply("+@all")
+
+	case "nocommands":
+		return user.apply("-@all")
+
+	case "reset":
+		*user = aclUser{Name: user.Name}
+		return nil
+	}
+
+	if rule == "" {
+		return errors.New("Syntax error")
+	}
+
+	value := rule[1:]
+
+	switch rule[0] {
+	case '>':
+		user.NoPass = false
+		user.addPassword(hashPassword(value))
+
+	case '<':
+		if !user.removePassword(hashPassword(value)) {
+			return errors.New("no such password")
+		}
+
+	case '#':
+		if _, err := hex.DecodeString(value); err != nil || len(value) != sha256.Size*2 {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+
+		user.NoPass = false
+		user.addPassword(strings.ToLower(value))
+
+	case '!':
+		if !user.removePassword(strings.ToLower(value)) {
+			return errors.New("no such password")
+		}
+
+	case '~':
+		user.Keys = append(user.Keys, value)
+
+	case '&':
+		user.Channels = append(user.Channels, value)
+
+	case '+', '-':
+		name := strings.ToLower(value)
+
+		if strings.HasPrefix(name, "@") {
+			if !knownCategory(name[1:]) {
+				return errors.New("Unknown command or category name in ACL")
+			}
+		} else if command := lookupCommand(name); command == nil || command.Handler == nil {
+			return errors.New("Unknown command or category name in ACL")
+		}
+
+		// Allowing or denying everything overrides every earlier rule.
+		if name == "@all" {
+			user.Commands = nil
+
+			if rule[0] == '-' {
+				return nil
+			}
+		}
+
+		user.Commands = append(user.Commands, rule[:1]+name)
+
+	default:
+		return errors.New("Syntax error")
+	}
+
+	return nil
+}
+
+// knownCategory returns true if an ACL category exists.
+func knownCategory(category string) bool {
+	for _, c := range aclCategories() {
+		if c == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (user *aclUser) addPassword(hash string) {
+	for _, h := range user.Passwords {
+		if h == hash {
+			return
+		}
+	}
+
+	user.Passwords = append(user.Passwords, hash)
+}
+
+func (user *aclUser) removePassword(hash string) bool {
+	for i, h := range user.Passwords {
+		if h == hash {
+			user.Passwords = append(user.Passwords[:i], user.Passwords[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Rules returns the rules that define the user, starting from a user that is disabled and can do nothing.
+func (user *aclUser) Rules() []string {
+	rules := make([]string, 0)
+
+	if user.Enabled {
+		rules = append(rules, "on")
+	} else {
+		rules = append(rules, "off")
+	}
+
+	if user.NoPass {
+		rules = append(rules, "nopass")
+	}
+
+	for _, hash := range user.Passwords {
+		rules = append(rules, "#"+hash)
+	}
+
+	for _, pattern := range user.Keys {
+		rules = append(rules, "~"+pattern)
+	}
+
+	for _, pattern := range user.Channels {
+		rules = append(rules, "&"+pattern)
+	}
+
+	if len(user.Commands) == 0 {
+		rules = append(rules, "-@all")
+	}
+
+	return append(rules, user.Commands...)
+}
+
+// CheckPassword returns true if a password is one of the user's passwords.
+func (user *aclUser) CheckPassword(password string) bool {
+	if user.NoPass {
+		return true
+	}
+
+	hash := []byte(hashPassword(password))
+
+	for _, h := range user.Passwords {
+		if subtle.ConstantTimeCompare(hash, []byte(h)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanRun returns true if the user is allowed to run a command.
+func (user *aclUser) CanRun(command string) bool {
+	allowed := false
+
+	for _, rule := range user.Commands {
+		name := rule[1:]
+
+		if name == command || strings.HasPrefix(name, "@") && inCategory(command, name[1:]) {
+			allowed = rule[0] == '+'
+		}
+	}
+
+	return allowed
+}
+
+// CanAccessKey returns true if the user is allowed to access a key.
+func (user *aclUser) CanAccessKey(key string) bool {
+	for _, pattern := range user.Keys {
+		if db.Match(pattern, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanAccessChannel returns true if the user is allowed to access a channel.
+// A pattern subscription is only allowed if it is one of the user's patterns, unless the user can access every channel.
+func (user *aclUser) CanAccessChannel(channel string, pattern bool) bool {
+	for _, allowed := range user.Channels {
+		if allowed == "*" || allowed == channel || !pattern && db.Match(allowed, channel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// acl contains the ACL users known to this node.
+type acl struct {
+	mutex sync.RWMutex
+
+	// users contains the users by name. Deleted users are absent.
+	users map[string]*aclUser
+
+	// defaultUser is the default user until it is defined with ACL SETUSER.
+	defaultUser *aclUser
+}
+
+// newACL creates the ACL of a node, whose default user can do anything and requires password if it is not empty.
+func newACL(password string) *acl {
+	rules := []string{"on", "nopass", "~*", "&*", "+@all"}
+	if password != "" {
+		rules[1] = ">" + password
+	}
+
+	defaultUser, _ := newUser("default", rules)
+
+	return &acl{
+		users:       make(map[string]*aclUser),
+		defaultUser: defaultUser,
+	}
+}
+
+// Load loads the users stored in the database.
+func (a *acl) Load(database *db.Database) error {
+	users, err := database.Users()
+	if err != nil {
+		return err
+	}
+
+	for name, user := range users {
+		a.cache(name, user)
+	}
+
+	return nil
+}
+
+// Set defines or deletes a user, unless it has been defined or deleted by a newer write.
+func (a *acl) Set(database *db.Database, name string, user db.User) error {
+	written, err := database.SetUser(name, user)
+	if err != nil || !written {
+		return err
+	}
+
+	a.cache(name, user)
+
+	return nil
+}
+
+// cache updates the user in memory once it has been stored.
+func (a *acl) cache(name string, user db.User) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if user.Deleted {
+		delete(a.users, name)
+		return
+	}
+
+	u, err := newUser(name, user.Rules)
+	if err != nil {
+		// Rules are validated on the originating node, so this only happens if nodes disagree on what rules exist.
+		delete(a.users, name)
+		return
+	}
+
+	a.users[name] = u
+}
+
+// User returns a user, or nil if it does not exist.
+func (a *acl) User(name string) *aclUser {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if user, ok := a.users[name]; ok {
+		return user
+	}
+
+	if name == "default" {
+		return a.defaultUser
+	}
+
+	return nil
+}
+
+// Users returns every user, in order of name.
+func (a *acl) Users() []*aclUser {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	users := make([]*aclUser, 0, len(a.users)+1)
+
+	if _, ok := a.users["default"]; !ok {
+		users = append(users, a.defaultUser)
+	}
+
+	for _, user := range a.users {
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+
+	return users
+}
+
+// authenticate checks the credentials given by a client.
+func (server *Server) authenticate(username string, password string) error {
+	user := server.acl.User(username)
+	if user == nil || !user.Enabled || !user.CheckPassword(password) {
+		return errWrongPass
+	}
+
+	return nil
+}
+
+// user returns the user that a client is authenticated as, or nil if it is not authenticated.
+// Clients are authenticated as the default user without AUTH while it does not require a password.
+func (server *Server) user(client *Client) *aclUser {
+	name := client.User
+	if name == "" {
+		name = "default"
+	}
+
+	user := server.acl.User(name)
+	if user == nil || !user.Enabled || client.User == "" && !user.NoPass {
+		return nil
+	}
+
+	return user
+}
+
+// authorize checks that a client is allowed to run a command.
+func (server *Server) authorize(client *Client, command *Command, args []string) error {
+	if command.Flags&CommandNoAuth != 0 {
+		return nil
+	}
+
+	user := server.user(client)
+	if user == nil {
+		return errNoAuth
+	}
+
+	if !user.CanRun(command.Name) {
+		return fmt.Errorf("NOPERM this user has no permissions to run the '%s' command", command.Name)
+	}
+
+	for _, key := range command.ArgumentKeys(args) {
+		if !user.CanAccessKey(key) {
+			return errors.New("NOPERM this user has no permissions to access one of the keys used as arguments")
+		}
+	}
+
+	var channels []string
+
+	switch command.Name {
+	case "publish":
+		if len(args) > 0 {
+			channels = args[:1]
+		}
+
+	case "subscribe", "psubscribe":
+		channels = args
+	}
+
+	for _, channel := range channels {
+		if !user.CanAccessChannel(channel, command.Name == "psubscribe") {
+			return errors.New("NOPERM this user has no permissions to access one of the channels used as arguments")
+		}
+	}
+
+	return nil
+}
+
+// writeUser writes the description of a user returned by ACL GETUSER.
+func writeUser(conn redcon.Conn, user *aclUser) {
+	flags := []string{"off"}
+	if user.Enabled {
+		flags[0] = "on"
+	}
+
+	if user.NoPass {
+		flags = append(flags, "nopass")
+	}
+
+	keys := make([]string, 0, len(user.Keys))
+	for _, pattern := range user.Keys {
+		keys = append(keys, "~"+pattern)
+	}
+
+	channels := make([]string, 0, len(user.Channels))
+	for _, pattern := range user.Channels {
+		channels = append(channels, "&"+pattern)
+	}
+
+	commands := user.Commands
+	if len(commands) == 0 {
+		commands = []string{"-@all"}
+	}
+
+	writeMap(conn, 6)
+
+	conn.WriteBulkString("flags")
+	writeSet(conn, len(flags))
+	for _, flag := range flags {
+		conn.WriteBulkString(flag)
+	}
+
+	conn.WriteBulkString("passwords")
+	conn.WriteArray(len(user.Passwords))
+	for _, hash := range user.Passwords {
+		conn.WriteBulkString(hash)
+	}
+
+	conn.WriteBulkString("commands")
+	conn.WriteBulkString(strings.Join(commands, " "))
+	conn.WriteBulkString("keys")
+	conn.WriteBulkString(strings.Join(keys, " "))
+	conn.WriteBulkString("channels")
+	conn.WriteBulkString(strings.Join(channels, " "))
+	conn.WriteBulkString("selectors")
+	conn.WriteArray(0)
+}
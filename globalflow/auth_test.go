@@ -0,0 +1,105 @@
+package globalflow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestACLUser_Permissions(t *testing.T) {
+	user, err := newUser("alice", []string{"on", ">secret", "~cache:*", "&news", "+@read", "-hgetall", "+set"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !user.CheckPassword("secret") || user.CheckPassword("wrong") {
+		t.Error("unexpected password check")
+	}
+
+	for command, expected := range map[string]bool{
+		"get":      true,
+		"hget":     true,
+		"hgetall":  false,
+		"set":      true,
+		"del":      false,
+		"flushall": false,
+	} {
+		if user.CanRun(command) != expected {
+			t.Errorf("expected CanRun(%q) to be %v", command, expected)
+		}
+	}
+
+	if !user.CanAccessKey("cache:1") || user.CanAccessKey("session:1") {
+		t.Error("unexpected key access")
+	}
+
+	if !user.CanAccessChannel("news", false) || user.CanAccessChannel("n*", true) {
+		t.Error("unexpected channel access")
+	}
+}
+
+func TestACLUser_Rules(t *testing.T) {
+	user, err := newUser("alice", []string{"on", ">secret", "allkeys", "+@all", "-flushall"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := user.Rules()
+
+	expected := []string{"on", "#" + hashPassword("secret"), "~*", "+@all", "-flushall"}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("unexpected rules %v", rules)
+	}
+
+	// The rules recreate the same user on another node, without the password itself.
+	replicated, err := newUser("alice", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(replicated, user) {
+		t.Errorf("expected %+v, got %+v", user, replicated)
+	}
+
+	// Allowing every command overrides earlier rules.
+	user, err = user.with([]string{"nocommands"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.CanRun("get") {
+		t.Error("expected no commands to be allowed")
+	}
+
+	for _, rule := range []string{"+nosuchcommand", "+@nosuchcategory", "<wrong", "#abc", "bogus"} {
+		if _, err := user.with([]string{rule}); err == nil {
+			t.Errorf("expected error for %q", rule)
+		}
+	}
+}
+
+func TestACL_DefaultUser(t *testing.T) {
+	open := newACL("")
+	if user := open.User("default"); user == nil || !user.NoPass || !user.CanRun("flushall") {
+		t.Errorf("expected default user without a password, got %+v", user)
+	}
+
+	protected := newACL("secret")
+	if user := protected.User("default"); user == nil || user.NoPass || !user.CheckPassword("secret") {
+		t.Errorf("expected default user with a password, got %+v", user)
+	}
+
+	if protected.User("alice") != nil {
+		t.Error("expected unknown user to be nil")
+	}
+}
+
+func TestACLUser_WriteCategoryExcludesFlush(t *testing.T) {
+	user, err := newUser("writer", []string{"on", "nopass", "allkeys", "+@write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !user.CanRun("set") || user.CanRun("flushdb") || user.CanRun("flushall") {
+		t.Error("expected the write category to allow SET but not FLUSHDB or FLUSHALL")
+	}
+}
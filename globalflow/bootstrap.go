@@ -0,0 +1,440 @@
+package globalflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// A node that starts without a database file, because it is new or its data was wiped, bootstraps its data from a
+// peer before serving clients. It joins the cluster marked as loading, which keeps it out of the rings of the other
+// nodes, and refuses commands that access the keyspace with a LOADING error. It downloads a consistent snapshot of the
+// database of a healthy peer, preferring one in its own region, over the peer's websocket port, and restores it. The
+// snapshot includes the peer's write-ahead log, so the node knows how far it has received the commands of every origin
+// and requests the commands that the peer has logged since, until none are left. It then joins the rings. Commands
+// broadcast while it was catching up are requested again like any other gap, as the node's sequence numbers show them
+// missing.
+
+const (
+	// snapshotPath serves a snapshot of the node's database.
+	snapshotPath = "/snapshot"
+
+	// walPath serves the sequence number that the write-ahead log has reached for each origin.
+	walPath = "/wal"
+
+	// walEntriesPath serves the commands of an origin recorded in the write-ahead log after a sequence number.
+	walEntriesPath = "/wal/entries"
+)
+
+// bootstrapPeerWait is how long a bootstrapping node waits for a healthy peer to appear, after which it starts with an
+// empty database, as when every node of a new cluster starts at once.
+const bootstrapPeerWait = 10 * time.Second
+
+// bootstrapRetryInterval is how long to wait before retrying a bootstrap that failed.
+const bootstrapRetryInterval = time.Second
+
+// bootstrapCatchUpRounds is the most times a bootstrapping node requests the commands logged since its previous
+// request, before it joins the rings regardless.
+const bootstrapCatchUpRounds = 10
+
+// walEntriesLimit is the most commands returned by a single request for the entries of the write-ahead log.
+const walEntriesLimit = 10000
+
+// errLoading is returned for commands that access the keyspace while the node is bootstrapping its data.
+var errLoading = errors.New("LOADING GlobalFlow is loading the dataset from a peer")
+
+// errEntriesLimit stops iterating over the write-ahead log once a response holds as many entries as it can.
+var errEntriesLimit = errors.New("entries limit reached")
+
+// serveSnapshot writes a snapshot of the database, as of a single read transaction.
+func (server *Server) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	if server.loading.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	// The snapshot may take longer to transfer than the server allows for other requests.
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		logrus.WithError(err).Debug("failed to clear the write deadline of a snapshot")
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	written, err := server.db.WriteSnapshot(w, func(size int64) {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("addr", r.RemoteAddr).Warn("failed to write snapshot")
+		return
+	}
+
+	logrus.WithField("addr", r.RemoteAddr).WithField("bytes", written).Info("Sent snapshot")
+}
+
+// serveWALPositions writes the sequence number that the write-ahead log has reached for each origin.
+func (server *Server) serveWALPositions(w http.ResponseWriter, r *http.Request) {
+	positions, err := server.db.WALPositions()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	err = json.NewEncoder(w).Encode(positions)
+	if err != nil {
+		logrus.WithError(err).Debug("failed to write log positions")
+	}
+}
+
+// serveWALEntries writes the commands of an origin recorded in the write-ahead log after a sequence number, one JSON
+// encoded command per line, in sequence order. At most walEntriesLimit commands are written.
+func (server *Server) serveWALEntries(w http.ResponseWriter, r *http.Request) {
+	origin := r.URL.Query().Get("origin")
+
+	after, err := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+	if origin == "" || err != nil {
+		http.Error(w, "origin and after are required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	encoder := json.NewEncoder(w)
+	count := 0
+
+	err = server.WALSince(origin, after, func(sequence uint64, cmd *CommandMessage) error {
+		if count == walEntriesLimit {
+			return errEntriesLimit
+		}
+
+		cmd.Sequence = sequence
+		count++
+
+		return encoder.Encode(cmd)
+	})
+	if err != nil && err != errEntriesLimit {
+		logrus.WithError(err).WithField("origin", origin).Warn("failed to write log entries")
+	}
+}
+
+// bootstrapSource downloads the data of a peer over its websocket port.
+type bootstrapSource struct {
+	client *http.Client
+
+	// url is the base URL of the peer.
+	url string
+
+	// header returns the headers that identify and authenticate the node downloading the data.
+	header func() http.Header
+}
+
+// bootstrapSource returns a source to download the data of a peer from.
+func (server *Server) bootstrapSource(node *Node) *bootstrapSource {
+	scheme := "http"
+	if server.nodeTLS != nil {
+		scheme = "https"
+	}
+
+	return &bootstrapSource{
+		client: server.nodeHTTPClient(node),
+		url:    fmt.Sprintf("%s://%s", scheme, node.SocketAddress()),
+		header: server.nodeHeader,
+	}
+}
+
+// get requests a path from the peer and returns the response if it succeeded.
+func (source *bootstrapSource) get(p string, query url.Values) (*http.Response, error) {
+	u := source.url + p
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens expire, so every request is authenticated with a new one.
+	for name, values := range source.header() {
+		req.Header[name] = values
+	}
+
+	resp, err := source.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("%s: unexpected status %s", p, resp.Status)
+	}
+
+	return resp, nil
+}
+
+// snapshot downloads a snapshot of the peer's database to a file.
+func (source *bootstrapSource) snapshot(file string) (int64, error) {
+	resp, err := source.get(snapshotPath, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(f, resp.Body)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return written, err
+}
+
+// positions returns the sequence number that the peer's write-ahead log has reached for each origin.
+func (source *bootstrapSource) positions() (map[string]uint64, error) {
+	resp, err := source.get(walPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	positions := make(map[string]uint64)
+
+	err = json.NewDecoder(resp.Body).Decode(&positions)
+	if err != nil {
+		return nil, err
+	}
+
+	return positions, nil
+}
+
+// entries calls fn with the commands of an origin that the peer recorded in its write-ahead log after a sequence
+// number, in sequence order.
+func (source *bootstrapSource) entries(origin string, after uint64, fn func(cmd *CommandMessage) error) error {
+	resp, err := source.get(walEntriesPath, url.Values{
+		"origin": []string{origin},
+		"after":  []string{strconv.FormatUint(after, 10)},
+	})
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+
+	for {
+		cmd := &CommandMessage{}
+
+		err := decoder.Decode(cmd)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = fn(cmd)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// bootstrapPeer returns a random healthy peer to bootstrap from, preferring one in the same region, or nil if there is
+// none.
+func (server *Server) bootstrapPeer() *Node {
+	candidates := server.LocalNodes()
+	if len(candidates) == 0 {
+		candidates = server.RemoteNodes()
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return candidates[rand.Intn(len(candidates))]
+}
+
+// Bootstrap downloads the data of a healthy peer and catches up with the commands that it has logged since, then
+// joins the rings. If no healthy peer appears in time, the node starts with an empty database.
+func (server *Server) Bootstrap() {
+	logrus.Info("Bootstrapping data from a peer")
+
+	deadline := time.Now().Add(bootstrapPeerWait)
+
+	for {
+		peer := server.bootstrapPeer()
+
+		if peer == nil && time.Now().After(deadline) {
+			logrus.Warn("No healthy peer to bootstrap from, starting with an empty database")
+			break
+		}
+
+		if peer != nil {
+			err := server.bootstrapFrom(server.bootstrapSource(peer))
+			if err == nil {
+				break
+			}
+
+			logrus.WithError(err).WithField("node", peer.NodeID()).Warn("failed to bootstrap from peer")
+		}
+
+		select {
+		case <-server.shutdownCh:
+			return
+
+		case <-time.After(bootstrapRetryInterval):
+		}
+	}
+
+	server.loading.Store(false)
+
+	err := server.gossip.SetLoading(false)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to advertise that the node has caught up")
+	}
+
+	logrus.Info("Bootstrap complete")
+}
+
+// bootstrapFrom restores a snapshot of a peer's database and replays the commands that the peer has logged since.
+func (server *Server) bootstrapFrom(source *bootstrapSource) error {
+	configuration := server.container.Configuration
+	file := path.Join(configuration.DatabasePath, fmt.Sprintf("%s.snapshot", configuration.NodeID))
+
+	defer os.Remove(file)
+
+	size, err := source.snapshot(file)
+	if err != nil {
+		return err
+	}
+
+	err = server.db.RestoreSnapshot(file)
+	if err != nil {
+		return err
+	}
+
+	logrus.WithField("bytes", size).Info("Restored snapshot")
+
+	err = server.acl.Load(server.db)
+	if err != nil {
+		return err
+	}
+
+	positions, err := server.db.WALPositions()
+	if err != nil {
+		return err
+	}
+
+	server.sequences.Restore(positions)
+
+	// The clock is moved past the most recent command of every origin, so that the node's own writes are not ordered
+	// before the writes it has restored.
+	for origin, position := range positions {
+		if position == 0 {
+			continue
+		}
+
+		err := server.WALSince(origin, position-1, func(sequence uint64, cmd *CommandMessage) error {
+			server.clock.Set(cmd.Time)
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return server.catchUp(source)
+}
+
+// catchUp replays the commands that a peer has logged beyond the sequence numbers the node has received, until none
+// are left or bootstrapCatchUpRounds requests have been made.
+func (server *Server) catchUp(source *bootstrapSource) error {
+	for round := 0; round < bootstrapCatchUpRounds; round++ {
+		positions, err := source.positions()
+		if err != nil {
+			return err
+		}
+
+		replayed := 0
+
+		for origin, position := range positions {
+			after, _ := server.sequences.Position(origin)
+
+			for after < position {
+				last := after
+
+				err := source.entries(origin, after, func(cmd *CommandMessage) error {
+					if server.replayCommand(cmd) {
+						replayed++
+					}
+
+					last = cmd.Sequence
+
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+
+				// The peer no longer has the remaining commands, which are left to anti-entropy.
+				if last == after {
+					break
+				}
+
+				after = last
+			}
+		}
+
+		logrus.WithField("commands", replayed).Debug("Replayed logged commands")
+
+		if replayed == 0 {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// replayCommand applies a command downloaded from a peer's write-ahead log, unless it has already been received.
+// Returns true if it was applied.
+func (server *Server) replayCommand(cmd *CommandMessage) bool {
+	server.clock.Set(cmd.Time)
+
+	if !server.sequences.Receive(cmd.Originator, cmd.Sequence, time.Now()) {
+		return false
+	}
+
+	_, err := server.processCommand(cmd)
+	if err == errFlushed {
+		logrus.WithField("command", cmd.Command).Debug("discarded command made before a flush")
+	} else if err != nil {
+		logrus.WithError(err).WithField("command", cmd.Command).Warn("failed to apply command")
+	}
+
+	return true
+}
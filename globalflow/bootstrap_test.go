@@ -0,0 +1,80 @@
+package globalflow
+
+import (
+	"globalflow/globalflow/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_Bootstrap(t *testing.T) {
+	peer := newTestServer(t, "a")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(snapshotPath, peer.serveSnapshot)
+	mux.HandleFunc(walPath, peer.serveWALPositions)
+	mux.HandleFunc(walEntriesPath, peer.serveWALEntries)
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	commit := func(command string, arguments ...string) {
+		cmd := &CommandMessage{Time: peer.clock.Get(), Command: command, Arguments: arguments, Originator: "c"}
+
+		if _, err := peer.commitCommand(peer.db, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commit("set", "a", "1")
+	commit("sadd", "s", "x")
+
+	node := newTestServer(t, "b")
+	node.loading.Store(true)
+
+	source := &bootstrapSource{client: http.DefaultClient, url: ts.URL, header: node.nodeHeader}
+
+	if err := node.bootstrapFrom(source); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := node.db.Get(db.Time(time.Now().UnixMilli()), "a"); err != nil || value != "1" {
+		t.Errorf("expected the snapshot to be restored, got %q, %v", value, err)
+	}
+
+	if position, missing := node.sequences.Position("c"); position != 2 || missing != 0 {
+		t.Errorf("expected position 2 with none missing, got %d with %d", position, missing)
+	}
+
+	// The clock is moved past the restored commands.
+	if node.clock.Current() <= 2 {
+		t.Errorf("expected the clock to move past 2, got %d", node.clock.Current())
+	}
+
+	// Commands logged after the snapshot are replayed.
+	commit("set", "a", "2")
+	commit("sadd", "s", "y")
+
+	if err := node.catchUp(source); err != nil {
+		t.Fatal(err)
+	}
+
+	now := db.Time(time.Now().UnixMilli())
+
+	if value, err := node.db.Get(now, "a"); err != nil || value != "2" {
+		t.Errorf("expected the logged command to be replayed, got %q, %v", value, err)
+	}
+
+	if members, err := node.db.SMembers(now, "s"); err != nil || len(members) != 2 {
+		t.Errorf("expected two members, got %v, %v", members, err)
+	}
+
+	if position, _ := node.sequences.Position("c"); position != 4 {
+		t.Errorf("expected position 4, got %d", position)
+	}
+
+	if positions, err := node.db.WALPositions(); err != nil || positions["c"] != 4 {
+		t.Errorf("expected the replayed commands to be logged, got %v, %v", positions, err)
+	}
+}
@@ -0,0 +1,190 @@
+package globalflow
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/redcon"
+	"globalflow/globalflow/db"
+	"sync"
+)
+
+// Client contains the state of a Redis connection.
+type Client struct {
+	// ID uniquely identifies the connection on this node.
+	ID int64
+
+	// Name is the name given to the connection with CLIENT SETNAME or HELLO.
+	Name string
+
+	// User is the name of the ACL user that the connection has authenticated as.
+	// It is empty until the connection authenticates, when it acts as the default user if that requires no password.
+	User string
+
+	// Protocol is the version of RESP the connection speaks, which is RESP2 unless it switches with HELLO.
+	Protocol int
+
+	// DB is the logical database selected with SELECT.
+	DB int
+
+	// Multi is true between MULTI and EXEC or DISCARD, while commands are being queued.
+	Multi bool
+
+	// Queued contains the commands queued since MULTI.
+	Queued []redcon.Command
+
+	// Aborted is true if a command could not be queued, in which case EXEC discards the transaction.
+	Aborted bool
+
+	// Caching is "yes" or "no" if the previous command was CLIENT CACHING, and applies to the next command only.
+	Caching string
+
+	// Detached is true once the connection has been detached from the command handler.
+	Detached bool
+}
+
+// pushConn is a Redis connection that has been detached from the command handler, so that messages can be pushed to it
+// from other goroutines.
+type pushConn struct {
+	// mutex must be held when writing to the connection.
+	mutex sync.Mutex
+
+	conn redcon.DetachedConn
+
+	// protocol is the protocol of the connection as of the last reply written to it. It is guarded by the mutex.
+	protocol int
+
+	// subscriber is the connection's subscriptions, or nil if it was detached for client-side caching.
+	subscriber *subscriber
+}
+
+// write writes to the connection and flushes it.
+func (p *pushConn) write(fn func(conn redcon.DetachedConn)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	fn(p.conn)
+
+	err := p.conn.Flush()
+	if err != nil {
+		logrus.WithError(err).Debug("failed to write to connection")
+	}
+}
+
+// client returns the state of a Redis connection, creating it when the connection is accepted.
+func (server *Server) client(conn redcon.Conn) *Client {
+	if client, ok := conn.Context().(*Client); ok {
+		return client
+	}
+
+	client := &Client{
+		ID:       server.clientIDs.Add(1),
+		Protocol: protocolRESP2,
+	}
+	conn.SetContext(client)
+
+	server.connectedClients.Add(1)
+
+	return client
+}
+
+// closeClient releases the state of a Redis connection once it has closed.
+// A detached connection is released by whatever serves it instead, once it closes.
+func (server *Server) closeClient(conn redcon.Conn) {
+	if client, ok := conn.Context().(*Client); ok && !client.Detached {
+		server.release(client)
+	}
+}
+
+// release releases the state of a Redis connection that has closed.
+func (server *Server) release(client *Client) {
+	server.watches.Unwatch(client)
+	server.tracking.Remove(client)
+
+	server.connectedClients.Add(-1)
+}
+
+// detach detaches a connection from the command handler, so that messages can be pushed to it.
+// The connection must be registered with the tracking table once it is ready to receive invalidation messages.
+func (server *Server) detach(conn redcon.Conn, client *Client) *pushConn {
+	client.Detached = true
+
+	return &pushConn{conn: conn.Detach(), protocol: client.Protocol}
+}
+
+// Request is the context that a Redis command runs in.
+type Request struct {
+	server *Server
+	client *Client
+
+	// database is the client's selected database. It is bound to the transaction while EXEC runs.
+	database *db.Database
+
+	// batch collects the commands replicated by a write or by EXEC, which are recorded in the write-ahead log in the
+	// transaction that applies them and broadcast once it has committed. EXEC broadcasts them together.
+	// It is nil for commands that do not write, which broadcast as soon as they have applied a command.
+	batch *[]*CommandMessage
+
+	// name is the name of the command being run, in lower case.
+	name string
+
+	// cmd is the command as it was sent.
+	cmd redcon.Command
+}
+
+// Server returns the server that the command runs on.
+func (req *Request) Server() *Server {
+	return req.server
+}
+
+// Client returns the connection that sent the command.
+func (req *Request) Client() *Client {
+	return req.client
+}
+
+// Database returns the client's selected database.
+func (req *Request) Database() *db.Database {
+	return req.database
+}
+
+// Name returns the name of the command being run, in lower case.
+func (req *Request) Name() string {
+	return req.name
+}
+
+// Apply applies a command to the request's database without broadcasting it.
+func (req *Request) Apply(message *CommandMessage) (interface{}, error) {
+	// Outside of a transaction, the command is recorded in the write-ahead log as it is applied.
+	if req.batch == nil {
+		result, err := req.server.commitCommand(req.database, message)
+		if err != nil {
+			return nil, err
+		}
+
+		req.server.invalidate(message, req.client)
+
+		return result, nil
+	}
+
+	// The writes of a transaction are invalidated once it has committed.
+	return req.server.applyCommand(req.database, message)
+}
+
+// Broadcast broadcasts a command that has been applied, or adds it to the transaction's batch.
+func (req *Request) Broadcast(message *CommandMessage) error {
+	if req.batch != nil {
+		*req.batch = append(*req.batch, message)
+		return nil
+	}
+
+	return req.server.broadcast(message)
+}
+
+// Replicate applies a command locally and broadcasts it to the rest of the cluster.
+// The command is not broadcast if it fails locally.
+func (req *Request) Replicate(message *CommandMessage) (interface{}, error) {
+	result, err := req.Apply(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, req.Broadcast(message)
+}
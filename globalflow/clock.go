@@ -1,44 +1,120 @@
 package globalflow
 
-import "sync"
-
+import (
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Time is a hybrid logical clock time. Its high bits are a physical time in Unix milliseconds, and its low
+// logicalBits bits a counter that orders the events within the same millisecond, so that times stay close to the wall
+// clock while still ordering every event after the events that caused it.
 type Time int64
 
-// LamportCLock contains an implementation of a Lamport clock.
-type LamportCLock struct {
-	// time is the current time.
+// logicalBits is the number of bits of a Time that hold its logical counter.
+const logicalBits = 16
+
+// NewTime creates a time from a physical time in Unix milliseconds and a logical counter.
+func NewTime(millis int64, logical uint16) Time {
+	return Time(millis<<logicalBits | int64(logical))
+}
+
+// Millis returns the physical part of the time, in Unix milliseconds.
+func (t Time) Millis() int64 {
+	return int64(t) >> logicalBits
+}
+
+// Logical returns the logical part of the time.
+func (t Time) Logical() uint16 {
+	return uint16(t & (1<<logicalBits - 1))
+}
+
+// Physical returns the physical part of the time.
+func (t Time) Physical() time.Time {
+	return time.UnixMilli(t.Millis())
+}
+
+// HybridClock contains an implementation of a hybrid logical clock.
+type HybridClock struct {
+	// time is the most recent time issued or received by the clock.
 	time Time
 
-	// Mutex is a mutex for Vectors.
-	// It must be held when writing to Vectors.
+	// now returns the wall clock time.
+	now func() time.Time
+
+	// maxOffset is how far ahead of the wall clock a reference time can move the clock. Zero does not limit it.
+	maxOffset time.Duration
+
+	// mu is a mutex for time.
 	mu sync.Mutex
 }
 
-// NewClock creates a new clock.
-func NewClock() *LamportCLock {
-	return &LamportCLock{}
+// NewClock creates a new clock that reference times move at most maxOffset ahead of the wall clock.
+func NewClock(maxOffset time.Duration) *HybridClock {
+	return &HybridClock{now: time.Now, maxOffset: maxOffset}
 }
 
-// Get gets the current time.
-func (clock *LamportCLock) Get() Time {
+// wall returns the wall clock time as a Time.
+func (clock *HybridClock) wall() Time {
+	return NewTime(clock.now().UnixMilli(), 0)
+}
+
+// tick advances the clock past the most recent time, to the wall clock time if it is later.
+// A logical counter that overflows carries into the physical time, which keeps the clock monotonic.
+// The mutex must be held.
+func (clock *HybridClock) tick() Time {
+	if wall := clock.wall(); wall > clock.time {
+		clock.time = wall
+	} else {
+		clock.time++
+	}
+
+	return clock.time
+}
+
+// Get advances the clock for a local event, such as sending a message, and returns its time.
+func (clock *HybridClock) Get() Time {
 	clock.mu.Lock()
 	defer clock.mu.Unlock()
 
-	clock.time++
+	return clock.tick()
+}
+
+// Current returns the current time without advancing the clock. It is the wall clock time, unless the clock has issued
+// or received a later time.
+func (clock *HybridClock) Current() Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+
+	if wall := clock.wall(); wall > clock.time {
+		return wall
+	}
 
 	return clock.time
 }
 
-// Set updates the current time with a reference time from another node.
-func (clock *LamportCLock) Set(time Time) {
+// Set updates the current time with a reference time from another node, so that later events are ordered after it.
+// A reference time further ahead of the wall clock than the maximum offset is clamped to it, so that a node whose clock
+// is far ahead cannot move the clock, against which keys expire, forward for good.
+func (clock *HybridClock) Set(reference Time) {
 	clock.mu.Lock()
 	defer clock.mu.Unlock()
 
-	if time > clock.time {
-		clock.time = time
+	wall := clock.wall()
+	limit := wall.Millis() + clock.maxOffset.Milliseconds()
+
+	if clock.maxOffset > 0 && reference.Millis() > limit {
+		logrus.WithField("offset", reference.Physical().Sub(wall.Physical())).
+			Warn("Reference time is too far ahead of the wall clock, clamping it")
+
+		reference = NewTime(limit, 0)
+	}
+
+	if reference > clock.time {
+		clock.time = reference
 	}
 
-	clock.time++
+	clock.tick()
 }
 
 // VectorClock contains an implementation of a vector clock.
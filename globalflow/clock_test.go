@@ -1,14 +1,94 @@
 package globalflow
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
-func TestLamportClockIncrements(t *testing.T) {
-	clock := NewClock()
+// newTestClock creates a clock whose wall clock is a variable.
+func newTestClock(wall *time.Time) *HybridClock {
+	clock := NewClock(0)
+	clock.now = func() time.Time { return *wall }
+
+	return clock
+}
+
+func TestHybridClockIncrements(t *testing.T) {
+	wall := time.UnixMilli(1000)
+	clock := newTestClock(&wall)
 
 	time1 := clock.Get()
 	time2 := clock.Get()
 
-	if time2 <= time1 {
-		t.Errorf("expected time2 to be greater than time1")
+	if time1 != NewTime(1000, 0) || time2 != NewTime(1000, 1) {
+		t.Errorf("expected the logical counter to order events within a millisecond, got %d and %d", time1, time2)
+	}
+
+	wall = time.UnixMilli(1005)
+
+	if time3 := clock.Get(); time3 != NewTime(1005, 0) {
+		t.Errorf("expected the clock to follow the wall clock, got %d", time3)
+	}
+}
+
+func TestHybridClockCurrentDoesNotIncrement(t *testing.T) {
+	wall := time.UnixMilli(1000)
+	clock := newTestClock(&wall)
+
+	if clock.Current() != NewTime(1000, 0) {
+		t.Errorf("expected the wall clock time, got %d", clock.Current())
+	}
+
+	clock.Set(NewTime(1000, 5))
+
+	if clock.Current() != NewTime(1000, 6) || clock.Current() != NewTime(1000, 6) {
+		t.Errorf("expected the current time to be 1000.6, got %d", clock.Current())
+	}
+}
+
+func TestHybridClockSkew(t *testing.T) {
+	wall := time.UnixMilli(1000)
+	clock := newTestClock(&wall)
+
+	// A time from a node whose clock is ahead moves the clock past it, and later events are ordered after it even
+	// though the wall clock is behind.
+	clock.Set(NewTime(2000, 3))
+
+	if next := clock.Get(); next != NewTime(2000, 5) {
+		t.Errorf("expected 2000.5, got %d.%d", next.Millis(), next.Logical())
+	}
+
+	// A time from a node whose clock is behind does not move the clock back.
+	clock.Set(NewTime(500, 0))
+
+	if next := clock.Get(); next != NewTime(2000, 7) {
+		t.Errorf("expected 2000.7, got %d.%d", next.Millis(), next.Logical())
+	}
+
+	// Once the wall clock catches up, the clock follows it again.
+	wall = time.UnixMilli(2001)
+
+	if next := clock.Get(); next != NewTime(2001, 0) {
+		t.Errorf("expected 2001.0, got %d.%d", next.Millis(), next.Logical())
+	}
+}
+
+func TestHybridClockMaxOffset(t *testing.T) {
+	wall := time.UnixMilli(1000)
+	clock := newTestClock(&wall)
+	clock.maxOffset = time.Second
+
+	// A time from a node whose clock is hours ahead only moves the clock up to the maximum offset.
+	clock.Set(NewTime(1000+int64(3*time.Hour/time.Millisecond), 0))
+
+	if current := clock.Current(); current != NewTime(2000, 1) {
+		t.Errorf("expected 2000.1, got %d.%d", current.Millis(), current.Logical())
+	}
+
+	// Times within the maximum offset are not clamped.
+	clock.Set(NewTime(2000, 5))
+
+	if current := clock.Current(); current != NewTime(2000, 6) {
+		t.Errorf("expected 2000.6, got %d.%d", current.Millis(), current.Logical())
 	}
 }
@@ -0,0 +1,1026 @@
+package globalflow
+
+// builtinCommands are the commands that every node supports.
+var builtinCommands = []*Command{
+	// Connection
+	{
+		Name:       "hello",
+		Arity:      -1,
+		Flags:      CommandNoAuth | CommandLoading,
+		Categories: []string{"connection"},
+		Group:      "connection",
+		Summary:    "Handshakes with the server, optionally switching protocol and authenticating.",
+		Handler:    helloCommand,
+	},
+	{
+		Name:       "auth",
+		Arity:      -2,
+		Flags:      CommandNoAuth | CommandLoading,
+		Categories: []string{"connection"},
+		Group:      "connection",
+		Summary:    "Authenticates the connection.",
+		Handler:    authCommand,
+	},
+	{
+		Name:       "client",
+		Arity:      -2,
+		Flags:      CommandLoading,
+		Categories: []string{"connection"},
+		Group:      "connection",
+		Summary:    "Inspects and configures the connection.",
+		Handler:    clientCommand,
+	},
+	{
+		Name:       "select",
+		Arity:      2,
+		Flags:      CommandLoading,
+		Categories: []string{"connection"},
+		Group:      "connection",
+		Summary:    "Changes the selected database.",
+		Handler:    selectCommand,
+	},
+	{
+		Name:       "ping",
+		Arity:      -1,
+		Flags:      CommandLoading,
+		Categories: []string{"connection"},
+		Group:      "connection",
+		Summary:    "Returns the server's liveliness response.",
+		Handler:    pingCommand,
+	},
+	{
+		Name:       "quit",
+		Arity:      -1,
+		Flags:      CommandNoAuth | CommandLoading,
+		Categories: []string{"connection"},
+		Group:      "connection",
+		Summary:    "Closes the connection.",
+		Handler:    quitCommand,
+	},
+
+	// Server
+	{
+		Name:    "acl",
+		Arity:   -2,
+		Flags:   CommandAdmin,
+		Group:   "server",
+		Summary: "Manages the ACL users.",
+		Handler: aclCommand,
+	},
+	{
+		Name:       "info",
+		Arity:      -1,
+		Flags:      CommandLoading,
+		Categories: []string{"dangerous"},
+		Group:      "server",
+		Summary:    "Returns information and statistics about the server.",
+		Handler:    infoCommand,
+	},
+	{
+		Name:       "command",
+		Arity:      -1,
+		Flags:      CommandLoading,
+		Categories: []string{"connection"},
+		Group:      "server",
+		Summary:    "Returns information about commands.",
+		Handler:    commandCommand,
+	},
+	{
+		Name:       "flushdb",
+		Arity:      -1,
+		Flags:      CommandWrite | CommandAdmin,
+		Categories: []string{"keyspace"},
+		Group:      "server",
+		Summary:    "Removes all keys from the selected database.",
+		Handler:    flushdbCommand,
+		Apply:      applyFlushDB,
+	},
+	{
+		Name:        "flushall",
+		Arity:       -1,
+		Flags:       CommandWrite | CommandAdmin,
+		Categories:  []string{"keyspace"},
+		Group:       "server",
+		Summary:     "Removes all keys from all databases.",
+		Handler:     flushdbCommand,
+		applyGlobal: applyFlushAll,
+	},
+	{
+		Name:        "aclsetuser",
+		Arity:       -2,
+		applyGlobal: applyACLSetUser,
+	},
+	{
+		Name:        "acldeluser",
+		Arity:       -1,
+		applyGlobal: applyACLDelUser,
+	},
+
+	// Transactions
+	{
+		Name:       "multi",
+		Arity:      1,
+		Categories: []string{"transaction"},
+		Group:      "transactions",
+		Summary:    "Starts a transaction.",
+		Handler:    multiCommand,
+	},
+	{
+		Name:        "exec",
+		Arity:       1,
+		Categories:  []string{"transaction"},
+		Group:       "transactions",
+		Summary:     "Executes all commands in a transaction.",
+		Handler:     execCommand,
+		applyGlobal: applyExec,
+	},
+	{
+		Name:       "discard",
+		Arity:      1,
+		Categories: []string{"transaction"},
+		Group:      "transactions",
+		Summary:    "Discards a transaction.",
+		Handler:    discardCommand,
+	},
+	{
+		Name:       "watch",
+		Arity:      -2,
+		Flags:      CommandNoMulti,
+		Categories: []string{"transaction"},
+		Keys:       allKeys,
+		Group:      "transactions",
+		Summary:    "Monitors changes to keys to determine the execution of a transaction.",
+		Handler:    watchCommand,
+	},
+	{
+		Name:       "unwatch",
+		Arity:      1,
+		Categories: []string{"transaction"},
+		Group:      "transactions",
+		Summary:    "Forgets about watched keys of a transaction.",
+		Handler:    unwatchCommand,
+	},
+
+	// Strings
+	{
+		Name:       "get",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"string"},
+		Keys:       firstKey,
+		Group:      "string",
+		Summary:    "Returns the string value of a key.",
+		Handler:    getCommand,
+	},
+	{
+		Name:       "set",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"string"},
+		Keys:       firstKey,
+		Group:      "string",
+		Summary:    "Sets the string value of a key, ignoring its type.",
+		Handler:    setCommand,
+		Apply:      applySet,
+	},
+
+	// Keys
+	{
+		Name:       "del",
+		Arity:      -2,
+		Flags:      CommandWrite,
+		Categories: []string{"keyspace"},
+		Keys:       allKeys,
+		Group:      "generic",
+		Summary:    "Deletes one or more keys.",
+		Handler:    delCommand,
+		Apply:      applyDel,
+	},
+	{
+		Name:       "unlink",
+		Arity:      -2,
+		Flags:      CommandWrite,
+		Categories: []string{"keyspace"},
+		Keys:       allKeys,
+		Group:      "generic",
+		Summary:    "Deletes one or more keys.",
+		Handler:    delCommand,
+	},
+	{
+		Name:       "exists",
+		Arity:      -2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"keyspace"},
+		Keys:       allKeys,
+		Group:      "generic",
+		Summary:    "Determines whether one or more keys exist.",
+		Handler:    existsCommand,
+	},
+	{
+		Name:       "type",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"keyspace"},
+		Keys:       firstKey,
+		Group:      "generic",
+		Summary:    "Determines the type of value stored at a key.",
+		Handler:    typeCommand,
+	},
+	{
+		Name:       "keys",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"keyspace", "dangerous"},
+		Group:      "generic",
+		Summary:    "Returns all key names that match a pattern.",
+		Handler:    keysCommand,
+	},
+	{
+		Name:       "scan",
+		Arity:      -2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"keyspace"},
+		Group:      "generic",
+		Summary:    "Iterates over the key names in the database.",
+		Handler:    scanCommand,
+	},
+	{
+		Name:       "dbsize",
+		Arity:      1,
+		Flags:      CommandReadOnly,
+		Categories: []string{"keyspace"},
+		Group:      "generic",
+		Summary:    "Returns the number of keys in the database.",
+		Handler:    dbsizeCommand,
+	},
+	{
+		Name:       "randomkey",
+		Arity:      1,
+		Flags:      CommandReadOnly,
+		Categories: []string{"keyspace"},
+		Group:      "generic",
+		Summary:    "Returns a random key name from the database.",
+		Handler:    randomkeyCommand,
+	},
+	{
+		Name:       "rename",
+		Arity:      3,
+		Flags:      CommandWrite,
+		Categories: []string{"keyspace"},
+		Keys:       firstTwoKeys,
+		Group:      "generic",
+		Summary:    "Renames a key and overwrites the destination.",
+		Handler:    renameCommand,
+		Apply:      applyRename,
+	},
+	{
+		Name:       "renamenx",
+		Arity:      3,
+		Flags:      CommandWrite,
+		Categories: []string{"keyspace"},
+		Keys:       firstTwoKeys,
+		Group:      "generic",
+		Summary:    "Renames a key only when the target key name doesn't exist.",
+		Handler:    renameCommand,
+	},
+	{
+		Name:       "expire",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"keyspace"},
+		Keys:       firstKey,
+		Group:      "generic",
+		Summary:    "Sets the expiration time of a key in seconds.",
+		Handler:    expireCommand,
+	},
+	{
+		Name:       "pexpire",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"keyspace"},
+		Keys:       firstKey,
+		Group:      "generic",
+		Summary:    "Sets the expiration time of a key in milliseconds.",
+		Handler:    expireCommand,
+	},
+	{
+		Name:       "expireat",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"keyspace"},
+		Keys:       firstKey,
+		Group:      "generic",
+		Summary:    "Sets the expiration time of a key to a Unix timestamp.",
+		Handler:    expireCommand,
+	},
+	{
+		Name:       "pexpireat",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"keyspace"},
+		Keys:       firstKey,
+		Group:      "generic",
+		Summary:    "Sets the expiration time of a key to a Unix milliseconds timestamp.",
+		Handler:    expireCommand,
+		Apply:      applyPExpireAt,
+	},
+	{
+		Name:       "ttl",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"keyspace"},
+		Keys:       firstKey,
+		Group:      "generic",
+		Summary:    "Returns the expiration time in seconds of a key.",
+		Handler:    ttlCommand,
+	},
+	{
+		Name:       "pttl",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"keyspace"},
+		Keys:       firstKey,
+		Group:      "generic",
+		Summary:    "Returns the expiration time in milliseconds of a key.",
+		Handler:    ttlCommand,
+	},
+	{
+		Name:       "persist",
+		Arity:      2,
+		Flags:      CommandWrite,
+		Categories: []string{"keyspace"},
+		Keys:       firstKey,
+		Group:      "generic",
+		Summary:    "Removes the expiration time of a key.",
+		Handler:    persistCommand,
+		Apply:      applyPersist,
+	},
+
+	// Lists
+	{
+		Name:       "lpush",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Prepends one or more elements to a list.",
+		Handler:    lpushCommand,
+		Apply:      applyPush,
+	},
+	{
+		Name:       "rpush",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Appends one or more elements to a list.",
+		Handler:    lpushCommand,
+		Apply:      applyPush,
+	},
+	{
+		Name:       "lpushx",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Prepends one or more elements to a list only when the list exists.",
+		Handler:    lpushCommand,
+		Apply:      applyPush,
+	},
+	{
+		Name:       "rpushx",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Appends one or more elements to a list only when the list exists.",
+		Handler:    lpushCommand,
+		Apply:      applyPush,
+	},
+	{
+		Name:       "lpop",
+		Arity:      -2,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Returns the first elements in a list after removing them.",
+		Handler:    lpopCommand,
+		Apply:      applyPop,
+	},
+	{
+		Name:       "rpop",
+		Arity:      -2,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Returns and removes the last elements of a list.",
+		Handler:    lpopCommand,
+		Apply:      applyPop,
+	},
+	{
+		Name:       "lrange",
+		Arity:      4,
+		Flags:      CommandReadOnly,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Returns a range of elements from a list.",
+		Handler:    lrangeCommand,
+	},
+	{
+		Name:       "llen",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Returns the length of a list.",
+		Handler:    llenCommand,
+	},
+	{
+		Name:       "lindex",
+		Arity:      3,
+		Flags:      CommandReadOnly,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Returns an element from a list by its index.",
+		Handler:    lindexCommand,
+	},
+	{
+		Name:       "lset",
+		Arity:      4,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Sets the value of an element in a list by its index.",
+		Handler:    lsetCommand,
+		Apply:      applyLSet,
+	},
+	{
+		Name:       "ltrim",
+		Arity:      4,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Removes elements from both ends of a list.",
+		Handler:    lsetCommand,
+		Apply:      applyLTrim,
+	},
+	{
+		Name:       "lrem",
+		Arity:      4,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Removes elements from a list.",
+		Handler:    lremCommand,
+		Apply:      applyLRem,
+	},
+	{
+		Name:       "linsert",
+		Arity:      5,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstKey,
+		Group:      "list",
+		Summary:    "Inserts an element before or after another element in a list.",
+		Handler:    linsertCommand,
+		Apply:      applyLInsert,
+	},
+	{
+		Name:       "lmove",
+		Arity:      5,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstTwoKeys,
+		Group:      "list",
+		Summary:    "Returns an element after popping it from one list and pushing it to another.",
+		Handler:    lmoveCommand,
+		Apply:      applyLMove,
+	},
+	{
+		Name:       "rpoplpush",
+		Arity:      3,
+		Flags:      CommandWrite,
+		Categories: []string{"list"},
+		Keys:       firstTwoKeys,
+		Group:      "list",
+		Summary:    "Returns the last element of a list after removing and pushing it to another list.",
+		Handler:    lmoveCommand,
+	},
+
+	// Hashes
+	{
+		Name:       "hset",
+		Arity:      -4,
+		Flags:      CommandWrite,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Creates or modifies the value of a field in a hash.",
+		Handler:    hsetCommand,
+		Apply:      applyHSet,
+	},
+	{
+		Name:       "hmset",
+		Arity:      -4,
+		Flags:      CommandWrite,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Sets the values of multiple fields.",
+		Handler:    hsetCommand,
+	},
+	{
+		Name:       "hsetnx",
+		Arity:      4,
+		Flags:      CommandWrite,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Sets the value of a field in a hash only when the field doesn't exist.",
+		Handler:    hsetnxCommand,
+		Apply:      applyHSetNX,
+	},
+	{
+		Name:       "hincrby",
+		Arity:      4,
+		Flags:      CommandWrite,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Increments the integer value of a field in a hash by a number.",
+		Handler:    hincrbyCommand,
+		Apply:      applyHIncrBy,
+	},
+	{
+		Name:       "hdel",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Deletes one or more fields and their values from a hash.",
+		Handler:    hdelCommand,
+		Apply:      applyHDel,
+	},
+	{
+		Name:       "hget",
+		Arity:      3,
+		Flags:      CommandReadOnly,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Returns the value of a field in a hash.",
+		Handler:    hgetCommand,
+	},
+	{
+		Name:       "hmget",
+		Arity:      -3,
+		Flags:      CommandReadOnly,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Returns the values of all fields in a hash.",
+		Handler:    hmgetCommand,
+	},
+	{
+		Name:       "hgetall",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Returns all fields and values in a hash.",
+		Handler:    hgetallCommand,
+	},
+	{
+		Name:       "hkeys",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Returns all fields in a hash.",
+		Handler:    hgetallCommand,
+	},
+	{
+		Name:       "hvals",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Returns all values in a hash.",
+		Handler:    hgetallCommand,
+	},
+	{
+		Name:       "hlen",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Returns the number of fields in a hash.",
+		Handler:    hgetallCommand,
+	},
+	{
+		Name:       "hexists",
+		Arity:      3,
+		Flags:      CommandReadOnly,
+		Categories: []string{"hash"},
+		Keys:       firstKey,
+		Group:      "hash",
+		Summary:    "Determines whether a field exists in a hash.",
+		Handler:    hexistsCommand,
+	},
+
+	// Sets
+	{
+		Name:       "sadd",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"set"},
+		Keys:       firstKey,
+		Group:      "set",
+		Summary:    "Adds one or more members to a set.",
+		Handler:    saddCommand,
+		Apply:      applySAdd,
+	},
+	{
+		Name:       "srem",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"set"},
+		Keys:       firstKey,
+		Group:      "set",
+		Summary:    "Removes one or more members from a set.",
+		Handler:    sremCommand,
+		Apply:      applySRem,
+	},
+	{
+		Name:       "spop",
+		Arity:      -2,
+		Flags:      CommandWrite,
+		Categories: []string{"set"},
+		Keys:       firstKey,
+		Group:      "set",
+		Summary:    "Returns one or more random members from a set after removing them.",
+		Handler:    spopCommand,
+	},
+	{
+		Name:       "srandmember",
+		Arity:      -2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"set"},
+		Keys:       firstKey,
+		Group:      "set",
+		Summary:    "Returns one or more random members from a set.",
+		Handler:    spopCommand,
+	},
+	{
+		Name:       "smembers",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"set"},
+		Keys:       firstKey,
+		Group:      "set",
+		Summary:    "Returns all members of a set.",
+		Handler:    smembersCommand,
+	},
+	{
+		Name:       "sunion",
+		Arity:      -2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"set"},
+		Keys:       allKeys,
+		Group:      "set",
+		Summary:    "Returns the union of multiple sets.",
+		Handler:    smembersCommand,
+	},
+	{
+		Name:       "sinter",
+		Arity:      -2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"set"},
+		Keys:       allKeys,
+		Group:      "set",
+		Summary:    "Returns the intersect of multiple sets.",
+		Handler:    smembersCommand,
+	},
+	{
+		Name:       "sdiff",
+		Arity:      -2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"set"},
+		Keys:       allKeys,
+		Group:      "set",
+		Summary:    "Returns the difference of multiple sets.",
+		Handler:    smembersCommand,
+	},
+	{
+		Name:       "sismember",
+		Arity:      3,
+		Flags:      CommandReadOnly,
+		Categories: []string{"set"},
+		Keys:       firstKey,
+		Group:      "set",
+		Summary:    "Determines whether a member belongs to a set.",
+		Handler:    sismemberCommand,
+	},
+	{
+		Name:       "smismember",
+		Arity:      -3,
+		Flags:      CommandReadOnly,
+		Categories: []string{"set"},
+		Keys:       firstKey,
+		Group:      "set",
+		Summary:    "Determines whether multiple members belong to a set.",
+		Handler:    sismemberCommand,
+	},
+	{
+		Name:       "scard",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"set"},
+		Keys:       firstKey,
+		Group:      "set",
+		Summary:    "Returns the number of members in a set.",
+		Handler:    scardCommand,
+	},
+
+	// Sorted sets
+	{
+		Name:       "zadd",
+		Arity:      -4,
+		Flags:      CommandWrite,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Adds one or more members to a sorted set, or updates their scores.",
+		Handler:    zaddCommand,
+		Apply:      applyZAdd,
+	},
+	{
+		Name:       "zincrby",
+		Arity:      4,
+		Flags:      CommandWrite,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Increments the score of a member in a sorted set.",
+		Handler:    zincrbyCommand,
+		Apply:      applyZIncrBy,
+	},
+	{
+		Name:       "zrem",
+		Arity:      -3,
+		Flags:      CommandWrite,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Removes one or more members from a sorted set.",
+		Handler:    zremCommand,
+		Apply:      applyZRem,
+	},
+	{
+		Name:       "zpopmin",
+		Arity:      -2,
+		Flags:      CommandWrite,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns the lowest-scoring members from a sorted set after removing them.",
+		Handler:    zpopCommand,
+	},
+	{
+		Name:       "zpopmax",
+		Arity:      -2,
+		Flags:      CommandWrite,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns the highest-scoring members from a sorted set after removing them.",
+		Handler:    zpopCommand,
+	},
+	{
+		Name:       "zremrangebyscore",
+		Arity:      4,
+		Flags:      CommandWrite,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Removes members in a sorted set within a range of scores.",
+		Handler:    zremrangeCommand,
+	},
+	{
+		Name:       "zremrangebyrank",
+		Arity:      4,
+		Flags:      CommandWrite,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Removes members in a sorted set within a range of indexes.",
+		Handler:    zremrangeCommand,
+	},
+	{
+		Name:       "zremrangebylex",
+		Arity:      4,
+		Flags:      CommandWrite,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Removes members in a sorted set within a lexicographical range.",
+		Handler:    zremrangeCommand,
+	},
+	{
+		Name:       "zrange",
+		Arity:      -4,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns members in a sorted set within a range of indexes.",
+		Handler:    zrangeCommand,
+	},
+	{
+		Name:       "zrevrange",
+		Arity:      -4,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns members in a sorted set within a range of indexes in reverse order.",
+		Handler:    zrangeCommand,
+	},
+	{
+		Name:       "zrangebyscore",
+		Arity:      -4,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns members in a sorted set within a range of scores.",
+		Handler:    zrangeCommand,
+	},
+	{
+		Name:       "zrevrangebyscore",
+		Arity:      -4,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns members in a sorted set within a range of scores in reverse order.",
+		Handler:    zrangeCommand,
+	},
+	{
+		Name:       "zrangebylex",
+		Arity:      -4,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns members in a sorted set within a lexicographical range.",
+		Handler:    zrangeCommand,
+	},
+	{
+		Name:       "zrevrangebylex",
+		Arity:      -4,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns members in a sorted set within a lexicographical range in reverse order.",
+		Handler:    zrangeCommand,
+	},
+	{
+		Name:       "zcount",
+		Arity:      4,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns the count of members in a sorted set that have scores within a range.",
+		Handler:    zcountCommand,
+	},
+	{
+		Name:       "zlexcount",
+		Arity:      4,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns the number of members in a sorted set within a lexicographical range.",
+		Handler:    zcountCommand,
+	},
+	{
+		Name:       "zscore",
+		Arity:      3,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns the score of a member in a sorted set.",
+		Handler:    zscoreCommand,
+	},
+	{
+		Name:       "zmscore",
+		Arity:      -3,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns the score of one or more members in a sorted set.",
+		Handler:    zscoreCommand,
+	},
+	{
+		Name:       "zcard",
+		Arity:      2,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns the number of members in a sorted set.",
+		Handler:    zcardCommand,
+	},
+	{
+		Name:       "zrank",
+		Arity:      -3,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns the index of a member in a sorted set ordered by ascending scores.",
+		Handler:    zrankCommand,
+	},
+	{
+		Name:       "zrevrank",
+		Arity:      -3,
+		Flags:      CommandReadOnly,
+		Categories: []string{"sortedset"},
+		Keys:       firstKey,
+		Group:      "sorted-set",
+		Summary:    "Returns the index of a member in a sorted set ordered by descending scores.",
+		Handler:    zrankCommand,
+	},
+
+	// Pub/Sub
+	{
+		Name:    "subscribe",
+		Arity:   -2,
+		Flags:   CommandPubSub | CommandNoMulti | CommandLoading,
+		Group:   "pubsub",
+		Summary: "Listens for messages published to channels.",
+		Handler: subscribeCommand,
+	},
+	{
+		Name:    "psubscribe",
+		Arity:   -2,
+		Flags:   CommandPubSub | CommandNoMulti | CommandLoading,
+		Group:   "pubsub",
+		Summary: "Listens for messages published to channels that match one or more patterns.",
+		Handler: subscribeCommand,
+	},
+	{
+		Name:    "unsubscribe",
+		Arity:   -1,
+		Flags:   CommandPubSub | CommandLoading,
+		Group:   "pubsub",
+		Summary: "Stops listening to messages posted to channels.",
+		Handler: unsubscribeCommand,
+	},
+	{
+		Name:    "punsubscribe",
+		Arity:   -1,
+		Flags:   CommandPubSub | CommandLoading,
+		Group:   "pubsub",
+		Summary: "Stops listening to messages published to channels that match one or more patterns.",
+		Handler: unsubscribeCommand,
+	},
+	{
+		Name:    "publish",
+		Arity:   3,
+		Flags:   CommandPubSub | CommandLoading,
+		Group:   "pubsub",
+		Summary: "Posts a message to a channel.",
+		Handler: publishCommand,
+	},
+	{
+		Name:    "pubsub",
+		Arity:   -2,
+		Flags:   CommandPubSub | CommandLoading,
+		Group:   "pubsub",
+		Summary: "Inspects the state of the Pub/Sub subsystem.",
+		Handler: pubsubCommand,
+	},
+}
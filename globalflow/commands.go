@@ -0,0 +1,445 @@
+package globalflow
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tidwall/redcon"
+	"globalflow/globalflow/db"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Commands are described by a registry, which drives their dispatch, their replication, access control, client-side
+// caching and the COMMAND command. Writes are replicated by command name and applied on every node by the command's
+// Apply function, so every node must have the same commands registered.
+
+// CommandFlags describe how a command behaves.
+type CommandFlags int
+
+const (
+	// CommandWrite marks a command that may modify the keyspace.
+	// Its handler runs in a database transaction, in which the commands it replicates are recorded in the write-ahead log.
+	CommandWrite CommandFlags = 1 << iota
+
+	// CommandReadOnly marks a command that reads the keyspace without modifying it.
+	CommandReadOnly
+
+	// CommandAdmin marks an administrative command.
+	CommandAdmin
+
+	// CommandPubSub marks a Pub/Sub command.
+	CommandPubSub
+
+	// CommandNoAuth marks a command that connections can run before they authenticate.
+	CommandNoAuth
+
+	// CommandNoMulti marks a command that is refused between MULTI and EXEC.
+	CommandNoMulti
+
+	// CommandLoading marks a command that can run while the node is bootstrapping its data from a peer, as it does not
+	// access the keyspace.
+	CommandLoading
+)
+
+// commandFlagNames are the names of the flags in replies to COMMAND.
+var commandFlagNames = []struct {
+	flag CommandFlags
+	name string
+}{
+	{CommandWrite, "write"},
+	{CommandReadOnly, "readonly"},
+	{CommandAdmin, "admin"},
+	{CommandPubSub, "pubsub"},
+	{CommandNoAuth, "no_auth"},
+	{CommandNoMulti, "no_multi"},
+	{CommandLoading, "loading"},
+}
+
+// KeySpec gives the positions of the keys of a command among its arguments, counting the command name as position 0
+// as COMMAND INFO does. Last is negative to count from the end of the arguments. The zero value means no keys.
+type KeySpec struct {
+	First int
+	Last  int
+	Step  int
+}
+
+var (
+	noKeys       = KeySpec{}
+	firstKey     = KeySpec{First: 1, Last: 1, Step: 1}
+	firstTwoKeys = KeySpec{First: 1, Last: 2, Step: 1}
+	allKeys      = KeySpec{First: 1, Last: -1, Step: 1}
+)
+
+// CommandHandler runs a command sent by a Redis connection and writes its reply.
+// args are the arguments of the command, not including its name, and have been checked against the command's arity.
+type CommandHandler func(req *Request, conn redcon.Conn, args []string)
+
+// ApplyFunc applies a write that was replicated with a command's name to the logical database it was made in.
+// It runs on every node, including the one that received the command, and returns the result that the receiving node
+// replies with.
+type ApplyFunc func(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error)
+
+// Command describes a Redis command.
+type Command struct {
+	// Name is the name of the command, in lower case.
+	Name string
+
+	// Arity is the number of arguments of the command, including its name.
+	// A negative arity is the minimum number of arguments.
+	Arity int
+
+	Flags CommandFlags
+
+	// Categories are the ACL categories of the command, in addition to those that follow from its flags.
+	Categories []string
+
+	Keys KeySpec
+
+	// Group and Summary describe the command in replies to COMMAND DOCS.
+	Group   string
+	Summary string
+
+	// Handler runs the command. Commands without a handler can be replicated, but cannot be sent by clients.
+	Handler CommandHandler
+
+	// Apply applies the writes replicated with the command's name. It is nil if the command is never replicated.
+	Apply ApplyFunc
+
+	// applyGlobal applies a replicated write that is not made in a single logical database.
+	applyGlobal func(server *Server, root *db.Database, cmd *CommandMessage) (interface{}, error)
+}
+
+// ArgumentKeys returns the keys among the arguments of the command, not including its name.
+func (command *Command) ArgumentKeys(args []string) []string {
+	spec := command.Keys
+	if spec.First == 0 || spec.First > len(args) {
+		return nil
+	}
+
+	last := spec.Last
+	if last < 0 {
+		last += len(args) + 1
+	}
+
+	if last > len(args) {
+		last = len(args)
+	}
+
+	step := spec.Step
+	if step < 1 {
+		step = 1
+	}
+
+	var keys []string
+	for i := spec.First; i <= last; i += step {
+		keys = append(keys, args[i-1])
+	}
+
+	return keys
+}
+
+// categories returns the ACL categories of the command, not including "all", in sorted order.
+// As in Redis, writes are in the write category, reads in the read category, and administrative commands are admin and
+// dangerous. Administrative commands that write, such as FLUSHALL, are left out of the write category, so that a user
+// allowed to write is not allowed to wipe every database.
+func (command *Command) categories() []string {
+	seen := make(map[string]bool)
+
+	for _, category := range command.Categories {
+		seen[category] = true
+	}
+
+	if command.Flags&CommandWrite != 0 && command.Flags&CommandAdmin == 0 {
+		seen["write"] = true
+	}
+
+	if command.Flags&CommandReadOnly != 0 {
+		seen["read"] = true
+	}
+
+	if command.Flags&CommandAdmin != 0 {
+		seen["admin"] = true
+		seen["dangerous"] = true
+	}
+
+	if command.Flags&CommandPubSub != 0 {
+		seen["pubsub"] = true
+	}
+
+	categories := make([]string, 0, len(seen))
+	for category := range seen {
+		categories = append(categories, category)
+	}
+
+	sort.Strings(categories)
+
+	return categories
+}
+
+// inCategory returns true if the command belongs to an ACL category.
+func (command *Command) inCategory(category string) bool {
+	if category == "all" {
+		return true
+	}
+
+	for _, c := range command.categories() {
+		if c == category {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkArity returns an error if the command cannot be sent with a number of arguments, including its name.
+func (command *Command) checkArity(count int) error {
+	if (command.Arity > 0 && count != command.Arity) || (command.Arity < 0 && count < -command.Arity) {
+		return errWrongArity(command.Name)
+	}
+
+	return nil
+}
+
+// errWrongArity returns the error replied to a command sent with the wrong number of arguments.
+func errWrongArity(name string) error {
+	return errors.New("ERR wrong number of arguments for '" + name + "' command")
+}
+
+var (
+	commandsMutex sync.RWMutex
+
+	// commands contains the registered commands by name.
+	commands = make(map[string]*Command)
+)
+
+// RegisterCommand adds a command to the registry, so that applications embedding GlobalFlow can add commands
+// implemented in Go. It must be called before the server starts, with the same commands on every node.
+// A command that writes replicates a CommandMessage with its name, which is applied by its Apply function.
+func RegisterCommand(command *Command) error {
+	if command.Name == "" || command.Name != strings.ToLower(command.Name) {
+		return fmt.Errorf("command name %q must be lower case", command.Name)
+	}
+
+	if command.Arity == 0 {
+		return fmt.Errorf("command %s has no arity", command.Name)
+	}
+
+	if command.Handler == nil && command.Apply == nil && command.applyGlobal == nil {
+		return fmt.Errorf("command %s has neither a handler nor an apply function", command.Name)
+	}
+
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+
+	if _, ok := commands[command.Name]; ok {
+		return fmt.Errorf("command %s is already registered", command.Name)
+	}
+
+	commands[command.Name] = command
+
+	return nil
+}
+
+// lookupCommand returns a registered command, or nil if there is no command with the name.
+func lookupCommand(name string) *Command {
+	commandsMutex.RLock()
+	defer commandsMutex.RUnlock()
+
+	return commands[strings.ToLower(name)]
+}
+
+// clientCommands returns the commands that clients can send, in order of name.
+func clientCommands() []*Command {
+	commandsMutex.RLock()
+	defer commandsMutex.RUnlock()
+
+	list := make([]*Command, 0, len(commands))
+
+	for _, command := range commands {
+		if command.Handler != nil {
+			list = append(list, command)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+// checkCommand returns the command that a client sent, or the error to reply with if the command is unknown or has the
+// wrong number of arguments.
+func checkCommand(cmd redcon.Command) (*Command, error) {
+	command := lookupCommand(string(cmd.Args[0]))
+	if command == nil || command.Handler == nil {
+		return nil, errors.New("ERR unknown command '" + string(cmd.Args[0]) + "'")
+	}
+
+	err := command.checkArity(len(cmd.Args))
+	if err != nil {
+		return nil, err
+	}
+
+	return command, nil
+}
+
+func init() {
+	for _, command := range builtinCommands {
+		err := RegisterCommand(command)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// commandCommand runs COMMAND.
+func commandCommand(req *Request, conn redcon.Conn, args []string) {
+	if len(args) == 0 {
+		list := clientCommands()
+
+		conn.WriteArray(len(list))
+
+		for _, command := range list {
+			writeCommandInfo(conn, command)
+		}
+
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	default:
+		conn.WriteError("ERR unknown subcommand '" + args[0] + "'. Try COMMAND HELP.")
+
+	case "count":
+		conn.WriteInt(len(clientCommands()))
+
+	case "list":
+		list := clientCommands()
+
+		conn.WriteArray(len(list))
+
+		for _, command := range list {
+			conn.WriteBulkString(command.Name)
+		}
+
+	case "info":
+		list := commandsNamed(args[1:])
+
+		conn.WriteArray(len(list))
+
+		for _, command := range list {
+			if command == nil {
+				writeNull(conn)
+				continue
+			}
+
+			writeCommandInfo(conn, command)
+		}
+
+	case "docs":
+		list := make([]*Command, 0)
+
+		// Unknown commands are left out of the reply.
+		for _, command := range commandsNamed(args[1:]) {
+			if command != nil {
+				list = append(list, command)
+			}
+		}
+
+		writeMap(conn, len(list))
+
+		for _, command := range list {
+			conn.WriteBulkString(command.Name)
+
+			writeMap(conn, 2)
+			conn.WriteBulkString("summary")
+			conn.WriteBulkString(command.Summary)
+			conn.WriteBulkString("group")
+			conn.WriteBulkString(command.Group)
+		}
+
+	case "getkeys":
+		if len(args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'command|getkeys' command")
+			return
+		}
+
+		command := lookupCommand(args[1])
+		if command == nil || command.Handler == nil {
+			conn.WriteError("ERR Invalid command specified")
+			return
+		}
+
+		if command.checkArity(len(args)-1) != nil {
+			conn.WriteError("ERR Invalid number of arguments specified for command")
+			return
+		}
+
+		keys := command.ArgumentKeys(args[2:])
+		if len(keys) == 0 {
+			conn.WriteError("ERR The command has no key arguments")
+			return
+		}
+
+		writeStrings(conn, keys)
+	}
+}
+
+// commandsNamed returns the commands with the given names, or every command if no names are given.
+// The command is nil for a name that is not a command.
+func commandsNamed(names []string) []*Command {
+	if len(names) == 0 {
+		return clientCommands()
+	}
+
+	list := make([]*Command, 0, len(names))
+
+	for _, name := range names {
+		command := lookupCommand(name)
+		if command != nil && command.Handler == nil {
+			command = nil
+		}
+
+		list = append(list, command)
+	}
+
+	return list
+}
+
+// writeCommandInfo writes the description of a command returned by COMMAND and COMMAND INFO.
+func writeCommandInfo(conn redcon.Conn, command *Command) {
+	flags := make([]string, 0)
+	for _, f := range commandFlagNames {
+		if command.Flags&f.flag != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+
+	categories := command.categories()
+
+	conn.WriteArray(10)
+	conn.WriteBulkString(command.Name)
+	conn.WriteInt(command.Arity)
+
+	writeSet(conn, len(flags))
+	for _, flag := range flags {
+		conn.WriteString(flag)
+	}
+
+	conn.WriteInt(command.Keys.First)
+	conn.WriteInt(command.Keys.Last)
+	conn.WriteInt(command.Keys.Step)
+
+	writeSet(conn, len(categories)+1)
+	conn.WriteString("@all")
+	for _, category := range categories {
+		conn.WriteString("@" + category)
+	}
+
+	// Command tips, key specifications and subcommands are not described.
+	conn.WriteArray(0)
+	conn.WriteArray(0)
+	conn.WriteArray(0)
+}
@@ -0,0 +1,98 @@
+package globalflow
+
+import (
+	"github.com/tidwall/redcon"
+	"reflect"
+	"testing"
+)
+
+func TestCommand_ArgumentKeys(t *testing.T) {
+	tests := []struct {
+		command  string
+		args     []string
+		expected []string
+	}{
+		{"get", []string{"a"}, []string{"a"}},
+		{"del", []string{"a", "b"}, []string{"a", "b"}},
+		{"lmove", []string{"a", "b", "left", "right"}, []string{"a", "b"}},
+		{"publish", []string{"news", "hello"}, nil},
+	}
+
+	for _, test := range tests {
+		if keys := lookupCommand(test.command).ArgumentKeys(test.args); !reflect.DeepEqual(keys, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.command, test.expected, keys)
+		}
+	}
+
+	// Keys can be interleaved with other arguments.
+	pairs := &Command{Keys: KeySpec{First: 1, Last: -1, Step: 2}}
+	if keys := pairs.ArgumentKeys([]string{"a", "1", "b", "2"}); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("expected [a b], got %v", keys)
+	}
+
+	// A negative last key counts from the end of the arguments.
+	command := &Command{Keys: KeySpec{First: 1, Last: -2, Step: 1}}
+	if keys := command.ArgumentKeys([]string{"a", "b", "10"}); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("expected [a b], got %v", keys)
+	}
+}
+
+func TestCommand_Categories(t *testing.T) {
+	command := &Command{Flags: CommandWrite | CommandAdmin, Categories: []string{"keyspace"}}
+
+	if categories := command.categories(); !reflect.DeepEqual(categories, []string{"admin", "dangerous", "keyspace"}) {
+		t.Errorf("unexpected categories %v", categories)
+	}
+
+	if !command.inCategory("all") || command.inCategory("read") || command.inCategory("write") {
+		t.Error("unexpected category membership")
+	}
+}
+
+func TestCommand_CheckArity(t *testing.T) {
+	fixed := &Command{Name: "get", Arity: 2}
+	if fixed.checkArity(2) != nil || fixed.checkArity(3) == nil {
+		t.Error("expected a fixed arity to be exact")
+	}
+
+	variadic := &Command{Name: "del", Arity: -2}
+	if variadic.checkArity(1) == nil || variadic.checkArity(5) != nil {
+		t.Error("expected a negative arity to be a minimum")
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	handler := func(req *Request, conn redcon.Conn, args []string) {}
+
+	t.Cleanup(func() {
+		commandsMutex.Lock()
+		delete(commands, "test.echo")
+		commandsMutex.Unlock()
+	})
+
+	invalid := []*Command{
+		{Name: "Test.Echo", Arity: 2, Handler: handler},
+		{Name: "test.echo", Handler: handler},
+		{Name: "test.echo", Arity: 2},
+		{Name: "get", Arity: 2, Handler: handler},
+	}
+
+	for _, command := range invalid {
+		if err := RegisterCommand(command); err == nil {
+			t.Errorf("expected an error registering %+v", command)
+		}
+	}
+
+	err := RegisterCommand(&Command{Name: "test.echo", Arity: 2, Flags: CommandReadOnly, Handler: handler})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lookupCommand("TEST.ECHO") == nil {
+		t.Error("expected the command to be registered")
+	}
+
+	if _, err := checkCommand(redcon.Command{Args: [][]byte{[]byte("test.echo")}}); err == nil {
+		t.Error("expected an arity error")
+	}
+}
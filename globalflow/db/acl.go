@@ -0,0 +1,80 @@
+package db
+
+import "encoding/json"
+
+// BucketACL contains the ACL users, which are shared by every logical database.
+const BucketACL = "ACL"
+
+// User is the stored definition of an ACL user.
+type User struct {
+	// Rules are the ACL rules that define the user, starting from a reset user.
+	Rules []string `json:"rules,omitempty"`
+
+	// Deleted is true if the user has been deleted. The record is kept so that an older definition is not restored.
+	Deleted bool `json:"deleted,omitempty"`
+
+	// Version is the version of the write that defined or deleted the user.
+	Version Version `json:"version"`
+}
+
+// SetUser defines or deletes an ACL user, unless it has been defined or deleted by a newer write.
+// Returns false if the write was ignored.
+func (db *Database) SetUser(name string, user User) (bool, error) {
+	written := false
+
+	err := db.update(func(tx *txn) error {
+		bucket := tx.Bucket([]byte(BucketACL))
+
+		if v := bucket.Get([]byte(name)); v != nil {
+			var current User
+
+			err := json.Unmarshal(v, &current)
+			if err != nil {
+				return err
+			}
+
+			if !user.Version.After(current.Version) {
+				return nil
+			}
+		}
+
+		encoded, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+
+		written = true
+
+		return bucket.Put([]byte(name), encoded)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return written, nil
+}
+
+// Users returns every stored ACL user by name, including deleted users.
+func (db *Database) Users() (map[string]User, error) {
+	users := make(map[string]User)
+
+	err := db.view(func(tx *txn) error {
+		return tx.Bucket([]byte(BucketACL)).ForEach(func(k, v []byte) error {
+			var user User
+
+			err := json.Unmarshal(v, &user)
+			if err != nil {
+				return err
+			}
+
+			users[string(k)] = user
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
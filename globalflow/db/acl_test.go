@@ -0,0 +1,42 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDatabase_SetUserIgnoresOlderVersions(t *testing.T) {
+	db := newTestDatabase(t)
+
+	deleted := User{Deleted: true, Version: Version{Time: 5, Node: "b"}}
+
+	written, err := db.SetUser("alice", deleted)
+	if err != nil || !written {
+		t.Fatalf("expected user to be deleted, got %v (%v)", written, err)
+	}
+
+	// A definition made before the deletion must not restore the user.
+	written, err = db.SetUser("alice", User{Rules: []string{"on"}, Version: Version{Time: 4, Node: "a"}})
+	if err != nil || written {
+		t.Errorf("expected older definition to be ignored, got %v (%v)", written, err)
+	}
+
+	users, err := db.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(users, map[string]User{"alice": deleted}) {
+		t.Errorf("unexpected users %v", users)
+	}
+
+	// The ACL is shared by every logical database.
+	other, err := db.Select(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if users, err := other.Users(); err != nil || len(users) != 1 {
+		t.Errorf("expected 1 user in database 3, got %v (%v)", users, err)
+	}
+}
@@ -1,6 +1,9 @@
 package db
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 type DataType int
 type Time int64
@@ -8,13 +11,105 @@ type Time int64
 const (
 	DataTypeString DataType = iota
 	DataTypeList
+	DataTypeHash
+	DataTypeSet
+	DataTypeSortedSet
 )
 
+// String returns the name of the data type as reported by the Redis TYPE command.
+func (t DataType) String() string {
+	switch t {
+	case DataTypeString:
+		return "string"
+	case DataTypeList:
+		return "list"
+	case DataTypeHash:
+		return "hash"
+	case DataTypeSet:
+		return "set"
+	case DataTypeSortedSet:
+		return "zset"
+	}
+
+	return "none"
+}
+
 type Data struct {
-	Type        DataType `json:"type"`
-	StringValue string   `json:"stringValue"`
-	ListValue   []string `json:"listValue"`
-	ExpiresAt   Time     `json:"expiresAt"`
+	Type        DataType             `json:"type"`
+	StringValue string               `json:"stringValue"`
+	ListValue   []string             `json:"listValue"`
+	HashValue   map[string]HashField `json:"hashValue,omitempty"`
+	SetValue    map[string][]string  `json:"setValue,omitempty"`
+	SetRemoved  []string             `json:"setRemoved,omitempty"`
+
+	// SortedSetSize is the number of members of a sorted set, whose members are stored in BucketSortedSets.
+	SortedSetSize int `json:"sortedSetSize,omitempty"`
+
+	ExpiresAt Time `json:"expiresAt"`
+
+	// ExpiryVersion is the version of the most recent write of the key's deadline, by SET, EXPIRE, PERSIST, RENAME or
+	// DEL. It is versioned separately from the value, so that a deadline and a value written concurrently are both kept
+	// whatever order they arrive in, and older deadlines arriving later are discarded.
+	ExpiryVersion Version `json:"expiryVersion"`
+
+	// Version is the version of the most recent write of the key, or of its deletion. Older writes of the whole key
+	// arriving later are discarded, so that every node keeps the value of the same write.
+	Version Version `json:"version"`
+
+	// Deleted marks the tombstone of a key removed by DEL or by expiry, so that an older write arriving later does not
+	// resurrect it. Tombstones are treated as missing until they are purged.
+	Deleted bool `json:"deleted,omitempty"`
+
+	// DeletedAt is the wall clock time at which the key was deleted, which the purge of its tombstone is based on.
+	DeletedAt Time `json:"deletedAt,omitempty"`
+}
+
+// Version identifies a write by the hybrid logical clock time it was made and the node it originated on.
+type Version struct {
+	Time Time   `json:"time"`
+	Node string `json:"node"`
+}
+
+// After returns true if the version is newer than another version.
+// Versions with the same time are ordered by node ID, so that every node picks the same winner.
+func (version Version) After(other Version) bool {
+	if version.Time != other.Time {
+		return version.Time > other.Time
+	}
+
+	return version.Node > other.Node
+}
+
+// Empty returns true for the tombstones of deleted keys and for collections that only contain tombstones.
+// They are kept so that older writes arriving later can be discarded, but are otherwise treated as missing.
+func (data Data) Empty() bool {
+	if data.Deleted {
+		return true
+	}
+
+	switch data.Type {
+	case DataTypeHash:
+		for _, field := range data.HashValue {
+			if !field.Deleted {
+				return false
+			}
+		}
+
+		return true
+
+	case DataTypeSet:
+		return len(data.SetValue) == 0
+
+	case DataTypeSortedSet:
+		return data.SortedSetSize == 0
+	}
+
+	return false
+}
+
+// Expired returns true if the data has a deadline that has passed at the given time.
+func (data Data) Expired(now Time) bool {
+	return data.ExpiresAt != 0 && data.ExpiresAt <= now
 }
 
 func (data Data) Encode() ([]byte, error) {
@@ -24,3 +119,8 @@ func (data Data) Encode() ([]byte, error) {
 func (data *Data) Decode(encoded []byte) error {
 	return json.Unmarshal(encoded, data)
 }
+
+// String returns a tag that uniquely identifies the write.
+func (version Version) String() string {
+	return fmt.Sprintf("%d@%s", version.Time, version.Node)
+}
@@ -1,18 +1,67 @@
 package db
 
-import bolt "go.etcd.io/bbolt"
+import (
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"strconv"
+)
 
 // Database is a wrapper around BoltDB that provides a higher-level API.
 // It implements both a Redis-style KV store and a WAL.
 type Database struct {
 	// db is the underlying BoltDB database.
 	db *bolt.DB
+
+	// index is the logical database that is read and written through this handle.
+	index int
+
+	// tx is the transaction that the handle is bound to by Batch.
+	// If it is nil, every operation runs in its own transaction.
+	tx *bolt.Tx
 }
 
 const BucketData = "DATA"
 const BucketWAL = "WAL"
 
+// BucketExpiry is an index of keys that have a deadline, ordered by deadline.
+const BucketExpiry = "EXPIRY"
+
+// BucketTombstones is an index of the tombstones of deleted keys, ordered by the time they were deleted at.
+const BucketTombstones = "TOMBSTONES"
+
+// BucketMeta contains metadata about each logical database, such as the version of its most recent flush.
+const BucketMeta = "META"
+
+// Databases is the number of logical databases, which clients choose between with the Redis SELECT command.
+const Databases = 16
+
+// ErrDatabaseIndexOutOfRange is returned when selecting a logical database that does not exist.
+var ErrDatabaseIndexOutOfRange = errors.New("DB index is out of range")
+
+// bucketName returns the name of a bucket of a logical database.
+// Database 0 uses the plain bucket names, so that files created before logical databases existed remain readable.
+func bucketName(name string, index int) []byte {
+	if index == 0 {
+		return []byte(name)
+	}
+
+	return []byte(name + ":" + strconv.Itoa(index))
+}
+
+// txn is a transaction on a single logical database.
+type txn struct {
+	*bolt.Tx
+
+	index int
+}
+
+// bucket returns a bucket of the logical database.
+func (tx *txn) bucket(name string) *bolt.Bucket {
+	return tx.Bucket(bucketName(name, tx.index))
+}
+
 // NewDatabase creates or opens a database file at the given path.
+// The returned handle reads and writes logical database 0.
 func NewDatabase(path string) (*Database, error) {
 	db, err := bolt.Open(path, 0600, nil)
 	if err != nil {
@@ -20,24 +69,143 @@ func NewDatabase(path string) (*Database, error) {
 	}
 
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(BucketData))
+		_, err := tx.CreateBucketIfNotExists([]byte(BucketWAL))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(BucketHints))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists([]byte(BucketMeta))
 		if err != nil {
 			return err
 		}
 
-		_, err = tx.CreateBucketIfNotExists([]byte(BucketWAL))
+		_, err = tx.CreateBucketIfNotExists([]byte(BucketACL))
 		if err != nil {
 			return err
 		}
 
+		for index := 0; index < Databases; index++ {
+			err := createBuckets(&txn{Tx: tx, index: index})
+			if err != nil {
+				return err
+			}
+		}
+
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	return &Database{
 		db: db,
 	}, nil
 }
 
+// createBuckets creates the buckets of a logical database if they do not exist.
+func createBuckets(tx *txn) error {
+	_, err := tx.CreateBucketIfNotExists(bucketName(BucketData, tx.index))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.CreateBucketIfNotExists(bucketName(BucketSortedSets, tx.index))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.CreateBucketIfNotExists(bucketName(BucketTombstones, tx.index))
+	if err != nil {
+		return err
+	}
+
+	if tx.bucket(BucketExpiry) == nil {
+		_, err = tx.CreateBucket(bucketName(BucketExpiry, tx.index))
+		if err != nil {
+			return err
+		}
+
+		return rebuildExpiryIndex(tx)
+	}
+
+	return nil
+}
+
+// Select returns a handle for a logical database, sharing the underlying file.
+func (db *Database) Select(index int) (*Database, error) {
+	if index < 0 || index >= Databases {
+		return nil, ErrDatabaseIndexOutOfRange
+	}
+
+	return &Database{
+		db:    db.db,
+		index: index,
+		tx:    db.tx,
+	}, nil
+}
+
+// Index returns the logical database that is read and written through this handle.
+func (db *Database) Index() int {
+	return db.index
+}
+
+// Batch runs fn with a handle whose operations all run in a single read-write transaction, which is committed if fn
+// returns nil and rolled back otherwise. Readers never observe some of the batch's writes without the others.
+// If the handle is already bound to a transaction, fn runs in that transaction.
+func (db *Database) Batch(fn func(db *Database) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return fn(&Database{
+			db:    db.db,
+			index: db.index,
+			tx:    tx,
+		})
+	})
+}
+
+// update runs a read-write transaction on the logical database.
+func (db *Database) update(fn func(tx *txn) error) error {
+	if db.tx != nil {
+		return fn(&txn{Tx: db.tx, index: db.index})
+	}
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		return fn(&txn{Tx: tx, index: db.index})
+	})
+}
+
+// view runs a read-only transaction on the logical database.
+func (db *Database) view(fn func(tx *txn) error) error {
+	if db.tx != nil {
+		return fn(&txn{Tx: db.tx, index: db.index})
+	}
+
+	return db.db.View(func(tx *bolt.Tx) error {
+		return fn(&txn{Tx: tx, index: db.index})
+	})
+}
+
+// FileSize returns the size of the database file in bytes, which is shared by every logical database.
+func (db *Database) FileSize() (int64, error) {
+	var size int64
+
+	err := db.view(func(tx *txn) error {
+		size = tx.Size()
+
+		return nil
+	})
+
+	return size, err
+}
+
 func (db *Database) Close() error {
 	return db.db.Close()
 }
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// expiryIndexKey returns the key of an entry in the expiry index.
+// Entries are ordered by deadline, then by key.
+func expiryIndexKey(expiresAt Time, key string) []byte {
+	k := make([]byte, 8+len(key))
+
+	binary.BigEndian.PutUint64(k, uint64(expiresAt))
+	copy(k[8:], key)
+
+	return k
+}
+
+// expire writes the deadline of a key with a version, unless it has been written by a newer write.
+// Returns false if the deadline was discarded.
+func (data *Data) expire(version Version, expiresAt Time) bool {
+	if data.ExpiryVersion.After(version) {
+		return false
+	}
+
+	data.ExpiresAt, data.ExpiryVersion = expiresAt, version
+
+	return true
+}
+
+// rebuildExpiryIndex indexes the deadlines of every key in the data bucket.
+// It is used to populate the index of a database created before the index existed.
+func rebuildExpiryIndex(tx *txn) error {
+	index := tx.bucket(BucketExpiry)
+
+	return tx.bucket(BucketData).ForEach(func(k, v []byte) error {
+		var data Data
+
+		err := data.Decode(v)
+		if err != nil {
+			return err
+		}
+
+		if data.ExpiresAt == 0 || data.Deleted {
+			return nil
+		}
+
+		return index.Put(expiryIndexKey(data.ExpiresAt, string(k)), []byte{})
+	})
+}
+
+// DeleteExpired deletes up to limit keys whose deadline has passed, oldest deadline first, replacing each of them with
+// a tombstone of the write that expired, so that older writes arriving later are still discarded.
+// Returns the keys that were deleted.
+func (db *Database) DeleteExpired(now Time, limit int) ([]string, error) {
+	deleted := make([]string, 0)
+
+	err := db.update(func(tx *txn) error {
+		index := tx.bucket(BucketExpiry)
+		if index == nil {
+			panic(fmt.Errorf("bucket %s not found", BucketExpiry))
+		}
+
+		data := tx.bucket(BucketData)
+
+		// Collect the entries first, as the cursor must not be used while the bucket is modified.
+		entries := make([][]byte, 0, limit)
+
+		c := index.Cursor()
+		for k, _ := c.First(); k != nil && len(entries) < limit; k, _ = c.Next() {
+			if Time(binary.BigEndian.Uint64(k[:8])) > now {
+				break
+			}
+
+			entries = append(entries, append([]byte{}, k...))
+		}
+
+		for _, entry := range entries {
+			key := entry[8:]
+
+			err := index.Delete(entry)
+			if err != nil {
+				return err
+			}
+
+			v := data.Get(key)
+			if v == nil {
+				continue
+			}
+
+			var d Data
+
+			err = d.Decode(v)
+			if err != nil {
+				return err
+			}
+
+			// Skip stale index entries whose key has since been given a different deadline or deleted.
+			if d.Deleted || !bytes.Equal(expiryIndexKey(d.ExpiresAt, string(key)), entry) {
+				continue
+			}
+
+			// The tombstone keeps the version of the deadline, which a write of the deadline made before it passed
+			// still replaces.
+			tombstone := Data{Version: d.Version, ExpiryVersion: d.ExpiryVersion, Deleted: true, DeletedAt: now}
+
+			err = putData(tx, string(key), tombstone)
+			if err != nil {
+				return err
+			}
+
+			deleted = append(deleted, string(key))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
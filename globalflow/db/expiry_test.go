@@ -0,0 +1,68 @@
+package db
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDatabase_DeleteExpired(t *testing.T) {
+	p, err := os.CreateTemp(os.TempDir(), "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDatabase(p.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expiresAt := range map[string]Time{"a": 100, "b": 200, "c": 300, "d": 0} {
+		_, err := db.Set(key, Version{}, "value", expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Moving a deadline must not leave the old index entry behind.
+	_, err = db.Expire(50, Version{}, "b", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := db.DeleteExpired(250, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deleted) != 1 || deleted[0] != "a" {
+		t.Fatalf("expected [a], got %v", deleted)
+	}
+
+	deleted, err = db.DeleteExpired(350, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deleted) != 1 || deleted[0] != "c" {
+		t.Fatalf("expected [c], got %v", deleted)
+	}
+
+	for _, key := range []string{"b", "d"} {
+		if _, err := db.Lookup(350, key); err != nil {
+			t.Fatalf("expected %s to survive, got %v", key, err)
+		}
+	}
+
+	deleted, err = db.DeleteExpired(2000, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deleted) != 1 || deleted[0] != "b" {
+		t.Fatalf("expected [b], got %v", deleted)
+	}
+}
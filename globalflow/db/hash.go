@@ -0,0 +1,251 @@
+package db
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+var (
+	// ErrHashValueNotInteger is returned when incrementing a hash field that does not hold an integer.
+	ErrHashValueNotInteger = errors.New("hash value is not an integer")
+
+	// ErrOverflow is returned when an increment would overflow.
+	ErrOverflow = errors.New("increment or decrement would overflow")
+)
+
+// HashField is a single field of a hash.
+// Each field is versioned separately, so that concurrent writes to different fields are merged
+// and concurrent writes to the same field are resolved by last-writer-wins.
+type HashField struct {
+	Value   string  `json:"value"`
+	Version Version `json:"version"`
+
+	// Deleted marks a field removed by HDEL, so that an older write arriving later does not resurrect it.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// viewHash reads the live fields of a hash within a transaction.
+// Returns nil if the key does not exist.
+func viewHash(tx *txn, now Time, key string) (map[string]string, error) {
+	data, err := getData(tx, now, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	if data.Type != DataTypeHash {
+		return nil, &ErrorWrongType{Key: key}
+	}
+
+	fields := make(map[string]string)
+
+	for name, field := range data.HashValue {
+		if !field.Deleted {
+			fields[name] = field.Value
+		}
+	}
+
+	return fields, nil
+}
+
+// updateHash reads a hash within a transaction, applies fn to its fields and writes the result back as a write of a
+// version. fn receives an empty map if the key does not exist, and is not called if the key was deleted by a newer
+// write. Hashes without live fields are kept for their tombstones, but are treated as missing.
+func updateHash(tx *txn, now Time, key string, version Version, fn func(fields map[string]HashField) error) error {
+	data, err := readData(tx, now, key)
+	if err != nil {
+		return err
+	}
+
+	if data == nil {
+		data, err = replaceData(tx, key, DataTypeHash, version)
+		if err != nil || data == nil {
+			return err
+		}
+	}
+
+	if data.Type != DataTypeHash {
+		return &ErrorWrongType{Key: key}
+	}
+
+	if data.HashValue == nil {
+		data.HashValue = make(map[string]HashField)
+	}
+
+	err = fn(data.HashValue)
+	if err != nil {
+		return err
+	}
+
+	data.stamp(version)
+
+	return putData(tx, key, *data)
+}
+
+// mergeHashField writes a field if the version is newer than the field's current version.
+// Returns true if the field did not previously exist.
+func mergeHashField(fields map[string]HashField, name string, field HashField) bool {
+	existing, ok := fields[name]
+	if ok && !field.Version.After(existing.Version) {
+		return false
+	}
+
+	fields[name] = field
+
+	return !ok || existing.Deleted
+}
+
+// HSet sets fields of a hash from a list of alternating field names and values.
+// Fields that have been written by a newer version are left untouched.
+// Returns the number of fields that were added.
+func (db *Database) HSet(now Time, key string, version Version, pairs ...string) (int, error) {
+	added := 0
+
+	err := db.update(func(tx *txn) error {
+		return updateHash(tx, now, key, version, func(fields map[string]HashField) error {
+			for i := 0; i+1 < len(pairs); i += 2 {
+				if mergeHashField(fields, pairs[i], HashField{Value: pairs[i+1], Version: version}) {
+					added++
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return added, nil
+}
+
+// HSetNX sets a field of a hash only if the field does not exist.
+// Returns true if the field was set.
+func (db *Database) HSetNX(now Time, key string, version Version, name string, value string) (bool, error) {
+	set := false
+
+	err := db.update(func(tx *txn) error {
+		return updateHash(tx, now, key, version, func(fields map[string]HashField) error {
+			if field, ok := fields[name]; ok && !field.Deleted {
+				return nil
+			}
+
+			set = mergeHashField(fields, name, HashField{Value: value, Version: version})
+
+			return nil
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return set, nil
+}
+
+// HDel removes fields from a hash.
+// Fields that have been written by a newer version are left untouched.
+// Returns the number of fields that were removed.
+func (db *Database) HDel(now Time, key string, version Version, names ...string) (int, error) {
+	removed := 0
+
+	err := db.update(func(tx *txn) error {
+		return updateHash(tx, now, key, version, func(fields map[string]HashField) error {
+			for _, name := range names {
+				existing, ok := fields[name]
+				if ok && !version.After(existing.Version) {
+					continue
+				}
+
+				fields[name] = HashField{Version: version, Deleted: true}
+
+				if ok && !existing.Deleted {
+					removed++
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// HIncrBy increments the integer value of a field of a hash.
+// Returns the value after the increment.
+func (db *Database) HIncrBy(now Time, key string, version Version, name string, increment int64) (int64, error) {
+	var value int64
+
+	err := db.update(func(tx *txn) error {
+		return updateHash(tx, now, key, version, func(fields map[string]HashField) error {
+			if field, ok := fields[name]; ok && !field.Deleted {
+				current, err := strconv.ParseInt(field.Value, 10, 64)
+				if err != nil {
+					return ErrHashValueNotInteger
+				}
+
+				if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+					return ErrOverflow
+				}
+
+				value = current
+			}
+
+			value += increment
+
+			mergeHashField(fields, name, HashField{Value: strconv.FormatInt(value, 10), Version: version})
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, nil
+}
+
+// HGet returns the value of a field of a hash.
+// Returns ErrorNotFound if the key or field does not exist.
+func (db *Database) HGet(now Time, key string, name string) (string, error) {
+	fields, err := db.HGetAll(now, key)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := fields[name]
+	if !ok {
+		return "", &ErrorNotFound{Key: key}
+	}
+
+	return value, nil
+}
+
+// HGetAll returns every field of a hash.
+// Returns an empty map if the key does not exist.
+func (db *Database) HGetAll(now Time, key string) (map[string]string, error) {
+	var fields map[string]string
+
+	err := db.view(func(tx *txn) error {
+		var err error
+
+		fields, err = viewHash(tx, now, key)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if fields == nil {
+		fields = make(map[string]string)
+	}
+
+	return fields, nil
+}
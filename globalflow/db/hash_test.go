@@ -0,0 +1,83 @@
+package db
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDatabase_HSetMergesConcurrentFields(t *testing.T) {
+	p, err := os.CreateTemp(os.TempDir(), "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDatabase(p.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two regions write different fields, and the same field, concurrently.
+	// The writes arrive in the opposite order to the one they were made in.
+	_, err = db.HSet(1, "user", Version{Time: 5, Node: "b"}, "email", "b@example.com", "name", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := db.HSet(1, "user", Version{Time: 5, Node: "a"}, "name", "a", "city", "London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added != 1 {
+		t.Fatalf("expected 1 field added, got %d", added)
+	}
+
+	fields, err := db.HGetAll(1, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"email": "b@example.com", "name": "b", "city": "London"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected %v, got %v", expected, fields)
+	}
+
+	// A delete is not undone by an older write that arrives after it.
+	removed, err := db.HDel(1, "user", Version{Time: 10, Node: "a"}, "city")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 1 {
+		t.Fatalf("expected 1 field removed, got %d", removed)
+	}
+
+	_, err = db.HSet(1, "user", Version{Time: 6, Node: "b"}, "city", "Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.HGet(1, "user", "city")
+	if !IsErrorNotFound(err) {
+		t.Fatalf("expected deleted field to stay deleted, got %v", err)
+	}
+
+	value, err := db.HIncrBy(1, "user", Version{Time: 11, Node: "a"}, "visits", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != 3 {
+		t.Fatalf("expected 3, got %d", value)
+	}
+
+	_, err = db.HIncrBy(1, "user", Version{Time: 12, Node: "a"}, "name", 1)
+	if err != ErrHashValueNotInteger {
+		t.Fatalf("expected not an integer error, got %v", err)
+	}
+}
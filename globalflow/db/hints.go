@@ -0,0 +1,236 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Hints are messages that could not be delivered to a node, kept until the node can be reached again. BucketHints
+// contains a bucket for each recipient, in which hints are keyed by a sequence number in the order they were stored.
+// Hints belong to the node that stored them, so they are kept when a snapshot is restored.
+const BucketHints = "HINTS"
+
+// hintsSizeKey is the key in the metadata bucket that records the total size of the stored hints in bytes.
+var hintsSizeKey = []byte("hints:size")
+
+// ErrHintsFull is returned when storing a hint would take the hints beyond their maximum size.
+var ErrHintsFull = errors.New("hints are full")
+
+// Hint is a message stored for a node that it could not be delivered to.
+type Hint struct {
+	// Node is the ID of the node that the message is for.
+	Node string `json:"-"`
+
+	// Sequence is the position of the hint among the hints of its node.
+	Sequence uint64 `json:"-"`
+
+	// Time is the wall clock time at which the hint was stored, which its expiry is based on.
+	Time Time `json:"time"`
+
+	// Message is the encoded message.
+	Message json.RawMessage `json:"message"`
+}
+
+// AddHint stores a hint for its node and sets its sequence number.
+// Returns ErrHintsFull if the hints would be larger than maxSize bytes. A maxSize of zero or less does not limit their
+// size.
+func (db *Database) AddHint(hint *Hint, maxSize int64) error {
+	return db.update(func(tx *txn) error {
+		bucket, err := tx.Bucket([]byte(BucketHints)).CreateBucketIfNotExists([]byte(hint.Node))
+		if err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(hint)
+		if err != nil {
+			return err
+		}
+
+		size := int64(8 + len(encoded))
+
+		if maxSize > 0 && metaSize(tx.Tx, hintsSizeKey)+size > maxSize {
+			return ErrHintsFull
+		}
+
+		hint.Sequence, err = bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		err = bucket.Put(walKey(hint.Sequence), encoded)
+		if err != nil {
+			return err
+		}
+
+		return addMetaSize(tx.Tx, hintsSizeKey, size)
+	})
+}
+
+// decodeHint decodes a hint of the bucket of a node.
+func decodeHint(node string, k []byte, v []byte) (Hint, error) {
+	hint := Hint{}
+
+	err := json.Unmarshal(v, &hint)
+	if err != nil {
+		return Hint{}, err
+	}
+
+	hint.Node = node
+	hint.Sequence = binary.BigEndian.Uint64(k)
+
+	return hint, nil
+}
+
+// Hints returns up to limit of the oldest hints stored for a node, in the order they were stored.
+func (db *Database) Hints(node string, limit int) ([]Hint, error) {
+	hints := make([]Hint, 0)
+
+	err := db.view(func(tx *txn) error {
+		bucket := tx.Bucket([]byte(BucketHints)).Bucket([]byte(node))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+
+		for k, v := c.First(); k != nil && len(hints) < limit; k, v = c.Next() {
+			hint, err := decodeHint(node, k, v)
+			if err != nil {
+				return err
+			}
+
+			hints = append(hints, hint)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hints, nil
+}
+
+// HintNodes returns the IDs of the nodes that have hints stored for them.
+func (db *Database) HintNodes() ([]string, error) {
+	nodes := make([]string, 0)
+
+	err := db.view(func(tx *txn) error {
+		return tx.Bucket([]byte(BucketHints)).ForEach(func(k, v []byte) error {
+			// Every key of the hints bucket is the bucket of a node.
+			nodes = append(nodes, string(k))
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// deleteHints removes the hints of a node for which keep returns false, from the oldest, stopping at the first hint
+// that is kept. The node's bucket is removed once it is empty.
+// Returns the number of hints removed.
+func deleteHints(tx *bolt.Tx, node []byte, keep func(hint Hint) bool) (int, error) {
+	hints := tx.Bucket([]byte(BucketHints))
+	bucket := hints.Bucket(node)
+	removed := 0
+
+	c := bucket.Cursor()
+
+	// The cursor is moved back to the start after each deletion, as a cursor is not positioned reliably after one.
+	for k, v := c.First(); k != nil; k, v = c.First() {
+		hint, err := decodeHint(string(node), k, v)
+		if err != nil {
+			return removed, err
+		}
+
+		if keep(hint) {
+			return removed, nil
+		}
+
+		err = c.Delete()
+		if err != nil {
+			return removed, err
+		}
+
+		err = addMetaSize(tx, hintsSizeKey, -int64(len(k)+len(v)))
+		if err != nil {
+			return removed, err
+		}
+
+		removed++
+	}
+
+	return removed, hints.DeleteBucket(node)
+}
+
+// DeleteHints removes the hints of a node up to and including a sequence number, once they have been delivered.
+func (db *Database) DeleteHints(node string, through uint64) error {
+	return db.update(func(tx *txn) error {
+		if tx.Bucket([]byte(BucketHints)).Bucket([]byte(node)) == nil {
+			return nil
+		}
+
+		_, err := deleteHints(tx.Tx, []byte(node), func(hint Hint) bool {
+			return hint.Sequence > through
+		})
+
+		return err
+	})
+}
+
+// TrimHints removes the hints that were stored before a time.
+// Returns the number of hints removed.
+func (db *Database) TrimHints(before Time) (int, error) {
+	removed := 0
+
+	err := db.update(func(tx *txn) error {
+		nodes := make([][]byte, 0)
+
+		err := tx.Bucket([]byte(BucketHints)).ForEach(func(k, v []byte) error {
+			nodes = append(nodes, append([]byte{}, k...))
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, node := range nodes {
+			n, err := deleteHints(tx.Tx, node, func(hint Hint) bool {
+				return hint.Time >= before
+			})
+
+			removed += n
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// HintsSize returns the total size of the stored hints in bytes.
+func (db *Database) HintsSize() (int64, error) {
+	var size int64
+
+	err := db.view(func(tx *txn) error {
+		size = metaSize(tx.Tx, hintsSizeKey)
+
+		return nil
+	})
+
+	return size, err
+}
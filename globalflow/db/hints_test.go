@@ -0,0 +1,94 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+// hintSequences returns the sequence numbers of the hints stored for a node.
+func hintSequences(t *testing.T, db *Database, node string) []uint64 {
+	hints, err := db.Hints(node, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sequences := make([]uint64, 0, len(hints))
+	for _, hint := range hints {
+		sequences = append(sequences, hint.Sequence)
+	}
+
+	return sequences
+}
+
+func TestDatabase_Hints(t *testing.T) {
+	db := newTestDatabase(t)
+
+	for i := 0; i < 3; i++ {
+		hint := &Hint{Node: "b", Time: Time(100 + i), Message: []byte(`{"type":"command"}`)}
+
+		if err := db.AddHint(hint, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if hint.Sequence != uint64(i+1) {
+			t.Fatalf("expected sequence %d, got %d", i+1, hint.Sequence)
+		}
+	}
+
+	if err := db.AddHint(&Hint{Node: "c", Time: 300, Message: []byte(`{}`)}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	hints, err := db.Hints("b", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hints) != 2 || hints[0].Sequence != 1 || string(hints[0].Message) != `{"type":"command"}` {
+		t.Errorf("expected the two oldest hints, got %v", hints)
+	}
+
+	if nodes, err := db.HintNodes(); err != nil || !reflect.DeepEqual(nodes, []string{"b", "c"}) {
+		t.Errorf("expected [b c], got %v, %v", nodes, err)
+	}
+
+	size, err := db.HintsSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A hint that does not fit is refused.
+	if err := db.AddHint(&Hint{Node: "b", Time: 400, Message: []byte(`{}`)}, size+1); err != ErrHintsFull {
+		t.Errorf("expected ErrHintsFull, got %v", err)
+	}
+
+	if err := db.DeleteHints("b", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if sequences := hintSequences(t, db, "b"); !reflect.DeepEqual(sequences, []uint64{3}) {
+		t.Errorf("expected [3], got %v", sequences)
+	}
+
+	// Expiry removes every hint stored before the time, along with nodes that have none left.
+	removed, err := db.TrimHints(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 1 {
+		t.Errorf("expected 1 hint removed, got %d", removed)
+	}
+
+	if nodes, err := db.HintNodes(); err != nil || !reflect.DeepEqual(nodes, []string{"c"}) {
+		t.Errorf("expected [c], got %v, %v", nodes, err)
+	}
+
+	if err := db.DeleteHints("c", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if size, err := db.HintsSize(); err != nil || size != 0 {
+		t.Errorf("expected no hints left, got %d bytes, %v", size, err)
+	}
+}
@@ -0,0 +1,421 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"strconv"
+)
+
+// liveData decodes an entry of the data bucket.
+// Returns nil if the entry has expired or only contains tombstones, so that it is hidden from the keyspace.
+func liveData(now Time, v []byte) (*Data, error) {
+	data := &Data{}
+
+	err := data.Decode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.Expired(now) || data.Empty() {
+		return nil, nil
+	}
+
+	return data, nil
+}
+
+// Scan iterates over the keyspace in key order, starting after the given key.
+// At most count entries of the data bucket are examined, of which the ones matching the pattern and type are returned.
+// An empty pattern or type name matches every key.
+// Returns the key to continue after, which is empty once the whole keyspace has been scanned.
+func (db *Database) Scan(now Time, after string, count int, pattern string, typeName string) ([]string, string, error) {
+	keys := make([]string, 0)
+	last := ""
+
+	err := db.view(func(tx *txn) error {
+		c := tx.bucket(BucketData).Cursor()
+
+		k, v := c.Seek([]byte(after))
+		if k != nil && after != "" && string(k) == after {
+			k, v = c.Next()
+		}
+
+		for examined := 0; k != nil; k, v = c.Next() {
+			if examined == count {
+				return nil
+			}
+
+			examined++
+			last = string(k)
+
+			data, err := liveData(now, v)
+			if err != nil {
+				return err
+			}
+
+			if data == nil || (typeName != "" && data.Type.String() != typeName) {
+				continue
+			}
+
+			if pattern != "" && !Match(pattern, string(k)) {
+				continue
+			}
+
+			keys = append(keys, string(k))
+		}
+
+		// The whole keyspace has been scanned.
+		last = ""
+
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return keys, last, nil
+}
+
+// Keys returns every key matching a pattern, in key order.
+func (db *Database) Keys(now Time, pattern string) ([]string, error) {
+	keys := make([]string, 0)
+
+	err := db.view(func(tx *txn) error {
+		return tx.bucket(BucketData).ForEach(func(k, v []byte) error {
+			if !Match(pattern, string(k)) {
+				return nil
+			}
+
+			data, err := liveData(now, v)
+			if err != nil || data == nil {
+				return err
+			}
+
+			keys = append(keys, string(k))
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Exists returns the number of the given keys that exist.
+// Keys given more than once are counted more than once.
+func (db *Database) Exists(now Time, keys ...string) (int, error) {
+	count := 0
+
+	err := db.view(func(tx *txn) error {
+		for _, key := range keys {
+			data, err := getData(tx, now, key)
+			if err != nil {
+				return err
+			}
+
+			if data != nil {
+				count++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Type returns the name of the type of the value stored at a key, or "none" if the key does not exist.
+func (db *Database) Type(now Time, key string) (string, error) {
+	data, err := db.Lookup(now, key)
+	if IsErrorNotFound(err) {
+		return "none", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return data.Type.String(), nil
+}
+
+// DeleteKeys deletes several keys in a single transaction, replacing each of them with a tombstone of a version.
+// A key that has been written by a newer write is kept, and a key that does not exist is given a tombstone too, so that
+// a write of the key that the deletion has overtaken is discarded when it arrives.
+// Returns the number of keys that existed and were deleted.
+func (db *Database) DeleteKeys(now Time, version Version, keys ...string) (int, error) {
+	count := 0
+
+	err := db.update(func(tx *txn) error {
+		for _, key := range keys {
+			data, err := getData(tx, now, key)
+			if err != nil {
+				return err
+			}
+
+			deleted, err := deleteKey(tx, now, key, version)
+			if err != nil {
+				return err
+			}
+
+			if deleted && data != nil {
+				count++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Rename moves the value of a key, along with its deadline, to another key, replacing any value stored there.
+// The source key is replaced with a tombstone of the rename, and each half of the rename is discarded if its key has
+// been written by a newer write. Returns ErrNoSuchKey if the source key does not exist.
+func (db *Database) Rename(now Time, version Version, src string, dst string) error {
+	return db.update(func(tx *txn) error {
+		data, err := getData(tx, now, src)
+		if err != nil {
+			return err
+		}
+
+		if data == nil {
+			return ErrNoSuchKey
+		}
+
+		if src == dst {
+			return nil
+		}
+
+		stored, err := readStored(tx, dst)
+		if err != nil {
+			return err
+		}
+
+		if stored == nil || version.After(stored.Version) {
+			err = deleteData(tx, dst)
+			if err != nil {
+				return err
+			}
+
+			if data.Type == DataTypeSortedSet {
+				err := copySortedSet(tx, src, dst)
+				if err != nil {
+					return err
+				}
+			}
+
+			renamed := *data
+			renamed.stamp(version)
+
+			// The deadline of the source moves to the destination, unless the destination's was written since.
+			renamed.ExpiresAt, renamed.ExpiryVersion = 0, Version{}
+			if stored != nil {
+				renamed.ExpiresAt, renamed.ExpiryVersion = stored.ExpiresAt, stored.ExpiryVersion
+			}
+
+			renamed.expire(version, data.ExpiresAt)
+
+			err = putData(tx, dst, renamed)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = deleteKey(tx, now, src, version)
+		return err
+	})
+}
+
+// Size returns the number of keys in the database.
+func (db *Database) Size(now Time) (int, error) {
+	count := 0
+
+	err := db.view(func(tx *txn) error {
+		return tx.bucket(BucketData).ForEach(func(k, v []byte) error {
+			data, err := liveData(now, v)
+			if err == nil && data != nil {
+				count++
+			}
+
+			return err
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// KeyspaceInfo contains statistics about the keys of a logical database.
+type KeyspaceInfo struct {
+	// Keys is the number of keys in the database.
+	Keys int
+
+	// Expires is the number of keys that have a deadline.
+	Expires int
+}
+
+// Info returns statistics about the keys in the database.
+func (db *Database) Info(now Time) (KeyspaceInfo, error) {
+	var info KeyspaceInfo
+
+	err := db.view(func(tx *txn) error {
+		return tx.bucket(BucketData).ForEach(func(k, v []byte) error {
+			data, err := liveData(now, v)
+			if err != nil || data == nil {
+				return err
+			}
+
+			info.Keys++
+
+			if data.ExpiresAt != 0 {
+				info.Expires++
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return KeyspaceInfo{}, err
+	}
+
+	return info, nil
+}
+
+// RandomKey returns a key chosen at random.
+// The key following a random position in the keyspace is returned, so keys are not chosen with equal probability.
+// Returns ErrorNotFound if the database is empty.
+func (db *Database) RandomKey(now Time) (string, error) {
+	position := make([]byte, 8)
+
+	_, err := rand.Read(position)
+	if err != nil {
+		return "", err
+	}
+
+	key := ""
+
+	err = db.view(func(tx *txn) error {
+		c := tx.bucket(BucketData).Cursor()
+
+		k, v := c.Seek(position)
+		if k == nil {
+			k, v = c.First()
+		}
+
+		for start := k; k != nil; {
+			data, err := liveData(now, v)
+			if err != nil {
+				return err
+			}
+
+			if data != nil {
+				key = string(k)
+				return nil
+			}
+
+			k, v = c.Next()
+			if k == nil {
+				k, v = c.First()
+			}
+
+			if string(k) == string(start) {
+				return nil
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if key == "" {
+		return "", &ErrorNotFound{}
+	}
+
+	return key, nil
+}
+
+// flushVersionKey returns the key in the metadata bucket that records the most recent flush of a logical database.
+func flushVersionKey(index int) []byte {
+	return []byte("flushed:" + strconv.Itoa(index))
+}
+
+// FlushVersion returns the version of the most recent flush of the database.
+// Returns the zero version if the database has never been flushed.
+func (db *Database) FlushVersion() (Version, error) {
+	version := Version{}
+
+	err := db.view(func(tx *txn) error {
+		v := tx.Bucket([]byte(BucketMeta)).Get(flushVersionKey(tx.index))
+		if v == nil {
+			return nil
+		}
+
+		return json.Unmarshal(v, &version)
+	})
+	if err != nil {
+		return Version{}, err
+	}
+
+	return version, nil
+}
+
+// Flush deletes every key in the database and records the version of the flush.
+// Flushes are ordered like any other write, so a flush that is older than the most recent flush is ignored.
+// Returns false if the flush was ignored.
+func (db *Database) Flush(version Version) (bool, error) {
+	flushed := false
+
+	err := db.update(func(tx *txn) error {
+		meta := tx.Bucket([]byte(BucketMeta))
+
+		if v := meta.Get(flushVersionKey(tx.index)); v != nil {
+			var current Version
+
+			err := json.Unmarshal(v, &current)
+			if err != nil {
+				return err
+			}
+
+			if !version.After(current) {
+				return nil
+			}
+		}
+
+		for _, name := range []string{BucketData, BucketExpiry, BucketTombstones, BucketSortedSets} {
+			err := tx.DeleteBucket(bucketName(name, tx.index))
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+
+		err := createBuckets(tx)
+		if err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(version)
+		if err != nil {
+			return err
+		}
+
+		flushed = true
+
+		return meta.Put(flushVersionKey(tx.index), encoded)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return flushed, nil
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDatabase_ScanHidesExpiredAndEmptyKeys(t *testing.T) {
+	db := newTestDatabase(t)
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := db.Set(key, Version{}, "value", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.Expire(1, Version{}, "b", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.HSet(1, "f", Version{Time: 1, Node: "a"}, "field", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.HDel(1, "f", Version{Time: 2, Node: "a"}, "field"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 0)
+	after := ""
+
+	for {
+		page, next, err := db.Scan(10, after, 2, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, page...)
+
+		if next == "" {
+			break
+		}
+
+		after = next
+	}
+
+	if !reflect.DeepEqual(keys, []string{"a", "c", "d", "e"}) {
+		t.Errorf("expected [a c d e], got %v", keys)
+	}
+
+	size, err := db.Size(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 4 {
+		t.Errorf("expected 4 keys, got %d", size)
+	}
+}
+
+func TestDatabase_ScanMatchAndType(t *testing.T) {
+	db := newTestDatabase(t)
+
+	if _, err := db.Set("user:1", Version{}, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.RPush(1, "user:2", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Set("session:1", Version{}, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, next, err := db.Scan(1, "", 10, "user:*", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next != "" || !reflect.DeepEqual(keys, []string{"user:2"}) {
+		t.Errorf("expected [user:2], got %v with cursor %q", keys, next)
+	}
+}
+
+func TestDatabase_RenameSortedSet(t *testing.T) {
+	db := newTestDatabase(t)
+
+	_, err := db.ZAdd(1, "src", Version{Time: 1, Node: "a"}, ZAddOptions{}, SortedSetMember{Member: "x", Score: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Set("dst", Version{}, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Rename(1, Version{Time: 2, Node: "a"}, "src", "dst"); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := db.ZRange(1, "dst", ZRangeQuery{Start: 0, Stop: -1, Count: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(members, []SortedSetMember{{Member: "x", Score: 1}}) {
+		t.Errorf("expected [x], got %v", members)
+	}
+
+	exists, err := db.Exists(1, "src", "dst", "dst")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exists != 2 {
+		t.Errorf("expected 2, got %d", exists)
+	}
+
+	if err := db.Rename(1, Version{Time: 2, Node: "a"}, "src", "dst"); err != ErrNoSuchKey {
+		t.Errorf("expected ErrNoSuchKey, got %v", err)
+	}
+}
+
+func TestDatabase_SelectIsolatesDatabases(t *testing.T) {
+	db := newTestDatabase(t)
+
+	other, err := db.Select(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := other.Set("foo", Version{}, "bar", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Get(1, "foo"); !IsErrorNotFound(err) {
+		t.Errorf("expected foo to be missing from database 0, got %v", err)
+	}
+
+	if v, err := other.Get(1, "foo"); err != nil || v != "bar" {
+		t.Errorf("expected bar, got %q (%v)", v, err)
+	}
+
+	if _, err := db.Select(Databases); err != ErrDatabaseIndexOutOfRange {
+		t.Errorf("expected ErrDatabaseIndexOutOfRange, got %v", err)
+	}
+}
+
+func TestDatabase_FlushIgnoresOlderVersions(t *testing.T) {
+	db := newTestDatabase(t)
+
+	_, err := db.ZAdd(1, "zset", Version{Time: 1, Node: "a"}, ZAddOptions{}, SortedSetMember{Member: "x", Score: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flushed, err := db.Flush(Version{Time: 5, Node: "a"})
+	if err != nil || !flushed {
+		t.Fatalf("expected flush to be applied, got %v (%v)", flushed, err)
+	}
+
+	if size, err := db.Size(1); err != nil || size != 0 {
+		t.Errorf("expected empty database, got %d keys (%v)", size, err)
+	}
+
+	flushed, err = db.Flush(Version{Time: 4, Node: "b"})
+	if err != nil || flushed {
+		t.Errorf("expected older flush to be ignored, got %v (%v)", flushed, err)
+	}
+
+	version, err := db.FlushVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version != (Version{Time: 5, Node: "a"}) {
+		t.Errorf("expected flush version 5@a, got %s", version)
+	}
+}
+
+func TestDatabase_BatchRollsBackOnError(t *testing.T) {
+	db := newTestDatabase(t)
+
+	err := db.Batch(func(tx *Database) error {
+		if _, err := tx.Set("foo", Version{}, "bar", 0); err != nil {
+			return err
+		}
+
+		// Writes are visible within the batch.
+		if v, err := tx.Get(1, "foo"); err != nil || v != "bar" {
+			t.Errorf("expected bar within the batch, got %q (%v)", v, err)
+		}
+
+		return ErrNoSuchKey
+	})
+	if err != ErrNoSuchKey {
+		t.Fatalf("expected ErrNoSuchKey, got %v", err)
+	}
+
+	if _, err := db.Get(1, "foo"); !IsErrorNotFound(err) {
+		t.Errorf("expected foo to be rolled back, got %v", err)
+	}
+}
+
+func TestDatabase_Info(t *testing.T) {
+	db := newTestDatabase(t)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if _, err := db.Set(key, Version{}, "value", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.Expire(1, Version{}, "b", 50); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Expire(1, Version{}, "c", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := db.Info(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info != (KeyspaceInfo{Keys: 2, Expires: 1}) {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
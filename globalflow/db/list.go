@@ -0,0 +1,452 @@
+package db
+
+import "errors"
+
+var (
+	// ErrNoSuchKey is returned when a command requires a key that does not exist.
+	ErrNoSuchKey = errors.New("no such key")
+
+	// ErrIndexOutOfRange is returned when a list index is outside the list.
+	ErrIndexOutOfRange = errors.New("index out of range")
+)
+
+// ListEnd identifies the head or tail of a list.
+type ListEnd string
+
+const (
+	ListHead ListEnd = "left"
+	ListTail ListEnd = "right"
+)
+
+// viewList reads a list within a transaction.
+// Returns nil if the key does not exist.
+func viewList(tx *txn, now Time, key string) ([]string, error) {
+	data, err := getData(tx, now, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	if data.Type != DataTypeList {
+		return nil, &ErrorWrongType{Key: key}
+	}
+
+	return data.ListValue, nil
+}
+
+// updateList reads a list within a transaction, applies fn to it and writes the result back.
+// fn receives a nil list if the key does not exist. Empty lists are deleted, as Redis does.
+func updateList(tx *txn, now Time, key string, fn func(list []string) ([]string, error)) error {
+	data, err := getData(tx, now, key)
+	if err != nil {
+		return err
+	}
+
+	exists := data != nil
+
+	if !exists {
+		// Lists are not versioned, so they replace the tombstone of a deleted key regardless of its version.
+		data, err = replaceData(tx, key, DataTypeList, Version{})
+		if err != nil {
+			return err
+		}
+	}
+
+	if data.Type != DataTypeList {
+		return &ErrorWrongType{Key: key}
+	}
+
+	list, err := fn(data.ListValue)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		if !exists {
+			return nil
+		}
+
+		return deleteData(tx, key)
+	}
+
+	data.ListValue = list
+
+	return putData(tx, key, *data)
+}
+
+// listRange converts Redis-style start and stop indexes, which may be negative, to slice bounds.
+// Returns an empty range if the indexes do not overlap the list.
+func listRange(length int, start int, stop int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+
+	if stop < 0 {
+		stop += length
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if stop >= length {
+		stop = length - 1
+	}
+
+	if start > stop || start >= length {
+		return 0, 0
+	}
+
+	return start, stop + 1
+}
+
+// listIndex converts a Redis-style index, which may be negative, to a slice index.
+// Returns false if the index is outside the list.
+func listIndex(length int, index int) (int, bool) {
+	if index < 0 {
+		index += length
+	}
+
+	return index, index >= 0 && index < length
+}
+
+// push adds values to one end of a list, creating it unless exists is set and the key does not exist.
+// Returns the length of the list after the push.
+func (db *Database) push(now Time, key string, end ListEnd, values []string, exists bool) (int, error) {
+	length := 0
+
+	err := db.update(func(tx *txn) error {
+		return updateList(tx, now, key, func(list []string) ([]string, error) {
+			if list == nil && exists {
+				return nil, nil
+			}
+
+			if end == ListTail {
+				list = append(list, values...)
+			} else {
+				head := make([]string, 0, len(list)+len(values))
+
+				for i := len(values) - 1; i >= 0; i-- {
+					head = append(head, values[i])
+				}
+
+				list = append(head, list...)
+			}
+
+			length = len(list)
+
+			return list, nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return length, nil
+}
+
+// LPush pushes values to the head of a list, one after the other.
+// Returns the length of the list after the push.
+func (db *Database) LPush(now Time, key string, values ...string) (int, error) {
+	return db.push(now, key, ListHead, values, false)
+}
+
+// RPush pushes values to the tail of a list.
+// Returns the length of the list after the push.
+func (db *Database) RPush(now Time, key string, values ...string) (int, error) {
+	return db.push(now, key, ListTail, values, false)
+}
+
+// LPushX pushes values to the head of a list only if the list exists.
+// Returns the length of the list after the push.
+func (db *Database) LPushX(now Time, key string, values ...string) (int, error) {
+	return db.push(now, key, ListHead, values, true)
+}
+
+// RPushX pushes values to the tail of a list only if the list exists.
+// Returns the length of the list after the push.
+func (db *Database) RPushX(now Time, key string, values ...string) (int, error) {
+	return db.push(now, key, ListTail, values, true)
+}
+
+// pop removes up to count values from one end of a list.
+// Returns the removed values in the order they were popped, or nil if the key does not exist.
+func (db *Database) pop(now Time, key string, end ListEnd, count int) ([]string, error) {
+	var popped []string
+
+	err := db.update(func(tx *txn) error {
+		return updateList(tx, now, key, func(list []string) ([]string, error) {
+			if list == nil {
+				return nil, nil
+			}
+
+			if count > len(list) {
+				count = len(list)
+			}
+
+			popped = make([]string, 0, count)
+
+			if end == ListHead {
+				popped = append(popped, list[:count]...)
+
+				return list[count:], nil
+			}
+
+			for i := len(list) - 1; i >= len(list)-count; i-- {
+				popped = append(popped, list[i])
+			}
+
+			return list[:len(list)-count], nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return popped, nil
+}
+
+// LPop removes up to count values from the head of a list.
+// Returns nil if the key does not exist.
+func (db *Database) LPop(now Time, key string, count int) ([]string, error) {
+	return db.pop(now, key, ListHead, count)
+}
+
+// RPop removes up to count values from the tail of a list.
+// Returns nil if the key does not exist.
+func (db *Database) RPop(now Time, key string, count int) ([]string, error) {
+	return db.pop(now, key, ListTail, count)
+}
+
+// LRange returns the values between the start and stop indexes of a list, inclusive.
+func (db *Database) LRange(now Time, key string, start int, stop int) ([]string, error) {
+	values := make([]string, 0)
+
+	err := db.view(func(tx *txn) error {
+		list, err := viewList(tx, now, key)
+		if err != nil {
+			return err
+		}
+
+		from, to := listRange(len(list), start, stop)
+		values = append(values, list[from:to]...)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// LLen returns the length of a list, or zero if the key does not exist.
+func (db *Database) LLen(now Time, key string) (int, error) {
+	length := 0
+
+	err := db.view(func(tx *txn) error {
+		list, err := viewList(tx, now, key)
+		length = len(list)
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return length, nil
+}
+
+// LIndex returns the value at an index of a list.
+// Returns ErrorNotFound if the key does not exist or the index is out of range.
+func (db *Database) LIndex(now Time, key string, index int) (string, error) {
+	value := ""
+
+	err := db.view(func(tx *txn) error {
+		list, err := viewList(tx, now, key)
+		if err != nil {
+			return err
+		}
+
+		i, ok := listIndex(len(list), index)
+		if !ok {
+			return &ErrorNotFound{Key: key}
+		}
+
+		value = list[i]
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// LSet sets the value at an index of a list.
+func (db *Database) LSet(now Time, key string, index int, value string) error {
+	return db.update(func(tx *txn) error {
+		return updateList(tx, now, key, func(list []string) ([]string, error) {
+			if list == nil {
+				return nil, ErrNoSuchKey
+			}
+
+			i, ok := listIndex(len(list), index)
+			if !ok {
+				return nil, ErrIndexOutOfRange
+			}
+
+			list[i] = value
+
+			return list, nil
+		})
+	})
+}
+
+// LRem removes occurrences of a value from a list.
+// A positive count removes up to count occurrences from the head, a negative count removes up to -count
+// occurrences from the tail, and zero removes every occurrence.
+// Returns the number of values removed.
+func (db *Database) LRem(now Time, key string, count int, value string) (int, error) {
+	removed := 0
+
+	err := db.update(func(tx *txn) error {
+		return updateList(tx, now, key, func(list []string) ([]string, error) {
+			limit := count
+			if limit < 0 {
+				limit = -limit
+			}
+
+			keep := make([]bool, len(list))
+			for i := range keep {
+				keep[i] = true
+			}
+
+			for j := 0; j < len(list); j++ {
+				i := j
+				if count < 0 {
+					i = len(list) - 1 - j
+				}
+
+				if list[i] == value && (limit == 0 || removed < limit) {
+					keep[i] = false
+					removed++
+				}
+			}
+
+			result := make([]string, 0, len(list)-removed)
+
+			for i, v := range list {
+				if keep[i] {
+					result = append(result, v)
+				}
+			}
+
+			return result, nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// LTrim trims a list so that it only contains the values between the start and stop indexes, inclusive.
+func (db *Database) LTrim(now Time, key string, start int, stop int) error {
+	return db.update(func(tx *txn) error {
+		return updateList(tx, now, key, func(list []string) ([]string, error) {
+			from, to := listRange(len(list), start, stop)
+
+			return list[from:to], nil
+		})
+	})
+}
+
+// LInsert inserts a value before or after the first occurrence of a pivot value.
+// Returns the length of the list after the insert, 0 if the key does not exist, or -1 if the pivot was not found.
+func (db *Database) LInsert(now Time, key string, before bool, pivot string, value string) (int, error) {
+	length := 0
+
+	err := db.update(func(tx *txn) error {
+		return updateList(tx, now, key, func(list []string) ([]string, error) {
+			if list == nil {
+				return nil, nil
+			}
+
+			for i, v := range list {
+				if v != pivot {
+					continue
+				}
+
+				if !before {
+					i++
+				}
+
+				list = append(list[:i], append([]string{value}, list[i:]...)...)
+				length = len(list)
+
+				return list, nil
+			}
+
+			length = -1
+
+			return list, nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return length, nil
+}
+
+// LMove atomically pops a value from one end of the source list and pushes it to one end of the destination list.
+// Returns ErrorNotFound if the source list does not exist.
+func (db *Database) LMove(now Time, source string, destination string, from ListEnd, to ListEnd) (string, error) {
+	value := ""
+
+	err := db.update(func(tx *txn) error {
+		// Check the destination type first, so that a wrong type leaves the source untouched.
+		_, err := viewList(tx, now, destination)
+		if err != nil {
+			return err
+		}
+
+		err = updateList(tx, now, source, func(list []string) ([]string, error) {
+			if list == nil {
+				return nil, &ErrorNotFound{Key: source}
+			}
+
+			if from == ListHead {
+				value = list[0]
+
+				return list[1:], nil
+			}
+
+			value = list[len(list)-1]
+
+			return list[:len(list)-1], nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return updateList(tx, now, destination, func(list []string) ([]string, error) {
+			if to == ListHead {
+				return append([]string{value}, list...), nil
+			}
+
+			return append(list, value), nil
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDatabase_LPush(t *testing.T) {
+	p, err := os.CreateTemp(os.TempDir(), "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDatabase(p.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	length, err := db.LPush(1, "foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if length != 1 {
+		t.Fatalf("expected length 1, got %d", length)
+	}
+
+	length, err = db.LPush(1, "foo", "baz", "qux")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if length != 3 {
+		t.Fatalf("expected length 3, got %d", length)
+	}
+
+	values, err := db.LRange(1, "foo", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(values, []string{"qux", "baz", "bar"}) {
+		t.Fatalf("expected values to be pushed to the head, got %v", values)
+	}
+}
+
+func TestDatabase_ListCommands(t *testing.T) {
+	p, err := os.CreateTemp(os.TempDir(), "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDatabase(p.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.RPush(1, "list", "a", "b", "c", "b", "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := db.LRem(1, "list", -1, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 1 {
+		t.Fatalf("expected 1 value removed, got %d", removed)
+	}
+
+	err = db.LSet(1, "list", -1, "e")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.LSet(1, "list", 10, "e")
+	if err != ErrIndexOutOfRange {
+		t.Fatalf("expected index out of range, got %v", err)
+	}
+
+	value, err := db.LIndex(1, "list", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != "b" {
+		t.Fatalf("expected b, got %s", value)
+	}
+
+	err = db.LTrim(1, "list", 1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	popped, err := db.RPop(1, "list", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(popped, []string{"e", "c"}) {
+		t.Fatalf("expected [e c], got %v", popped)
+	}
+
+	moved, err := db.LMove(1, "list", "other", ListHead, ListTail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if moved != "b" {
+		t.Fatalf("expected b, got %s", moved)
+	}
+
+	// Popping the last value deletes the list.
+	_, err = db.Lookup(1, "list")
+	if !IsErrorNotFound(err) {
+		t.Fatal("expected empty list to be deleted")
+	}
+
+	_, err = db.Set("string", Version{}, "value", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.LPush(1, "string", "value")
+	if !IsErrorWrongType(err) {
+		t.Fatalf("expected wrong type error, got %v", err)
+	}
+
+	_, err = db.Get(1, "other")
+	if !IsErrorWrongType(err) {
+		t.Fatalf("expected wrong type error, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package db
+
+// Match reports whether a string matches a Redis-style glob pattern.
+// Patterns support *, ?, character classes such as [abc], [^abc] and [a-z], and backslash escapes.
+func Match(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for i := 0; i <= len(s); i++ {
+				if Match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+
+			s = s[1:]
+
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+
+			pattern = pattern[1:]
+
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			matched := false
+
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					matched = matched || pattern[0] == s[0]
+
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+
+					pattern = pattern[2:]
+					matched = matched || (s[0] >= start && s[0] <= end)
+
+				default:
+					matched = matched || pattern[0] == s[0]
+				}
+
+				pattern = pattern[1:]
+			}
+
+			if matched == not {
+				return false
+			}
+
+			s = s[1:]
+
+			// An unterminated class matches up to the end of the pattern.
+			if len(pattern) == 0 {
+				return len(s) == 0
+			}
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+
+			fallthrough
+
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+
+			s = s[1:]
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return len(s) == 0
+}
@@ -0,0 +1,376 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"sort"
+)
+
+// Replicas that have drifted apart are repaired by exchanging the complete state of the keys that differ and merging
+// it with the rules that resolve concurrent writes: hash fields and sorted set members are last-writer-wins by version,
+// and set members are merged as observed-remove sets. Otherwise, when the states differ, a key holds a different type
+// on each node, or a key has been deleted on one of them, both nodes keep the state of the newer write of the whole
+// key. Lists are not versioned, so between states of the same version they keep the state with the greater encoding,
+// which makes them converge on the same one. A key that only one node has is copied to the other.
+
+// Entry is the complete state of a key, as exchanged between nodes to repair differences.
+type Entry struct {
+	Key  string `json:"key"`
+	Data Data   `json:"data"`
+
+	// Members contains the state of every member of a sorted set, including removed members, by member.
+	Members map[string]json.RawMessage `json:"members,omitempty"`
+}
+
+// normalize puts the entry in the form that its digest is computed from, so that equal states have equal encodings
+// regardless of the order their writes were applied in.
+func (entry *Entry) normalize() {
+	for member, tags := range entry.Data.SetValue {
+		sorted := append([]string{}, tags...)
+		sort.Strings(sorted)
+
+		entry.Data.SetValue[member] = sorted
+	}
+
+	if len(entry.Data.ListValue) == 0 {
+		entry.Data.ListValue = nil
+	}
+
+	// The size of a sorted set follows from its members.
+	entry.Data.SortedSetSize = 0
+}
+
+// encode returns the canonical encoding of the entry.
+func (entry Entry) encode() ([]byte, error) {
+	entry.Data.SetValue = copySet(entry.Data.SetValue)
+	entry.normalize()
+
+	return json.Marshal(entry)
+}
+
+// Digest returns a hash of the key and its state, which is equal on two nodes if and only if they hold the same state.
+func (entry Entry) Digest() ([]byte, error) {
+	encoded, err := entry.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(encoded)
+
+	return digest[:], nil
+}
+
+// copySet returns a copy of the members of a set and their tags.
+func copySet(set map[string][]string) map[string][]string {
+	if set == nil {
+		return nil
+	}
+
+	copied := make(map[string][]string, len(set))
+	for member, tags := range set {
+		copied[member] = append([]string{}, tags...)
+	}
+
+	return copied
+}
+
+// readEntry reads the complete state of a key within a transaction, including the tombstone of a deleted key and
+// collections that only contain tombstones.
+// Returns nil if the key does not exist or has expired.
+func readEntry(tx *txn, now Time, key string) (*Entry, error) {
+	data, err := readStored(tx, key)
+	if err != nil || data == nil || data.Expired(now) {
+		return nil, err
+	}
+
+	entry := &Entry{Key: key, Data: *data}
+
+	if data.Type == DataTypeSortedSet {
+		if set := openSortedSet(tx, key); set != nil {
+			entry.Members = make(map[string]json.RawMessage)
+
+			err := set.members.ForEach(func(k, v []byte) error {
+				entry.Members[string(k)] = append(json.RawMessage{}, v...)
+
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return entry, nil
+}
+
+// Entries returns the complete state of keys, leaving out the keys that do not exist.
+func (db *Database) Entries(now Time, keys ...string) ([]Entry, error) {
+	entries := make([]Entry, 0, len(keys))
+
+	err := db.view(func(tx *txn) error {
+		for _, key := range keys {
+			entry, err := readEntry(tx, now, key)
+			if err != nil {
+				return err
+			}
+
+			if entry != nil {
+				entries = append(entries, *entry)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Merge merges the state of a key held by another node into this database.
+// Returns true if the key's state changed.
+func (db *Database) Merge(now Time, remote Entry) (bool, error) {
+	changed := false
+
+	if remote.Data.Expired(now) {
+		return false, nil
+	}
+
+	err := db.update(func(tx *txn) error {
+		local, err := readEntry(tx, now, remote.Key)
+		if err != nil {
+			return err
+		}
+
+		before := []byte(nil)
+		if local != nil {
+			before, err = local.Digest()
+			if err != nil {
+				return err
+			}
+		}
+
+		err = mergeEntry(tx, now, local, remote)
+		if err != nil {
+			return err
+		}
+
+		merged, err := readEntry(tx, now, remote.Key)
+		if err != nil || merged == nil {
+			return err
+		}
+
+		after, err := merged.Digest()
+		if err != nil {
+			return err
+		}
+
+		changed = !bytes.Equal(before, after)
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return changed, nil
+}
+
+// mergeEntry merges the state of a key held by another node into the local state, which is nil if the key does not
+// exist locally.
+func mergeEntry(tx *txn, now Time, local *Entry, remote Entry) error {
+	key := remote.Key
+
+	if local == nil || !mergeable(local.Data, remote.Data) {
+		if local != nil {
+			newer, err := replaces(*local, remote)
+			if err != nil || !newer {
+				return err
+			}
+
+			err = deleteData(tx, key)
+			if err != nil {
+				return err
+			}
+		}
+
+		return writeEntry(tx, now, remote)
+	}
+
+	data := local.Data
+	data.stamp(remote.Data.Version)
+
+	// The deadline of the newer write wins, and between deadlines written without a version the later one.
+	if local.Data.ExpiryVersion != remote.Data.ExpiryVersion {
+		data.expire(remote.Data.ExpiryVersion, remote.Data.ExpiresAt)
+	} else {
+		data.ExpiresAt = mergeExpiry(local.Data.ExpiresAt, remote.Data.ExpiresAt)
+	}
+
+	switch data.Type {
+	case DataTypeHash:
+		if data.HashValue == nil {
+			data.HashValue = make(map[string]HashField)
+		}
+
+		for name, field := range remote.Data.HashValue {
+			mergeHashField(data.HashValue, name, field)
+		}
+
+	case DataTypeSet:
+		data.SetValue = mergeSets(data.SetValue, data.SetRemoved, remote.Data.SetValue, remote.Data.SetRemoved)
+		data.SetRemoved = mergeTags(data.SetRemoved, remote.Data.SetRemoved)
+
+	case DataTypeSortedSet:
+		err := mergeSortedSetMembers(tx, now, key, remote.Members)
+		if err != nil {
+			return err
+		}
+
+		// The size is maintained by the merge of the members.
+		current, err := readData(tx, now, key)
+		if err != nil {
+			return err
+		}
+
+		data.SortedSetSize = current.SortedSetSize
+	}
+
+	return putData(tx, key, data)
+}
+
+// replaces returns true if the remote state of a key that is not merged member by member replaces the local state.
+// The state of the newer write wins, then the state with the newer deadline. Between states of the same versions, a
+// key wins over the tombstone of its expiry, whose deadline has been replaced on the node that still has the key, and
+// otherwise the greater encoding wins.
+func replaces(local Entry, remote Entry) (bool, error) {
+	if local.Data.Version != remote.Data.Version {
+		return remote.Data.Version.After(local.Data.Version), nil
+	}
+
+	if local.Data.ExpiryVersion != remote.Data.ExpiryVersion {
+		return remote.Data.ExpiryVersion.After(local.Data.ExpiryVersion), nil
+	}
+
+	if local.Data.Deleted != remote.Data.Deleted {
+		return local.Data.Deleted, nil
+	}
+
+	localEncoded, err := local.encode()
+	if err != nil {
+		return false, err
+	}
+
+	remoteEncoded, err := remote.encode()
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Compare(remoteEncoded, localEncoded) > 0, nil
+}
+
+// mergeable returns true if two states of a key are merged member by member rather than one replacing the other.
+func mergeable(local Data, remote Data) bool {
+	if local.Type != remote.Type || local.Deleted || remote.Deleted {
+		return false
+	}
+
+	return local.Type == DataTypeHash || local.Type == DataTypeSet || local.Type == DataTypeSortedSet
+}
+
+// mergeExpiry returns the deadline of a merged key. The later deadline wins, and no deadline is later than any other.
+func mergeExpiry(local Time, remote Time) Time {
+	if local == 0 || remote == 0 {
+		return 0
+	}
+
+	if remote > local {
+		return remote
+	}
+
+	return local
+}
+
+// mergeSets returns the union of the tags of two observed-remove sets, without the tags that either has removed.
+func mergeSets(local map[string][]string, localRemoved []string, remote map[string][]string, remoteRemoved []string) map[string][]string {
+	removed := make(map[string]bool)
+	for _, tag := range append(append([]string{}, localRemoved...), remoteRemoved...) {
+		removed[tag] = true
+	}
+
+	merged := make(map[string][]string)
+
+	for _, set := range []map[string][]string{local, remote} {
+		for member, tags := range set {
+			for _, tag := range tags {
+				if !removed[tag] && !containsString(merged[member], tag) {
+					merged[member] = append(merged[member], tag)
+				}
+			}
+		}
+	}
+
+	return merged
+}
+
+// mergeTags returns the sorted union of two lists of tags.
+func mergeTags(local []string, remote []string) []string {
+	seen := make(map[string]bool)
+	merged := make([]string, 0, len(local)+len(remote))
+
+	for _, tag := range append(append([]string{}, local...), remote...) {
+		if !seen[tag] {
+			seen[tag] = true
+			merged = append(merged, tag)
+		}
+	}
+
+	sort.Strings(merged)
+
+	return merged
+}
+
+// mergeSortedSetMembers writes the members of a sorted set held by another node whose versions are newer.
+func mergeSortedSetMembers(tx *txn, now Time, key string, members map[string]json.RawMessage) error {
+	return updateSortedSet(tx, now, key, Version{}, func(set *sortedSet) error {
+		for member, v := range members {
+			var entry sortedSetEntry
+
+			err := json.Unmarshal(v, &entry)
+			if err != nil {
+				return err
+			}
+
+			_, err = set.put(member, entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// writeEntry writes the state of a key held by another node, which does not exist locally.
+func writeEntry(tx *txn, now Time, entry Entry) error {
+	if entry.Data.Type == DataTypeSortedSet {
+		err := mergeSortedSetMembers(tx, now, entry.Key, entry.Members)
+		if err != nil {
+			return err
+		}
+
+		data, err := readData(tx, now, entry.Key)
+		if err != nil {
+			return err
+		}
+
+		data.ExpiresAt, data.ExpiryVersion = entry.Data.ExpiresAt, entry.Data.ExpiryVersion
+		data.stamp(entry.Data.Version)
+
+		return putData(tx, entry.Key, *data)
+	}
+
+	return putData(tx, entry.Key, entry.Data)
+}
@@ -0,0 +1,251 @@
+package db
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// mergeAll merges the state of every key of one database into another.
+func mergeAll(t *testing.T, from *Database, to *Database, now Time) {
+	keys := make([]string, 0)
+
+	err := from.view(func(tx *txn) error {
+		return tx.bucket(BucketData).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := from.Entries(now, keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if _, err := to.Merge(now, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDatabase_MerkleTree(t *testing.T) {
+	a := newTestDatabase(t)
+	b := newTestDatabase(t)
+	now := Time(1000)
+
+	v1 := Version{Time: 1, Node: "a"}
+	v2 := Version{Time: 2, Node: "b"}
+
+	// The same writes applied in a different order produce the same tree.
+	if _, err := a.SAdd(now, "s", v1, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.SAdd(now, "s", v2, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.SAdd(now, "s", v2, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.SAdd(now, "s", v1, "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, db := range []*Database{a, b} {
+		if _, err := db.HSet(now, "h", v1, "f", "1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	treeA, err := a.MerkleTree(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	treeB, err := b.MerkleTree(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if treeA.Hash("") == nil || !bytes.Equal(treeA.Hash(""), treeB.Hash("")) {
+		t.Fatal("expected equal roots")
+	}
+
+	if _, err := b.HSet(now, "h", v2, "f", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	treeB, err = b.MerkleTree(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the nodes on the path to the leaf of the changed key differ.
+	leaf := merkleLeaf("h")
+
+	for depth := 0; depth <= MerkleDepth; depth++ {
+		path := leaf[:depth]
+
+		if bytes.Equal(treeA.Hash(path), treeB.Hash(path)) {
+			t.Errorf("expected %q to differ", path)
+		}
+
+		for _, sibling := range MerkleChildren(path) {
+			if depth < MerkleDepth && sibling != leaf[:depth+1] && !bytes.Equal(treeA.Hash(sibling), treeB.Hash(sibling)) {
+				t.Errorf("expected %q to be equal", sibling)
+			}
+		}
+	}
+
+	if digests := treeB.Digests(leaf); digests["h"] == nil {
+		t.Errorf("expected a digest for h, got %v", digests)
+	}
+
+	if tree, err := newTestDatabase(t).MerkleTree(now); err != nil || tree.Hash("") != nil {
+		t.Errorf("expected an empty tree, got %v", err)
+	}
+}
+
+func TestDatabase_Merge(t *testing.T) {
+	a := newTestDatabase(t)
+	b := newTestDatabase(t)
+	now := Time(1000)
+
+	va := Version{Time: 1, Node: "a"}
+	vb := Version{Time: 1, Node: "b"}
+
+	// Concurrent writes to each database that were never replicated.
+	if _, err := a.HSet(now, "h", va, "f", "a", "g", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.HSet(now, "h", vb, "f", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.SAdd(now, "s", va, "x", "y"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.SAdd(now, "s", vb, "y"); err != nil {
+		t.Fatal(err)
+	}
+
+	observed, err := a.SObserve(now, "s", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.SRem(now, "s", observed); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.ZAdd(now, "z", va, ZAddOptions{}, SortedSetMember{Member: "m", Score: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.ZAdd(now, "z", vb, ZAddOptions{}, SortedSetMember{Member: "m", Score: 2}, SortedSetMember{Member: "n", Score: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The newer write of a string wins, even though its value is smaller.
+	if _, err := a.Set("str", Version{Time: 2, Node: "a"}, "a", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Set("str", vb, "b", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.LPush(now, "only-b", "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	mergeAll(t, a, b, now)
+	mergeAll(t, b, a, now)
+
+	treeA, err := a.MerkleTree(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	treeB, err := b.MerkleTree(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(treeA.Hash(""), treeB.Hash("")) {
+		t.Fatal("expected the databases to converge")
+	}
+
+	for _, db := range []*Database{a, b} {
+		if fields, err := db.HGetAll(now, "h"); err != nil || !reflect.DeepEqual(fields, map[string]string{"f": "b", "g": "a"}) {
+			t.Errorf("unexpected hash %v, %v", fields, err)
+		}
+
+		if members, err := db.SMembers(now, "s"); err != nil || !reflect.DeepEqual(members, []string{"y"}) {
+			t.Errorf("unexpected set %v, %v", members, err)
+		}
+
+		if members, err := db.ZRange(now, "z", ZRangeQuery{Start: 0, Stop: -1}); err != nil || len(members) != 2 || members[0].Score != 2 {
+			t.Errorf("unexpected sorted set %v, %v", members, err)
+		}
+
+		if value, err := db.Get(now, "str"); err != nil || value != "a" {
+			t.Errorf("expected the newer string to win, got %q, %v", value, err)
+		}
+
+		if values, err := db.LRange(now, "only-b", 0, -1); err != nil || !reflect.DeepEqual(values, []string{"x"}) {
+			t.Errorf("unexpected list %v, %v", values, err)
+		}
+	}
+
+	// Merging a state that is already held changes nothing.
+	entries, err := b.Entries(now, "h", "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("expected a single entry, got %v", entries)
+	}
+
+	if changed, err := a.Merge(now, entries[0]); err != nil || changed {
+		t.Errorf("expected no change, got %v, %v", changed, err)
+	}
+}
+
+func TestDatabase_MergeExpiry(t *testing.T) {
+	a := newTestDatabase(t)
+	now := Time(1000)
+
+	expired := Entry{Key: "k", Data: Data{Type: DataTypeString, StringValue: "v", ExpiresAt: now}}
+	if changed, err := a.Merge(now, expired); err != nil || changed {
+		t.Errorf("expected an expired key to be skipped, got %v, %v", changed, err)
+	}
+
+	if _, err := a.HSet(now, "h", Version{Time: 1, Node: "a"}, "f", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Expire(now, Version{}, "h", 2000); err != nil {
+		t.Fatal(err)
+	}
+
+	// The later deadline wins, and a key without one outlives any deadline.
+	remote := Entry{Key: "h", Data: Data{Type: DataTypeHash, HashValue: map[string]HashField{}}}
+	if changed, err := a.Merge(now, remote); err != nil || !changed {
+		t.Fatalf("expected the deadline to change, got %v, %v", changed, err)
+	}
+
+	if ttl, err := a.TTL(now, "h"); err != nil || ttl != -1 {
+		t.Errorf("expected no deadline, got %d, %v", ttl, err)
+	}
+}
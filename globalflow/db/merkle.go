@@ -0,0 +1,148 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+)
+
+// MerkleDepth is the depth of the hash tree of a logical database.
+// Keys are spread over the leaves by the leading hex digits of the hash of the key, so that each level of the tree
+// divides the keyspace in 16 and a tree has 4096 leaves.
+const MerkleDepth = 3
+
+// MerkleTree is a hash tree over the keyspace of a logical database, which lets two nodes find the keys whose state
+// differs by comparing the hashes of ever smaller key ranges.
+// A node of the tree is identified by its path, the hex digits that the hashes of its keys start with. The root has
+// the empty path.
+type MerkleTree struct {
+	// hashes contains the hash of every node that has keys beneath it, by path.
+	hashes map[string][]byte
+
+	// digests contains the digest of every key in each leaf, by leaf path and key.
+	digests map[string]map[string][]byte
+}
+
+// merkleLeaf returns the path of the leaf that a key belongs to.
+func merkleLeaf(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])[:MerkleDepth]
+}
+
+// MerkleTree builds the hash tree of the database from the state of every key, including collections that only
+// contain tombstones. Expired keys are left out.
+func (db *Database) MerkleTree(now Time) (*MerkleTree, error) {
+	tree := &MerkleTree{
+		hashes:  make(map[string][]byte),
+		digests: make(map[string]map[string][]byte),
+	}
+
+	err := db.view(func(tx *txn) error {
+		return tx.bucket(BucketData).ForEach(func(k, v []byte) error {
+			key := string(k)
+
+			entry, err := readEntry(tx, now, key)
+			if err != nil || entry == nil {
+				return err
+			}
+
+			digest, err := entry.Digest()
+			if err != nil {
+				return err
+			}
+
+			leaf := merkleLeaf(key)
+
+			if tree.digests[leaf] == nil {
+				tree.digests[leaf] = make(map[string][]byte)
+			}
+
+			tree.digests[leaf][key] = digest
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tree.hash("")
+
+	return tree, nil
+}
+
+// hash computes the hash of the node at a path and of the nodes beneath it.
+// A leaf hashes the keys and digests of its keys in key order, and an internal node hashes the hashes of its children.
+// Returns nil if there are no keys beneath the node.
+func (tree *MerkleTree) hash(path string) []byte {
+	h := sha256.New()
+	empty := true
+
+	if len(path) == MerkleDepth {
+		digests := tree.digests[path]
+		if len(digests) == 0 {
+			return nil
+		}
+
+		for _, key := range sortedKeys(digests) {
+			h.Write([]byte(key))
+			h.Write(digests[key])
+		}
+	} else {
+		var none [sha256.Size]byte
+
+		for _, child := range MerkleChildren(path) {
+			sum := tree.hash(child)
+			if sum == nil {
+				h.Write(none[:])
+				continue
+			}
+
+			h.Write(sum)
+			empty = false
+		}
+
+		if empty {
+			return nil
+		}
+	}
+
+	sum := h.Sum(nil)
+	tree.hashes[path] = sum
+
+	return sum
+}
+
+// Hash returns the hash of the node at a path, or nil if there are no keys beneath it.
+func (tree *MerkleTree) Hash(path string) []byte {
+	return tree.hashes[path]
+}
+
+// Digests returns the digest of every key in the leaf at a path, by key.
+func (tree *MerkleTree) Digests(path string) map[string][]byte {
+	return tree.digests[path]
+}
+
+// MerkleChildren returns the paths of the children of an internal node, in order.
+func MerkleChildren(path string) []string {
+	children := make([]string, 0, 16)
+
+	for _, digit := range "0123456789abcdef" {
+		children = append(children, path+string(digit))
+	}
+
+	return children
+}
+
+// sortedKeys returns the keys of a map of digests in order.
+func sortedKeys(digests map[string][]byte) []string {
+	keys := make([]string, 0, len(digests))
+	for key := range digests {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -2,87 +2,69 @@ package db
 
 import (
 	"fmt"
-	bolt "go.etcd.io/bbolt"
-	"time"
 )
 
 // Get gets a value from the database.
+// Expired keys are treated as missing until they are reclaimed by DeleteExpired.
 func (db *Database) Get(now Time, key string) (string, error) {
-	data := Data{}
-
-	err := db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BucketData))
-		if b == nil {
-			panic(fmt.Errorf("bucket %s not found", BucketData))
-		}
-
-		v := b.Get([]byte(key))
-
-		if v == nil {
-			return &ErrorNotFound{Key: key}
-		}
-
-		err := data.Decode(v)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
-
+	data, err := db.Lookup(now, key)
 	if err != nil {
 		return "", err
 	}
 
 	if data.Type != DataTypeString {
-		return "", fmt.Errorf("wrong type")
-	}
-
-	if data.ExpiresAt != 0 && data.ExpiresAt < now {
-		// TODO: expire key
-		return "", &ErrorNotFound{Key: key}
+		return "", &ErrorWrongType{Key: key}
 	}
 
 	return data.StringValue, nil
 }
 
-// Set sets a value in the database.
-func (db *Database) Set(now time.Time, key string, value string, expiresAt Time) error {
+// Set sets a value in the database, unless the key was set by a newer write, in which case it returns false.
+// Writes are ordered by version rather than by the order they arrive in, so every node keeps the value of the same
+// write.
+func (db *Database) Set(key string, version Version, value string, expiresAt Time) (bool, error) {
 	data := Data{
 		Type:        DataTypeString,
 		StringValue: value,
-		ExpiresAt:   expiresAt,
+		Version:     version,
 	}
 
-	err := db.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BucketData))
-		if b == nil {
-			panic(fmt.Errorf("bucket %s not found", BucketData))
-		}
+	applied := false
 
-		encoded, err := data.Encode()
+	err := db.update(func(tx *txn) error {
+		// The version of a deleted key, or of a value that has expired but has not been reclaimed yet, still counts, as
+		// the nodes that the newer write reached first discarded this one.
+		existing, err := readStored(tx, key)
 		if err != nil {
 			return err
 		}
 
-		return b.Put([]byte(key), encoded)
+		if existing != nil && existing.Version.After(version) {
+			return nil
+		}
+
+		// A deadline set by a newer EXPIRE or PERSIST is kept.
+		if existing != nil {
+			data.ExpiresAt, data.ExpiryVersion = existing.ExpiresAt, existing.ExpiryVersion
+		}
+
+		data.expire(version, expiresAt)
+
+		applied = true
+
+		return putData(tx, key, data)
 	})
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	return applied, nil
 }
 
 // Delete deletes a value from the database.
 func (db *Database) Delete(key string) error {
-	err := db.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BucketData))
-		if b == nil {
-			panic(fmt.Errorf("bucket %s not found", BucketData))
-		}
-
-		return b.Delete([]byte(key))
+	err := db.update(func(tx *txn) error {
+		return deleteData(tx, key)
 	})
 	if err != nil {
 		return err
@@ -91,48 +73,114 @@ func (db *Database) Delete(key string) error {
 	return nil
 }
 
-// LPush pushes a value to the head of a list.
-func (db *Database) LPush(key string, value string) error {
-	err := db.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BucketData))
-		if b == nil {
-			panic(fmt.Errorf("bucket %s not found", BucketData))
-		}
+// Expire sets the absolute deadline of a key, unless it was set by a newer write.
+// Returns false if the key does not exist or the deadline was discarded.
+func (db *Database) Expire(now Time, version Version, key string, expiresAt Time) (bool, error) {
+	updated := false
 
-		v := b.Get([]byte(key))
+	err := db.update(func(tx *txn) error {
+		var err error
 
-		var data Data
+		updated, err = expireKey(tx, now, version, key, expiresAt)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
 
-		if v == nil {
-			data = Data{
-				Type:      DataTypeList,
-				ListValue: []string{value},
-			}
-		} else {
-			err := data.Decode(v)
-			if err != nil {
-				return err
-			}
+	return updated, nil
+}
 
-			if data.Type != DataTypeList {
-				return fmt.Errorf("wrong type")
-			}
+// Persist removes the deadline from a key, unless it was set by a newer write.
+// Returns false if the key does not exist or has no deadline, or the write was discarded.
+func (db *Database) Persist(now Time, version Version, key string) (bool, error) {
+	persisted := false
 
-			data.ListValue = append(data.ListValue, value)
+	err := db.update(func(tx *txn) error {
+		data, err := getData(tx, now, key)
+		if err != nil {
+			return err
 		}
 
-		encoded, err := data.Encode()
+		updated, err := expireKey(tx, now, version, key, 0)
 		if err != nil {
 			return err
 		}
 
-		return b.Put([]byte(key), encoded)
+		// A key that is updated exists.
+		persisted = updated && data.ExpiresAt != 0
+
+		return nil
 	})
 	if err != nil {
+		return false, err
+	}
+
+	return persisted, nil
+}
+
+// expireKey writes the deadline of a key, unless it was written by a newer write. The deadline of a key that does not
+// exist, because it was deleted or has not been received yet, is recorded in its tombstone, so that it still applies to
+// an older write of the key that arrives later. A node that has reclaimed an expired key records a newer deadline in
+// its tombstone, and anti-entropy then brings the key back from a node that had not reclaimed it yet.
+// Returns false if the key does not exist or the deadline was discarded.
+func expireKey(tx *txn, now Time, version Version, key string, expiresAt Time) (bool, error) {
+	stored, err := readStored(tx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if stored == nil {
+		stored = &Data{Deleted: true, DeletedAt: now}
+	}
+
+	live := !stored.Deleted && !stored.Expired(now)
+
+	if !stored.expire(version, expiresAt) {
+		return false, nil
+	}
+
+	return live, putData(tx, key, *stored)
+}
+
+// NoExpiry is returned by TTL for keys without a deadline.
+const NoExpiry Time = -1
+
+// TTL returns the time remaining until a key expires, in milliseconds.
+// Returns NoExpiry if the key has no deadline.
+func (db *Database) TTL(now Time, key string) (Time, error) {
+	data, err := db.Lookup(now, key)
+	if err != nil {
+		return 0, err
+	}
+
+	if data.ExpiresAt == 0 {
+		return NoExpiry, nil
+	}
+
+	return data.ExpiresAt - now, nil
+}
+
+// Lookup gets the raw data for a key, regardless of its type.
+func (db *Database) Lookup(now Time, key string) (*Data, error) {
+	var data *Data
+
+	err := db.view(func(tx *txn) error {
+		var err error
+
+		data, err = getData(tx, now, key)
+
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	if data == nil {
+		return nil, &ErrorNotFound{Key: key}
+	}
+
+	return data, nil
 }
 
 type ErrorNotFound struct {
@@ -147,3 +195,17 @@ func IsErrorNotFound(err error) bool {
 	_, ok := err.(*ErrorNotFound)
 	return ok
 }
+
+// ErrorWrongType is returned when a command is used against a key holding a different data type.
+type ErrorWrongType struct {
+	Key string
+}
+
+func (e ErrorWrongType) Error() string {
+	return "WRONGTYPE Operation against a key holding the wrong kind of value"
+}
+
+func IsErrorWrongType(err error) bool {
+	_, ok := err.(*ErrorWrongType)
+	return ok
+}
@@ -3,7 +3,6 @@ package db
 import (
 	"os"
 	"testing"
-	"time"
 )
 
 func TestDatabase_Get(t *testing.T) {
@@ -21,7 +20,7 @@ func TestDatabase_Get(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = db.Set(time.Now(), "foo", "bar", 0)
+	_, err = db.Set("foo", Version{}, "bar", 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -51,7 +50,7 @@ func TestDatabase_Get(t *testing.T) {
 	}
 }
 
-func TestDatabase_LPush(t *testing.T) {
+func TestDatabase_Expire(t *testing.T) {
 	p, err := os.CreateTemp(os.TempDir(), "bolt")
 	if err != nil {
 		t.Fatal(err)
@@ -66,13 +65,89 @@ func TestDatabase_LPush(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = db.LPush("foo", "bar")
+	_, err = db.Set("foo", Version{}, "bar", 0)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = db.LPush("foo", "baz")
+	ttl, err := db.TTL(100, "foo")
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	if ttl != NoExpiry {
+		t.Fatalf("expected no expiry, got %d", ttl)
+	}
+
+	updated, err := db.Expire(100, Version{}, "foo", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !updated {
+		t.Fatal("expected key to be updated")
+	}
+
+	ttl, err = db.TTL(150, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl != 50 {
+		t.Fatalf("expected ttl of 50, got %d", ttl)
+	}
+
+	_, err = db.Get(200, "foo")
+	if !IsErrorNotFound(err) {
+		t.Fatal("expected not found error")
+	}
+
+	persisted, err := db.Persist(150, Version{}, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !persisted {
+		t.Fatal("expected key to be persisted")
+	}
+
+	value, err := db.Get(300, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != "bar" {
+		t.Fatalf("expected %s, got %s", "bar", value)
+	}
+
+	updated, err = db.Expire(100, Version{}, "missing", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated {
+		t.Fatal("expected missing key not to be updated")
+	}
+}
+
+func TestDatabase_SetVersion(t *testing.T) {
+	db := newTestDatabase(t)
+
+	if applied, err := db.Set("foo", Version{Time: 2, Node: "a"}, "new", 0); err != nil || !applied {
+		t.Fatalf("expected the write to apply, got %v, %v", applied, err)
+	}
+
+	// An older write arriving later is discarded.
+	if applied, err := db.Set("foo", Version{Time: 1, Node: "b"}, "old", 0); err != nil || applied {
+		t.Fatalf("expected the older write to be discarded, got %v, %v", applied, err)
+	}
+
+	// Writes made at the same time are ordered by node ID.
+	if applied, err := db.Set("foo", Version{Time: 2, Node: "b"}, "tie", 0); err != nil || !applied {
+		t.Fatalf("expected the write of the greater node to apply, got %v, %v", applied, err)
+	}
+
+	if value, err := db.Get(100, "foo"); err != nil || value != "tie" {
+		t.Errorf("expected tie, got %q, %v", value, err)
+	}
 }
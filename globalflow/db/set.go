@@ -0,0 +1,326 @@
+package db
+
+import "sort"
+
+// Sets are observed-remove sets (OR-Sets).
+// Every add tags the member with the version of the write, and a remove deletes only the tags that the removing node
+// had observed. A member is present while it has at least one tag, so an add that is concurrent with a remove wins on
+// every node, whatever order the two writes arrive in. Removed tags are remembered, so that an add arriving after the
+// remove that observed it is discarded.
+
+// viewSet reads the members of a set within a transaction, with the tags that keep each member present.
+// Returns nil if the key does not exist.
+func viewSet(tx *txn, now Time, key string) (map[string][]string, error) {
+	data, err := getData(tx, now, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	if data.Type != DataTypeSet {
+		return nil, &ErrorWrongType{Key: key}
+	}
+
+	return data.SetValue, nil
+}
+
+// updateSet reads a set within a transaction, applies fn to it and writes the result back as a write of a version.
+// fn receives an empty set if the key does not exist, and is not called if the key was deleted by a newer write.
+// Sets without members are kept for their removed tags, but are treated as missing.
+func updateSet(tx *txn, now Time, key string, version Version, fn func(members map[string][]string, removed map[string]bool) error) error {
+	data, err := readData(tx, now, key)
+	if err != nil {
+		return err
+	}
+
+	if data == nil {
+		data, err = replaceData(tx, key, DataTypeSet, version)
+		if err != nil || data == nil {
+			return err
+		}
+	}
+
+	if data.Type != DataTypeSet {
+		return &ErrorWrongType{Key: key}
+	}
+
+	if data.SetValue == nil {
+		data.SetValue = make(map[string][]string)
+	}
+
+	removed := make(map[string]bool, len(data.SetRemoved))
+	for _, tag := range data.SetRemoved {
+		removed[tag] = true
+	}
+
+	err = fn(data.SetValue, removed)
+	if err != nil {
+		return err
+	}
+
+	data.SetRemoved = make([]string, 0, len(removed))
+	for tag := range removed {
+		data.SetRemoved = append(data.SetRemoved, tag)
+	}
+
+	sort.Strings(data.SetRemoved)
+
+	data.stamp(version)
+
+	return putData(tx, key, *data)
+}
+
+// SAdd adds members to a set, tagging them with the version of the write.
+// Returns the number of members that were not already present.
+func (db *Database) SAdd(now Time, key string, version Version, members ...string) (int, error) {
+	added := 0
+	tag := version.String()
+
+	err := db.update(func(tx *txn) error {
+		return updateSet(tx, now, key, version, func(set map[string][]string, removed map[string]bool) error {
+			if removed[tag] {
+				return nil
+			}
+
+			for _, member := range members {
+				tags := set[member]
+
+				if len(tags) == 0 {
+					added++
+				}
+
+				if !containsString(tags, tag) {
+					set[member] = append(tags, tag)
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return added, nil
+}
+
+// SObserve returns the tags of the given members that are present in a set.
+// The result is passed to SRem on every node, so that only the observed adds are removed.
+func (db *Database) SObserve(now Time, key string, members ...string) (map[string][]string, error) {
+	observed := make(map[string][]string)
+
+	err := db.view(func(tx *txn) error {
+		set, err := viewSet(tx, now, key)
+		if err != nil {
+			return err
+		}
+
+		for _, member := range members {
+			if tags, ok := set[member]; ok {
+				observed[member] = append([]string{}, tags...)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return observed, nil
+}
+
+// SRem removes the observed tags of members from a set.
+// Returns the number of members that are no longer present.
+func (db *Database) SRem(now Time, key string, observed map[string][]string) (int, error) {
+	count := 0
+
+	err := db.update(func(tx *txn) error {
+		return updateSet(tx, now, key, Version{}, func(set map[string][]string, removed map[string]bool) error {
+			for member, tags := range observed {
+				present := len(set[member]) > 0
+				remaining := make([]string, 0)
+
+				for _, tag := range set[member] {
+					if !containsString(tags, tag) {
+						remaining = append(remaining, tag)
+					}
+				}
+
+				for _, tag := range tags {
+					removed[tag] = true
+				}
+
+				if len(remaining) == 0 {
+					delete(set, member)
+				} else {
+					set[member] = remaining
+				}
+
+				if present && len(remaining) == 0 {
+					count++
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// SMembers returns the members of a set in sorted order.
+// Returns an empty list if the key does not exist.
+func (db *Database) SMembers(now Time, key string) ([]string, error) {
+	sets, err := db.sets(now, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return sortedMembers(sets[0]), nil
+}
+
+// SIsMember returns whether each of the given members is present in a set.
+func (db *Database) SIsMember(now Time, key string, members ...string) ([]bool, error) {
+	sets, err := db.sets(now, key)
+	if err != nil {
+		return nil, err
+	}
+
+	present := make([]bool, len(members))
+	for i, member := range members {
+		_, present[i] = sets[0][member]
+	}
+
+	return present, nil
+}
+
+// SCard returns the number of members in a set.
+func (db *Database) SCard(now Time, key string) (int, error) {
+	sets, err := db.sets(now, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(sets[0]), nil
+}
+
+// SUnion returns the members that are present in any of the given sets, in sorted order.
+func (db *Database) SUnion(now Time, keys ...string) ([]string, error) {
+	sets, err := db.sets(now, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	union := make(map[string][]string)
+
+	for _, set := range sets {
+		for member := range set {
+			union[member] = nil
+		}
+	}
+
+	return sortedMembers(union), nil
+}
+
+// SInter returns the members that are present in all the given sets, in sorted order.
+func (db *Database) SInter(now Time, keys ...string) ([]string, error) {
+	sets, err := db.sets(now, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	inter := make(map[string][]string)
+
+	for member := range sets[0] {
+		inter[member] = nil
+
+		for _, set := range sets[1:] {
+			if _, ok := set[member]; !ok {
+				delete(inter, member)
+				break
+			}
+		}
+	}
+
+	return sortedMembers(inter), nil
+}
+
+// SDiff returns the members of the first set that are not present in any of the other sets, in sorted order.
+func (db *Database) SDiff(now Time, keys ...string) ([]string, error) {
+	sets, err := db.sets(now, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := make(map[string][]string)
+
+	for member := range sets[0] {
+		diff[member] = nil
+	}
+
+	for _, set := range sets[1:] {
+		for member := range set {
+			delete(diff, member)
+		}
+	}
+
+	return sortedMembers(diff), nil
+}
+
+// sets reads several sets in a single transaction.
+// Missing keys are returned as empty sets.
+func (db *Database) sets(now Time, keys ...string) ([]map[string][]string, error) {
+	sets := make([]map[string][]string, len(keys))
+
+	err := db.view(func(tx *txn) error {
+		for i, key := range keys {
+			set, err := viewSet(tx, now, key)
+			if err != nil {
+				return err
+			}
+
+			if set == nil {
+				set = make(map[string][]string)
+			}
+
+			sets[i] = set
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sets, nil
+}
+
+// sortedMembers returns the members of a set in sorted order.
+func sortedMembers(set map[string][]string) []string {
+	members := make([]string, 0, len(set))
+
+	for member := range set {
+		members = append(members, member)
+	}
+
+	sort.Strings(members)
+
+	return members
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func newTestDatabase(t *testing.T) *Database {
+	p, err := os.CreateTemp(os.TempDir(), "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewDatabase(p.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestDatabase_SetConcurrentAddAndRemoveConverge(t *testing.T) {
+	a := newTestDatabase(t)
+	b := newTestDatabase(t)
+
+	// Both regions start with the same member.
+	for _, db := range []*Database{a, b} {
+		_, err := db.SAdd(1, "set", Version{Time: 1, Node: "a"}, "x", "y")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Region a removes x while region b concurrently adds it again.
+	observed, err := a.SObserve(1, "set", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := a.SRem(1, "set", observed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 1 {
+		t.Fatalf("expected 1 member removed, got %d", removed)
+	}
+
+	_, err = b.SAdd(1, "set", Version{Time: 2, Node: "b"}, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each region then receives the other's write.
+	_, err = a.SAdd(1, "set", Version{Time: 2, Node: "b"}, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.SRem(1, "set", observed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, db := range []*Database{a, b} {
+		members, err := db.SMembers(1, "set")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(members, []string{"x", "y"}) {
+			t.Fatalf("expected the concurrent add to win, got %v", members)
+		}
+	}
+}
+
+func TestDatabase_SetRemoveBeforeAdd(t *testing.T) {
+	db := newTestDatabase(t)
+
+	// A remove can arrive before the add it observed.
+	_, err := db.SRem(1, "set", map[string][]string{"x": {Version{Time: 1, Node: "a"}.String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.SAdd(1, "set", Version{Time: 1, Node: "a"}, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := db.SCard(1, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Fatalf("expected the removed add to be discarded, got %d members", count)
+	}
+
+	_, err = db.SAdd(1, "other", Version{Time: 2, Node: "a"}, "x", "z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.SAdd(1, "set", Version{Time: 3, Node: "a"}, "x", "y")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inter, err := db.SInter(1, "set", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(inter, []string{"x"}) {
+		t.Fatalf("expected [x], got %v", inter)
+	}
+
+	diff, err := db.SDiff(1, "set", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(diff, []string{"y"}) {
+		t.Fatalf("expected [y], got %v", diff)
+	}
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"io"
+)
+
+// WriteSnapshot writes a consistent copy of the whole database file to w, as of a single read transaction.
+// size is called with the size of the copy before it is written.
+func (db *Database) WriteSnapshot(w io.Writer, size func(int64)) (int64, error) {
+	var written int64
+
+	err := db.db.View(func(tx *bolt.Tx) error {
+		size(tx.Size())
+
+		var err error
+		written, err = tx.WriteTo(w)
+
+		return err
+	})
+
+	return written, err
+}
+
+// RestoreSnapshot replaces the contents of the database with those of a snapshot file written by WriteSnapshot, in a
+// single transaction. Readers observe either the previous contents or the snapshot, never a mix of both. The hints of
+// the database are kept, as the snapshot's hints are for the node that wrote it to deliver.
+func (db *Database) RestoreSnapshot(path string) error {
+	snapshot, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+
+	defer snapshot.Close()
+
+	return snapshot.View(func(source *bolt.Tx) error {
+		return db.db.Update(func(tx *bolt.Tx) error {
+			hintsSize := metaSize(tx, hintsSizeKey)
+			names := make([][]byte, 0)
+
+			err := tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				if string(name) != BucketHints {
+					names = append(names, append([]byte{}, name...))
+				}
+
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, name := range names {
+				err := tx.DeleteBucket(name)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = source.ForEach(func(name []byte, b *bolt.Bucket) error {
+				if string(name) == BucketHints {
+					return nil
+				}
+
+				copied, err := tx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+
+				return copyBucket(copied, b)
+			})
+			if err != nil {
+				return err
+			}
+
+			return addMetaSize(tx, hintsSizeKey, hintsSize-metaSize(tx, hintsSizeKey))
+		})
+	})
+}
+
+// copyBucket copies the keys, nested buckets and sequence of a bucket into an empty bucket.
+func copyBucket(dst *bolt.Bucket, src *bolt.Bucket) error {
+	err := dst.SetSequence(src.Sequence())
+	if err != nil {
+		return err
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(k, v)
+		}
+
+		nested, err := dst.CreateBucket(k)
+		if err != nil {
+			return err
+		}
+
+		return copyBucket(nested, src.Bucket(k))
+	})
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDatabase_Snapshot(t *testing.T) {
+	source := newTestDatabase(t)
+	now := Time(1000)
+
+	if _, err := source.ZAdd(now, "z", Version{Time: 1, Node: "a"}, ZAddOptions{}, SortedSetMember{Member: "m", Score: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := source.AppendWAL(&WALEntry{Origin: "a", Time: 100, Command: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	target := newTestDatabase(t)
+
+	// Whatever the target held before is replaced.
+	if _, err := target.LPush(now, "stale", "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var size int64
+
+	written, err := source.WriteSnapshot(f, func(n int64) {
+		size = n
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if written == 0 || written != size {
+		t.Errorf("expected %d bytes, wrote %d", size, written)
+	}
+
+	if err := target.RestoreSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, err := target.Keys(now, "*"); err != nil || !reflect.DeepEqual(keys, []string{"z"}) {
+		t.Errorf("expected only z, got %v, %v", keys, err)
+	}
+
+	want, err := source.MerkleTree(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := target.MerkleTree(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(want.Hash(""), got.Hash("")) {
+		t.Error("expected the restored data to match")
+	}
+
+	// The log continues from the snapshot's position.
+	entry := &WALEntry{Origin: "a", Time: 200, Command: []byte(`{}`)}
+	if err := target.AppendWAL(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Sequence != 2 {
+		t.Errorf("expected sequence 2, got %d", entry.Sequence)
+	}
+}
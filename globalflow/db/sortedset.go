@@ -0,0 +1,695 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"math"
+	"strconv"
+)
+
+// BucketSortedSets contains a nested bucket for each sorted set.
+// The entry in the data bucket only records the type, size and deadline of the sorted set, so that updating a single
+// member does not rewrite the whole set.
+const BucketSortedSets = "ZSET"
+
+var (
+	bucketSortedSetMembers = []byte("members")
+	bucketSortedSetScores  = []byte("scores")
+)
+
+// ErrScoreNaN is returned when an increment would make a score NaN.
+var ErrScoreNaN = errors.New("resulting score is not a number (NaN)")
+
+// SortedSetMember is a member of a sorted set with its score.
+type SortedSetMember struct {
+	Member string
+	Score  float64
+}
+
+// sortedSetEntry is the stored state of a member of a sorted set.
+// Each member is versioned separately, so that concurrent score updates are resolved by last-writer-wins.
+type sortedSetEntry struct {
+	Score   Score   `json:"score"`
+	Version Version `json:"version"`
+
+	// Deleted marks a member removed by ZREM, so that an older write arriving later does not resurrect it.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Score is a sorted set score. It is encoded in JSON as a string, as JSON numbers cannot be infinite.
+type Score float64
+
+func (score Score) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatFloat(float64(score), 'g', -1, 64))
+}
+
+func (score *Score) UnmarshalJSON(data []byte) error {
+	var s string
+
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+
+	*score = Score(f)
+
+	return nil
+}
+
+// scoreIndexKey returns the key of a member in the score index.
+// Scores are encoded so that their byte order matches their numeric order, followed by the member.
+func scoreIndexKey(score float64, member string) []byte {
+	bits := math.Float64bits(score)
+
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	k := make([]byte, 8+len(member))
+
+	binary.BigEndian.PutUint64(k, bits)
+	copy(k[8:], member)
+
+	return k
+}
+
+// decodeScoreIndexKey decodes a key of the score index.
+func decodeScoreIndexKey(k []byte) SortedSetMember {
+	bits := binary.BigEndian.Uint64(k[:8])
+
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+
+	return SortedSetMember{
+		Member: string(k[8:]),
+		Score:  math.Float64frombits(bits),
+	}
+}
+
+// sortedSet is a sorted set opened within a transaction.
+type sortedSet struct {
+	members *bolt.Bucket
+	scores  *bolt.Bucket
+	size    int
+}
+
+// get returns the stored state of a member.
+func (set *sortedSet) get(member string) (*sortedSetEntry, error) {
+	v := set.members.Get([]byte(member))
+	if v == nil {
+		return nil, nil
+	}
+
+	entry := &sortedSetEntry{}
+
+	err := json.Unmarshal(v, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// score returns the score of a member, or nil if it is not present.
+func (set *sortedSet) score(member string) (*float64, error) {
+	entry, err := set.get(member)
+	if err != nil || entry == nil || entry.Deleted {
+		return nil, err
+	}
+
+	score := float64(entry.Score)
+
+	return &score, nil
+}
+
+// put writes the state of a member if its version is newer than the stored one.
+// Returns true if the write was applied.
+func (set *sortedSet) put(member string, entry sortedSetEntry) (bool, error) {
+	existing, err := set.get(member)
+	if err != nil {
+		return false, err
+	}
+
+	if existing != nil && !entry.Version.After(existing.Version) {
+		return false, nil
+	}
+
+	if existing != nil && !existing.Deleted {
+		err := set.scores.Delete(scoreIndexKey(float64(existing.Score), member))
+		if err != nil {
+			return false, err
+		}
+
+		set.size--
+	}
+
+	if !entry.Deleted {
+		err := set.scores.Put(scoreIndexKey(float64(entry.Score), member), []byte{})
+		if err != nil {
+			return false, err
+		}
+
+		set.size++
+	}
+
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+
+	return true, set.members.Put([]byte(member), encoded)
+}
+
+// openSortedSet opens the nested buckets of a sorted set within a transaction.
+// Returns nil if the sorted set has no buckets.
+func openSortedSet(tx *txn, key string) *sortedSet {
+	root := tx.bucket(BucketSortedSets)
+	if root == nil {
+		panic(fmt.Errorf("bucket %s not found", BucketSortedSets))
+	}
+
+	b := root.Bucket([]byte(key))
+	if b == nil {
+		return nil
+	}
+
+	return &sortedSet{
+		members: b.Bucket(bucketSortedSetMembers),
+		scores:  b.Bucket(bucketSortedSetScores),
+	}
+}
+
+// deleteSortedSet deletes the nested buckets of a sorted set.
+func deleteSortedSet(tx *txn, key string) error {
+	err := tx.bucket(BucketSortedSets).DeleteBucket([]byte(key))
+	if err == bolt.ErrBucketNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// copySortedSet copies the nested buckets of a sorted set to another key, replacing any buckets the key already has.
+func copySortedSet(tx *txn, src string, dst string) error {
+	root := tx.bucket(BucketSortedSets)
+
+	from := root.Bucket([]byte(src))
+	if from == nil {
+		return nil
+	}
+
+	err := deleteSortedSet(tx, dst)
+	if err != nil {
+		return err
+	}
+
+	to, err := root.CreateBucket([]byte(dst))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range [][]byte{bucketSortedSetMembers, bucketSortedSetScores} {
+		b, err := to.CreateBucket(name)
+		if err != nil {
+			return err
+		}
+
+		err = from.Bucket(name).ForEach(func(k, v []byte) error {
+			return b.Put(k, v)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// viewSortedSet opens a sorted set for reading within a transaction.
+// Returns nil if the key does not exist.
+func viewSortedSet(tx *txn, now Time, key string) (*sortedSet, error) {
+	data, err := getData(tx, now, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	if data.Type != DataTypeSortedSet {
+		return nil, &ErrorWrongType{Key: key}
+	}
+
+	set := openSortedSet(tx, key)
+	if set == nil {
+		return nil, nil
+	}
+
+	set.size = data.SortedSetSize
+
+	return set, nil
+}
+
+// updateSortedSet opens a sorted set for writing within a transaction, applies fn to it and records its new size as a
+// write of a version. fn is not called if the key was deleted by a newer write.
+// Sorted sets without members are kept for their tombstones, but are treated as missing.
+func updateSortedSet(tx *txn, now Time, key string, version Version, fn func(set *sortedSet) error) error {
+	data, err := readData(tx, now, key)
+	if err != nil {
+		return err
+	}
+
+	if data != nil && data.Type != DataTypeSortedSet {
+		return &ErrorWrongType{Key: key}
+	}
+
+	if data == nil {
+		data, err = replaceData(tx, key, DataTypeSortedSet, version)
+		if err != nil || data == nil {
+			return err
+		}
+
+		// Discard the members of a sorted set that has expired but not yet been reclaimed.
+		err := deleteSortedSet(tx, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := tx.bucket(BucketSortedSets).CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return err
+	}
+
+	set := &sortedSet{size: data.SortedSetSize}
+
+	set.members, err = b.CreateBucketIfNotExists(bucketSortedSetMembers)
+	if err != nil {
+		return err
+	}
+
+	set.scores, err = b.CreateBucketIfNotExists(bucketSortedSetScores)
+	if err != nil {
+		return err
+	}
+
+	err = fn(set)
+	if err != nil {
+		return err
+	}
+
+	data.SortedSetSize = set.size
+	data.stamp(version)
+
+	return putData(tx, key, *data)
+}
+
+// ZAddOptions contains the conditions of a ZADD command.
+type ZAddOptions struct {
+	// NX only adds new members.
+	NX bool
+
+	// XX only updates existing members.
+	XX bool
+
+	// GT only updates existing members if the new score is greater.
+	GT bool
+
+	// LT only updates existing members if the new score is less.
+	LT bool
+
+	// Incr increments the score of the member instead of setting it.
+	Incr bool
+}
+
+// ZAddResult is the result of a ZADD command.
+type ZAddResult struct {
+	// Added is the number of members that were added.
+	Added int
+
+	// Changed is the number of members that were added or had their score changed.
+	Changed int
+
+	// Applied contains the resulting scores of the members that were written.
+	// Replicating these as an unconditional ZADD reproduces the write on other nodes.
+	Applied []SortedSetMember
+}
+
+// ZAdd adds members to a sorted set or updates their scores, subject to the options.
+func (db *Database) ZAdd(now Time, key string, version Version, options ZAddOptions, members ...SortedSetMember) (*ZAddResult, error) {
+	result := &ZAddResult{
+		Applied: make([]SortedSetMember, 0, len(members)),
+	}
+
+	err := db.update(func(tx *txn) error {
+		return updateSortedSet(tx, now, key, version, func(set *sortedSet) error {
+			for _, m := range members {
+				current, err := set.score(m.Member)
+				if err != nil {
+					return err
+				}
+
+				if (options.NX && current != nil) || (options.XX && current == nil) {
+					continue
+				}
+
+				score := m.Score
+
+				if options.Incr && current != nil {
+					score += *current
+
+					if math.IsNaN(score) {
+						return ErrScoreNaN
+					}
+				}
+
+				if current != nil && ((options.GT && score <= *current) || (options.LT && score >= *current)) {
+					continue
+				}
+
+				applied, err := set.put(m.Member, sortedSetEntry{Score: Score(score), Version: version})
+				if err != nil {
+					return err
+				}
+
+				if !applied {
+					continue
+				}
+
+				result.Applied = append(result.Applied, SortedSetMember{Member: m.Member, Score: score})
+
+				if current == nil {
+					result.Added++
+					result.Changed++
+				} else if *current != score {
+					result.Changed++
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ZRem removes members from a sorted set.
+// Members that have been written by a newer version are left untouched.
+// Returns the number of members removed.
+func (db *Database) ZRem(now Time, key string, version Version, members ...string) (int, error) {
+	removed := 0
+
+	err := db.update(func(tx *txn) error {
+		return updateSortedSet(tx, now, key, version, func(set *sortedSet) error {
+			for _, member := range members {
+				current, err := set.score(member)
+				if err != nil {
+					return err
+				}
+
+				applied, err := set.put(member, sortedSetEntry{Version: version, Deleted: true})
+				if err != nil {
+					return err
+				}
+
+				if applied && current != nil {
+					removed++
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// ZScore returns the scores of members of a sorted set, with nil for members that are not present.
+func (db *Database) ZScore(now Time, key string, members ...string) ([]*float64, error) {
+	scores := make([]*float64, len(members))
+
+	err := db.view(func(tx *txn) error {
+		set, err := viewSortedSet(tx, now, key)
+		if err != nil || set == nil {
+			return err
+		}
+
+		for i, member := range members {
+			scores[i], err = set.score(member)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return scores, nil
+}
+
+// ZCard returns the number of members in a sorted set.
+func (db *Database) ZCard(now Time, key string) (int, error) {
+	size := 0
+
+	err := db.view(func(tx *txn) error {
+		set, err := viewSortedSet(tx, now, key)
+		if err != nil || set == nil {
+			return err
+		}
+
+		size = set.size
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// ZRank returns the rank of a member in a sorted set, ordered from the lowest score or the highest if rev is set.
+// Returns ErrorNotFound if the key or member does not exist.
+func (db *Database) ZRank(now Time, key string, member string, rev bool) (int, float64, error) {
+	rank := 0
+	score := 0.0
+
+	err := db.view(func(tx *txn) error {
+		set, err := viewSortedSet(tx, now, key)
+		if err != nil {
+			return err
+		}
+
+		if set == nil {
+			return &ErrorNotFound{Key: key}
+		}
+
+		current, err := set.score(member)
+		if err != nil {
+			return err
+		}
+
+		if current == nil {
+			return &ErrorNotFound{Key: key}
+		}
+
+		score = *current
+		target := string(scoreIndexKey(score, member))
+
+		c := set.scores.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < target; k, _ = c.Next() {
+			rank++
+		}
+
+		if rev {
+			rank = set.size - 1 - rank
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return rank, score, nil
+}
+
+// ScoreBound is the minimum or maximum of a score range.
+type ScoreBound struct {
+	Score     float64
+	Exclusive bool
+}
+
+// LexBound is the minimum or maximum of a lexicographical range.
+type LexBound struct {
+	Value     string
+	Exclusive bool
+
+	// Infinite is -1 for the "-" bound and 1 for the "+" bound.
+	Infinite int
+}
+
+// ZRangeBy selects how a range of a sorted set is specified.
+type ZRangeBy int
+
+const (
+	ZRangeByRank ZRangeBy = iota
+	ZRangeByScore
+	ZRangeByLex
+)
+
+// ZRangeQuery is a range of a sorted set.
+type ZRangeQuery struct {
+	By ZRangeBy
+
+	// Start and Stop are the inclusive ranks of a rank range, which may be negative.
+	Start int
+	Stop  int
+
+	// Min and Max bound a score range.
+	Min ScoreBound
+	Max ScoreBound
+
+	// LexMin and LexMax bound a lexicographical range.
+	LexMin LexBound
+	LexMax LexBound
+
+	// Rev orders the range from the highest score.
+	Rev bool
+
+	// Offset and Count limit a score or lexicographical range. A negative count returns every member after the offset.
+	Offset int
+	Count  int
+}
+
+// aboveMin returns true if a member is on or above the minimum of the range.
+func (query *ZRangeQuery) aboveMin(m SortedSetMember) bool {
+	switch query.By {
+	case ZRangeByScore:
+		return m.Score > query.Min.Score || (m.Score == query.Min.Score && !query.Min.Exclusive)
+	case ZRangeByLex:
+		return query.LexMin.Infinite < 0 || (query.LexMin.Infinite == 0 && (m.Member > query.LexMin.Value || (m.Member == query.LexMin.Value && !query.LexMin.Exclusive)))
+	}
+
+	return true
+}
+
+// belowMax returns true if a member is on or below the maximum of the range.
+func (query *ZRangeQuery) belowMax(m SortedSetMember) bool {
+	switch query.By {
+	case ZRangeByScore:
+		return m.Score < query.Max.Score || (m.Score == query.Max.Score && !query.Max.Exclusive)
+	case ZRangeByLex:
+		return query.LexMax.Infinite > 0 || (query.LexMax.Infinite == 0 && (m.Member < query.LexMax.Value || (m.Member == query.LexMax.Value && !query.LexMax.Exclusive)))
+	}
+
+	return true
+}
+
+// ZRange returns a range of a sorted set, in order.
+func (db *Database) ZRange(now Time, key string, query ZRangeQuery) ([]SortedSetMember, error) {
+	members := make([]SortedSetMember, 0)
+
+	err := db.view(func(tx *txn) error {
+		set, err := viewSortedSet(tx, now, key)
+		if err != nil || set == nil {
+			return err
+		}
+
+		start, stop := 0, set.size
+		offset, count := query.Offset, query.Count
+
+		if query.By == ZRangeByRank {
+			start, stop = listRange(set.size, query.Start, query.Stop)
+			offset, count = 0, -1
+		}
+
+		c := set.scores.Cursor()
+		first, next := c.First, c.Next
+		if query.Rev {
+			first, next = c.Last, c.Prev
+		}
+
+		rank := 0
+
+		for k, _ := first(); k != nil && rank < stop; k, _ = next() {
+			m := decodeScoreIndexKey(k)
+			rank++
+
+			if rank <= start {
+				continue
+			}
+
+			// Ranges are walked from the minimum, or from the maximum if reversed.
+			inRange, pastEnd := query.aboveMin(m), !query.belowMax(m)
+			if query.Rev {
+				inRange, pastEnd = query.belowMax(m), !query.aboveMin(m)
+			}
+
+			if pastEnd {
+				break
+			}
+
+			if !inRange {
+				continue
+			}
+
+			if offset > 0 {
+				offset--
+				continue
+			}
+
+			if count == 0 {
+				break
+			}
+
+			members = append(members, m)
+			count--
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// ZIncrBy increments the score of a member of a sorted set, adding it if it does not exist.
+// Returns the score after the increment.
+func (db *Database) ZIncrBy(now Time, key string, version Version, member string, increment float64) (float64, error) {
+	result, err := db.ZAdd(now, key, version, ZAddOptions{Incr: true}, SortedSetMember{Member: member, Score: increment})
+	if err != nil {
+		return 0, err
+	}
+
+	if len(result.Applied) == 0 {
+		return 0, fmt.Errorf("increment of %s was not applied", member)
+	}
+
+	return result.Applied[0].Score, nil
+}
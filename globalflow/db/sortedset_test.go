@@ -0,0 +1,199 @@
+package db
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func members(set []SortedSetMember) []string {
+	names := make([]string, len(set))
+
+	for i, m := range set {
+		names[i] = m.Member
+	}
+
+	return names
+}
+
+func TestDatabase_ZRange(t *testing.T) {
+	db := newTestDatabase(t)
+
+	_, err := db.ZAdd(1, "z", Version{Time: 1, Node: "a"}, ZAddOptions{},
+		SortedSetMember{Member: "d", Score: 10},
+		SortedSetMember{Member: "a", Score: -2.5},
+		SortedSetMember{Member: "c", Score: 3},
+		SortedSetMember{Member: "b", Score: 3},
+		SortedSetMember{Member: "e", Score: math.Inf(1)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query    ZRangeQuery
+		expected []string
+	}{
+		{ZRangeQuery{Start: 0, Stop: -1, Count: -1}, []string{"a", "b", "c", "d", "e"}},
+		{ZRangeQuery{Start: 1, Stop: 2, Count: -1}, []string{"b", "c"}},
+		{ZRangeQuery{Start: 0, Stop: 1, Rev: true, Count: -1}, []string{"e", "d"}},
+		{ZRangeQuery{By: ZRangeByScore, Min: ScoreBound{Score: 3}, Max: ScoreBound{Score: 10, Exclusive: true}, Count: -1}, []string{"b", "c"}},
+		{ZRangeQuery{By: ZRangeByScore, Min: ScoreBound{Score: math.Inf(-1)}, Max: ScoreBound{Score: math.Inf(1)}, Offset: 1, Count: 2}, []string{"b", "c"}},
+		{ZRangeQuery{By: ZRangeByScore, Min: ScoreBound{Score: 0}, Max: ScoreBound{Score: 10}, Rev: true, Count: -1}, []string{"d", "c", "b"}},
+		{ZRangeQuery{By: ZRangeByLex, LexMin: LexBound{Value: "b"}, LexMax: LexBound{Infinite: 1}, Count: -1}, []string{"b", "c", "d", "e"}},
+	}
+
+	for _, test := range tests {
+		result, err := db.ZRange(1, "z", test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(members(result), test.expected) {
+			t.Errorf("expected %v for %+v, got %v", test.expected, test.query, members(result))
+		}
+	}
+
+	rank, score, err := db.ZRank(1, "z", "c", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rank != 2 || score != 3 {
+		t.Errorf("expected rank 2 with score 3, got rank %d with score %f", rank, score)
+	}
+
+	size, err := db.ZCard(1, "z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 5 {
+		t.Errorf("expected 5 members, got %d", size)
+	}
+}
+
+func TestDatabase_ZAddConcurrentScoresConverge(t *testing.T) {
+	a := newTestDatabase(t)
+	b := newTestDatabase(t)
+
+	first := Version{Time: 4, Node: "a"}
+	second := Version{Time: 4, Node: "b"}
+
+	// The same member is given different scores concurrently, and each node applies the writes in a different order.
+	for _, write := range []struct {
+		db      *Database
+		version Version
+		score   float64
+	}{
+		{a, first, 1},
+		{a, second, 2},
+		{b, second, 2},
+		{b, first, 1},
+	} {
+		_, err := write.db.ZAdd(1, "z", write.version, ZAddOptions{}, SortedSetMember{Member: "x", Score: write.score})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, db := range []*Database{a, b} {
+		scores, err := db.ZScore(1, "z", "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if scores[0] == nil || *scores[0] != 2 {
+			t.Fatalf("expected score 2, got %v", scores[0])
+		}
+	}
+
+	// A removal is not undone by an older write arriving later.
+	removed, err := a.ZRem(1, "z", Version{Time: 5, Node: "a"}, "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 1 {
+		t.Fatalf("expected 1 member removed, got %d", removed)
+	}
+
+	_, err = a.ZAdd(1, "z", Version{Time: 3, Node: "b"}, ZAddOptions{}, SortedSetMember{Member: "x", Score: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := a.ZCard(1, "z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != 0 {
+		t.Fatalf("expected an empty sorted set, got %d members", size)
+	}
+
+	_, err = a.Lookup(1, "z")
+	if !IsErrorNotFound(err) {
+		t.Fatal("expected an empty sorted set to be treated as missing")
+	}
+}
+
+func TestDatabase_ZAddOptions(t *testing.T) {
+	db := newTestDatabase(t)
+
+	_, err := db.ZAdd(1, "z", Version{Time: 1, Node: "a"}, ZAddOptions{}, SortedSetMember{Member: "x", Score: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.ZAdd(1, "z", Version{Time: 2, Node: "a"}, ZAddOptions{GT: true},
+		SortedSetMember{Member: "x", Score: 4},
+		SortedSetMember{Member: "y", Score: 1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Added != 1 || len(result.Applied) != 1 || result.Applied[0].Member != "y" {
+		t.Fatalf("expected only y to be added, got %+v", result)
+	}
+
+	score, err := db.ZIncrBy(1, "z", Version{Time: 3, Node: "a"}, "x", 2.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if score != 7.5 {
+		t.Fatalf("expected 7.5, got %f", score)
+	}
+
+	_, err = db.Set("z", Version{Time: 4, Node: "a"}, "value", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.ZAdd(1, "z", Version{Time: 4, Node: "a"}, ZAddOptions{}, SortedSetMember{Member: "z", Score: 1})
+	if !IsErrorWrongType(err) {
+		t.Fatalf("expected wrong type error, got %v", err)
+	}
+
+	err = db.Delete("z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Overwriting a sorted set discards its members.
+	_, err = db.ZAdd(1, "z", Version{Time: 5, Node: "a"}, ZAddOptions{}, SortedSetMember{Member: "z", Score: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remaining, err := db.ZRange(1, "z", ZRangeQuery{Start: 0, Stop: -1, Count: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(members(remaining), []string{"z"}) {
+		t.Fatalf("expected [z], got %v", members(remaining))
+	}
+}
@@ -0,0 +1,142 @@
+package db
+
+import "fmt"
+
+// getData reads the data for a key within a transaction.
+// Returns nil if the key does not exist, has expired or only contains tombstones.
+func getData(tx *txn, now Time, key string) (*Data, error) {
+	data, err := readData(tx, now, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil || data.Empty() {
+		return nil, nil
+	}
+
+	return data, nil
+}
+
+// readData reads the data for a key within a transaction, including collections that only contain tombstones.
+// Returns nil if the key does not exist, has expired or has been deleted.
+func readData(tx *txn, now Time, key string) (*Data, error) {
+	data, err := readStored(tx, key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	if data.Deleted || data.Expired(now) {
+		return nil, nil
+	}
+
+	return data, nil
+}
+
+// readStored reads the data stored for a key within a transaction, including the tombstone of a deleted key and data
+// that has expired but has not been reclaimed yet.
+// Returns nil if nothing is stored for the key.
+func readStored(tx *txn, key string) (*Data, error) {
+	b := tx.bucket(BucketData)
+	if b == nil {
+		panic(fmt.Errorf("bucket %s not found", BucketData))
+	}
+
+	v := b.Get([]byte(key))
+	if v == nil {
+		return nil, nil
+	}
+
+	data := &Data{}
+
+	err := data.Decode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// putData writes data to the data bucket and keeps the indexes up to date.
+func putData(tx *txn, key string, data Data) error {
+	b := tx.bucket(BucketData)
+	if b == nil {
+		panic(fmt.Errorf("bucket %s not found", BucketData))
+	}
+
+	err := releaseData(tx, key, &data)
+	if err != nil {
+		return err
+	}
+
+	if data.Deleted {
+		err := tx.bucket(BucketTombstones).Put(expiryIndexKey(data.DeletedAt, key), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+
+	if data.ExpiresAt != 0 && !data.Deleted {
+		err := tx.bucket(BucketExpiry).Put(expiryIndexKey(data.ExpiresAt, key), []byte{})
+		if err != nil {
+			return err
+		}
+	}
+
+	encoded, err := data.Encode()
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(key), encoded)
+}
+
+// deleteData deletes a key from the data bucket, along with its index entries and nested buckets.
+func deleteData(tx *txn, key string) error {
+	b := tx.bucket(BucketData)
+	if b == nil {
+		panic(fmt.Errorf("bucket %s not found", BucketData))
+	}
+
+	err := releaseData(tx, key, nil)
+	if err != nil {
+		return err
+	}
+
+	return b.Delete([]byte(key))
+}
+
+// releaseData removes the index entries and nested buckets of the current value of a key,
+// before it is replaced or deleted. Nested buckets are kept if the replacement has the same type.
+func releaseData(tx *txn, key string, replacement *Data) error {
+	v := tx.bucket(BucketData).Get([]byte(key))
+	if v == nil {
+		return nil
+	}
+
+	var previous Data
+
+	err := previous.Decode(v)
+	if err != nil {
+		return err
+	}
+
+	if previous.ExpiresAt != 0 && !previous.Deleted {
+		err := tx.bucket(BucketExpiry).Delete(expiryIndexKey(previous.ExpiresAt, key))
+		if err != nil {
+			return err
+		}
+	}
+
+	if previous.Deleted {
+		err := tx.bucket(BucketTombstones).Delete(expiryIndexKey(previous.DeletedAt, key))
+		if err != nil {
+			return err
+		}
+	}
+
+	if previous.Type == DataTypeSortedSet && (replacement == nil || replacement.Type != DataTypeSortedSet) {
+		return deleteSortedSet(tx, key)
+	}
+
+	return nil
+}
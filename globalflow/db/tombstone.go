@@ -0,0 +1,130 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// A key removed by DEL or by expiry is replaced by a tombstone, which records the version of the deletion, so that a
+// write made before the deletion that arrives after it is discarded rather than resurrecting the key, and so that
+// anti-entropy can tell a deleted key from one that a node has not received yet. Tombstones are treated as missing by
+// every read, and are purged once every node has had the chance to receive the deletion.
+
+// replaceData returns empty data of a type for a write of a version to a key that does not exist, or nil if the key was
+// deleted by a newer write, in favour of which the write is discarded. Data that replaces a tombstone keeps its
+// version, so that writes older than the deletion are still discarded, and its deadline, so that a deadline recorded
+// before the key arrived still applies to it. Writes without a version are not ordered against deletions, and are
+// never discarded.
+func replaceData(tx *txn, key string, dataType DataType, version Version) (*Data, error) {
+	stored, err := readStored(tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored == nil {
+		return &Data{Type: dataType}, nil
+	}
+
+	// A value that has expired but has not been reclaimed yet is replaced as if the tombstone of its expiry had been
+	// written, so that every node discards the same writes.
+	if !stored.Deleted {
+		stored = &Data{Version: stored.Version, ExpiryVersion: stored.ExpiryVersion, Deleted: true}
+	}
+
+	if version != (Version{}) && !version.After(stored.Version) {
+		return nil, nil
+	}
+
+	return &Data{
+		Type:          dataType,
+		Version:       stored.Version,
+		ExpiresAt:     stored.ExpiresAt,
+		ExpiryVersion: stored.ExpiryVersion,
+	}, nil
+}
+
+// stamp records a write of a version, unless the data has already been written by a newer one.
+func (data *Data) stamp(version Version) {
+	if version.After(data.Version) {
+		data.Version = version
+	}
+}
+
+// deleteKey replaces a key with a tombstone of a deletion, unless the key has been written since.
+// Returns false if the deletion was discarded.
+func deleteKey(tx *txn, now Time, key string, version Version) (bool, error) {
+	stored, err := readStored(tx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if stored != nil && !version.After(stored.Version) {
+		return false, nil
+	}
+
+	tombstone := Data{Version: version, Deleted: true, DeletedAt: now}
+
+	// The deletion removes the key's deadline, unless it was written since.
+	if stored != nil {
+		tombstone.ExpiresAt, tombstone.ExpiryVersion = stored.ExpiresAt, stored.ExpiryVersion
+	}
+
+	tombstone.expire(version, 0)
+
+	return true, putData(tx, key, tombstone)
+}
+
+// PurgeTombstones removes up to limit tombstones of keys deleted before a time, oldest first.
+// Returns the number of tombstones removed.
+func (db *Database) PurgeTombstones(before Time, limit int) (int, error) {
+	purged := 0
+
+	err := db.update(func(tx *txn) error {
+		index := tx.bucket(BucketTombstones)
+
+		// Collect the entries first, as the cursor must not be used while the bucket is modified.
+		entries := make([][]byte, 0, limit)
+
+		c := index.Cursor()
+		for k, _ := c.First(); k != nil && len(entries) < limit; k, _ = c.Next() {
+			if Time(binary.BigEndian.Uint64(k[:8])) >= before {
+				break
+			}
+
+			entries = append(entries, append([]byte{}, k...))
+		}
+
+		for _, entry := range entries {
+			key := string(entry[8:])
+
+			data, err := readStored(tx, key)
+			if err != nil {
+				return err
+			}
+
+			// Skip stale index entries whose key has since been written or deleted again.
+			if data == nil || !data.Deleted || !bytes.Equal(expiryIndexKey(data.DeletedAt, key), entry) {
+				err := index.Delete(entry)
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			err = deleteData(tx, key)
+			if err != nil {
+				return err
+			}
+
+			purged++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,276 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDatabase_DeleteKeys(t *testing.T) {
+	db := newTestDatabase(t)
+	now := Time(1000)
+
+	if _, err := db.Set("a", Version{Time: 1, Node: "a"}, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.HSet(now, "h", Version{Time: 1, Node: "a"}, "f", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := db.DeleteKeys(now, Version{Time: 2, Node: "b"}, "a", "h", "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 2 {
+		t.Errorf("expected 2 keys deleted, got %d", deleted)
+	}
+
+	// Tombstones are treated as missing.
+	if keys, err := db.Keys(now, "*"); err != nil || len(keys) != 0 {
+		t.Errorf("expected no keys, got %v, %v", keys, err)
+	}
+
+	if keys, _, err := db.Scan(now, "", 10, "", ""); err != nil || len(keys) != 0 {
+		t.Errorf("expected no keys, got %v, %v", keys, err)
+	}
+
+	if count, err := db.Exists(now, "a", "h", "missing"); err != nil || count != 0 {
+		t.Errorf("expected no keys to exist, got %d, %v", count, err)
+	}
+
+	if _, err := db.Get(now, "a"); !IsErrorNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	// Writes made before the deletion are discarded when they arrive after it, including for keys that did not exist.
+	if applied, err := db.Set("missing", Version{Time: 1, Node: "c"}, "old", 0); err != nil || applied {
+		t.Errorf("expected the older write to be discarded, got %v, %v", applied, err)
+	}
+
+	if _, err := db.HSet(now, "h", Version{Time: 1, Node: "c"}, "g", "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	if fields, err := db.HGetAll(now, "h"); err != nil || len(fields) != 0 {
+		t.Errorf("expected the older field to be discarded, got %v, %v", fields, err)
+	}
+
+	// A deletion older than the key's most recent write is discarded.
+	if _, err := db.Set("a", Version{Time: 4, Node: "a"}, "new", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted, err := db.DeleteKeys(now, Version{Time: 3, Node: "b"}, "a"); err != nil || deleted != 0 {
+		t.Errorf("expected the older deletion to be discarded, got %d, %v", deleted, err)
+	}
+
+	if value, err := db.Get(now, "a"); err != nil || value != "new" {
+		t.Errorf("expected new, got %q, %v", value, err)
+	}
+}
+
+func TestDatabase_ExpiryTombstone(t *testing.T) {
+	db := newTestDatabase(t)
+
+	if _, err := db.Set("a", Version{Time: 2, Node: "a"}, "value", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted, err := db.DeleteExpired(200, 10); err != nil || !reflect.DeepEqual(deleted, []string{"a"}) {
+		t.Fatalf("expected a to expire, got %v, %v", deleted, err)
+	}
+
+	if applied, err := db.Set("a", Version{Time: 1, Node: "b"}, "old", 0); err != nil || applied {
+		t.Errorf("expected the older write to be discarded, got %v, %v", applied, err)
+	}
+
+	if applied, err := db.Set("a", Version{Time: 3, Node: "b"}, "new", 0); err != nil || !applied {
+		t.Errorf("expected the newer write to apply, got %v, %v", applied, err)
+	}
+}
+
+func TestDatabase_PurgeTombstones(t *testing.T) {
+	db := newTestDatabase(t)
+
+	if _, err := db.DeleteKeys(100, Version{Time: 1, Node: "a"}, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.DeleteKeys(200, Version{Time: 2, Node: "a"}, "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing a key removes its tombstone.
+	if _, err := db.Set("c", Version{Time: 3, Node: "a"}, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := db.PurgeTombstones(200, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged != 1 {
+		t.Errorf("expected 1 tombstone purged, got %d", purged)
+	}
+
+	// Once its tombstone is purged, a key no longer discards older writes.
+	if applied, err := db.Set("a", Version{Time: 0, Node: "b"}, "old", 0); err != nil || !applied {
+		t.Errorf("expected the write to apply, got %v, %v", applied, err)
+	}
+
+	if applied, err := db.Set("b", Version{Time: 1, Node: "b"}, "old", 0); err != nil || applied {
+		t.Errorf("expected the write to be discarded, got %v, %v", applied, err)
+	}
+
+	if purged, err := db.PurgeTombstones(300, 10); err != nil || purged != 1 {
+		t.Errorf("expected 1 tombstone purged, got %d, %v", purged, err)
+	}
+}
+
+func TestDatabase_MergeTombstone(t *testing.T) {
+	a, b := newTestDatabase(t), newTestDatabase(t)
+	now := Time(1000)
+
+	for _, db := range []*Database{a, b} {
+		if _, err := db.Set("k", Version{Time: 1, Node: "a"}, "value", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := a.DeleteKeys(now, Version{Time: 2, Node: "a"}, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	mergeAll(t, a, b, now)
+
+	if _, err := b.Get(now, "k"); !IsErrorNotFound(err) {
+		t.Errorf("expected the deletion to be repaired, got %v", err)
+	}
+
+	// A write made after the deletion wins over the tombstone.
+	if _, err := b.Set("k", Version{Time: 3, Node: "b"}, "new", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	mergeAll(t, b, a, now)
+
+	if value, err := a.Get(now, "k"); err != nil || value != "new" {
+		t.Errorf("expected new, got %q, %v", value, err)
+	}
+}
+
+func TestDatabase_RenameTombstone(t *testing.T) {
+	db := newTestDatabase(t)
+	now := Time(1000)
+
+	if _, err := db.Set("src", Version{Time: 1, Node: "a"}, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Rename(now, Version{Time: 3, Node: "a"}, "src", "dst"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A write of the source made before the rename is discarded when it arrives after it.
+	if applied, err := db.Set("src", Version{Time: 2, Node: "b"}, "old", 0); err != nil || applied {
+		t.Errorf("expected the older write to be discarded, got %v, %v", applied, err)
+	}
+
+	if _, err := db.Get(now, "src"); !IsErrorNotFound(err) {
+		t.Errorf("expected src to stay missing, got %v", err)
+	}
+
+	if value, err := db.Get(now, "dst"); err != nil || value != "value" {
+		t.Errorf("expected value, got %q, %v", value, err)
+	}
+
+	// A write of the destination made after the rename is kept, whichever arrives first.
+	if _, err := db.Set("other", Version{Time: 4, Node: "a"}, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Set("target", Version{Time: 6, Node: "b"}, "newer", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Rename(now, Version{Time: 5, Node: "a"}, "other", "target"); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := db.Get(now, "target"); err != nil || value != "newer" {
+		t.Errorf("expected newer, got %q, %v", value, err)
+	}
+
+	if _, err := db.Get(now, "other"); !IsErrorNotFound(err) {
+		t.Errorf("expected other to be renamed away, got %v", err)
+	}
+}
+
+func TestDatabase_PersistAfterExpiry(t *testing.T) {
+	a, b := newTestDatabase(t), newTestDatabase(t)
+
+	for _, db := range []*Database{a, b} {
+		if _, err := db.Set("k", Version{Time: 1, Node: "a"}, "value", 100); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The PERSIST reaches b before the key expires, and a after it has reclaimed the key.
+	if persisted, err := b.Persist(50, Version{Time: 2, Node: "b"}, "k"); err != nil || !persisted {
+		t.Fatalf("expected the key to be persisted, got %v, %v", persisted, err)
+	}
+
+	if _, err := a.DeleteExpired(200, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if persisted, err := a.Persist(200, Version{Time: 2, Node: "b"}, "k"); err != nil || persisted {
+		t.Fatalf("expected the reclaimed key not to be persisted, got %v, %v", persisted, err)
+	}
+
+	// Anti-entropy brings the key back on a rather than expiring it on b.
+	mergeAll(t, b, a, 200)
+	mergeAll(t, a, b, 200)
+
+	for _, db := range []*Database{a, b} {
+		if value, err := db.Get(200, "k"); err != nil || value != "value" {
+			t.Errorf("expected value, got %q, %v", value, err)
+		}
+	}
+}
+
+func TestDatabase_ExpireBeforeWrite(t *testing.T) {
+	db := newTestDatabase(t)
+
+	// The deadline of an EXPIRE that arrives before the write it was made after is kept for the key.
+	if updated, err := db.Expire(10, Version{Time: 2, Node: "a"}, "k", 100); err != nil || updated {
+		t.Fatalf("expected the missing key not to be updated, got %v, %v", updated, err)
+	}
+
+	if _, err := db.HSet(10, "k", Version{Time: 1, Node: "a"}, "f", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl, err := db.TTL(10, "k"); err != nil || ttl != 90 {
+		t.Errorf("expected a TTL of 90, got %d, %v", ttl, err)
+	}
+
+	// A DEL removes the deadline, and an older EXPIRE arriving later is discarded.
+	if _, err := db.DeleteKeys(20, Version{Time: 4, Node: "a"}, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Expire(20, Version{Time: 3, Node: "b"}, "k", 50); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.HSet(20, "k", Version{Time: 5, Node: "a"}, "f", "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl, err := db.TTL(20, "k"); err != nil || ttl != NoExpiry {
+		t.Errorf("expected no deadline, got a TTL of %d, %v", ttl, err)
+	}
+}
@@ -1,26 +1,297 @@
 package db
 
+import (
+	"encoding/binary"
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The write-ahead log records every replicated command applied to the database, so that they can be replayed or resent
+// to other nodes. BucketWAL contains a bucket for each node that originated commands, in which entries are keyed by a
+// sequence number that increases with every entry of that node. The log is shared by every logical database.
+
+// walSizeKey is the key in the metadata bucket that records the total size of the log's entries in bytes.
+var walSizeKey = []byte("wal:size")
+
+// WALEntry is a command recorded in the write-ahead log.
 type WALEntry struct {
-	Index int64
-}
-
-//
-//func (db *Database) WriteWAL(entry WALEntry) error {
-//	return db.db.Update(func(tx *bolt.Tx) error {
-//		b := tx.Bucket([]byte(BucketWAL))
-//		if b == nil {
-//			panic(fmt.Errorf("bucket %s not found", BucketWAL))
-//		}
-//
-//		encoded, err := entry.Encode()
-//		if err != nil {
-//			return err
-//		}
-//
-//		err = b.Put([]byte(entry.ID()), encoded)
-//		if err != nil {
-//			return err
-//		}
-//
-//		return nil
-//	})
+	// Origin is the ID of the node that originated the command.
+	Origin string `json:"-"`
+
+	// Sequence is the position of the entry among the entries of its origin.
+	Sequence uint64 `json:"-"`
+
+	// Time is the wall clock time at which the entry was written, which retention by age is based on.
+	Time Time `json:"time"`
+
+	// Command is the encoded command.
+	Command json.RawMessage `json:"command"`
+}
+
+// walKey returns the key of an entry in the bucket of its origin.
+func walKey(sequence uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, sequence)
+
+	return k
+}
+
+// decodeWALEntry decodes an entry of the bucket of an origin.
+func decodeWALEntry(origin string, k []byte, v []byte) (WALEntry, error) {
+	entry := WALEntry{}
+
+	err := json.Unmarshal(v, &entry)
+	if err != nil {
+		return WALEntry{}, err
+	}
+
+	entry.Origin = origin
+	entry.Sequence = binary.BigEndian.Uint64(k)
+
+	return entry, nil
+}
+
+// walSize returns the total size of the log's entries in bytes.
+func walSize(tx *bolt.Tx) int64 {
+	return metaSize(tx, walSizeKey)
+}
+
+// addWALSize adds to the total size of the log's entries.
+func addWALSize(tx *bolt.Tx, delta int64) error {
+	return addMetaSize(tx, walSizeKey, delta)
+}
+
+// metaSize returns a size recorded in the metadata bucket, which is zero if it has not been recorded.
+func metaSize(tx *bolt.Tx, key []byte) int64 {
+	v := tx.Bucket([]byte(BucketMeta)).Get(key)
+	if v == nil {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+// addMetaSize adds to a size recorded in the metadata bucket.
+func addMetaSize(tx *bolt.Tx, key []byte, delta int64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(metaSize(tx, key)+delta))
+
+	return tx.Bucket([]byte(BucketMeta)).Put(key, v)
+}
+
+// AppendWAL records an entry in the write-ahead log.
+// If the entry has no sequence number, it is given the next sequence number of its origin, which is set on the entry.
+// Otherwise it is written at its sequence number, replacing any entry already there.
+func (db *Database) AppendWAL(entry *WALEntry) error {
+	return db.update(func(tx *txn) error {
+		bucket, err := tx.Bucket([]byte(BucketWAL)).CreateBucketIfNotExists([]byte(entry.Origin))
+		if err != nil {
+			return err
+		}
+
+		if entry.Sequence == 0 {
+			entry.Sequence, err = bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+		} else if entry.Sequence > bucket.Sequence() {
+			err = bucket.SetSequence(entry.Sequence)
+			if err != nil {
+				return err
+			}
+		}
+
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		k := walKey(entry.Sequence)
+		size := int64(len(k) + len(encoded))
+
+		if v := bucket.Get(k); v != nil {
+			size -= int64(len(k) + len(v))
+		}
+
+		err = bucket.Put(k, encoded)
+		if err != nil {
+			return err
+		}
+
+		return addWALSize(tx.Tx, size)
+	})
+}
+
+// WALSince calls fn with the entries of an origin that follow a sequence number, in sequence order, until fn returns an
+// error. Entries that have been removed by retention are skipped.
+func (db *Database) WALSince(origin string, after uint64, fn func(entry WALEntry) error) error {
+	return db.view(func(tx *txn) error {
+		bucket := tx.Bucket([]byte(BucketWAL)).Bucket([]byte(origin))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+
+		for k, v := c.Seek(walKey(after + 1)); k != nil; k, v = c.Next() {
+			entry, err := decodeWALEntry(origin, k, v)
+			if err != nil {
+				return err
+			}
+
+			err = fn(entry)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// WALPositions returns the most recent sequence number of every origin in the write-ahead log, by origin.
+// The position of an origin is kept after retention has removed its entries.
+func (db *Database) WALPositions() (map[string]uint64, error) {
+	positions := make(map[string]uint64)
+
+	err := db.view(func(tx *txn) error {
+		return tx.Bucket([]byte(BucketWAL)).ForEach(func(k, v []byte) error {
+			// Every key of the log bucket is the bucket of an origin.
+			positions[string(k)] = tx.Bucket([]byte(BucketWAL)).Bucket(k).Sequence()
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return positions, nil
+}
+
+// WALSize returns the total size of the entries of the write-ahead log in bytes.
+func (db *Database) WALSize() (int64, error) {
+	var size int64
+
+	err := db.view(func(tx *txn) error {
+		size = walSize(tx.Tx)
+
+		return nil
+	})
+
+	return size, err
+}
+
+// TrimWAL removes the entries of the write-ahead log that were written before a time, then the oldest entries until the
+// log is no larger than maxSize bytes. A maxSize of zero or less does not limit the size of the log.
+// Entries are removed from the start of each origin's sequence, so an entry is only removed once every earlier entry of
+// its origin has been.
+// Returns the number of entries removed.
+func (db *Database) TrimWAL(before Time, maxSize int64) (int, error) {
+	removed := 0
+
+	err := db.update(func(tx *txn) error {
+		wal := tx.Bucket([]byte(BucketWAL))
+
+		type head struct {
+			origin string
+			cursor *bolt.Cursor
+			key    []byte
+			entry  WALEntry
+		}
+
+		heads := make([]*head, 0)
+
+		// advance removes the first entry of an origin and moves on to the next one.
+		// Returns false once the origin has no entries left.
+		advance := func(h *head) (bool, error) {
+			v := h.cursor.Bucket().Get(h.key)
+
+			err := h.cursor.Delete()
+			if err != nil {
+				return false, err
+			}
+
+			err = addWALSize(tx.Tx, -int64(len(h.key)+len(v)))
+			if err != nil {
+				return false, err
+			}
+
+			removed++
+
+			// The cursor is moved back to the start, as a cursor is not positioned reliably after a deletion.
+			k, v := h.cursor.First()
+			if k == nil {
+				return false, nil
+			}
+
+			h.key = k
+			h.entry, err = decodeWALEntry(h.origin, k, v)
+
+			return err == nil, err
+		}
+
+		err := wal.ForEach(func(k, v []byte) error {
+			c := wal.Bucket(k).Cursor()
+
+			first, value := c.First()
+			if first == nil {
+				return nil
+			}
+
+			entry, err := decodeWALEntry(string(k), first, value)
+			if err != nil {
+				return err
+			}
+
+			heads = append(heads, &head{origin: string(k), cursor: c, key: first, entry: entry})
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		remaining := make([]*head, 0, len(heads))
+
+		for _, h := range heads {
+			left := true
+
+			for left && h.entry.Time < before {
+				left, err = advance(h)
+				if err != nil {
+					return err
+				}
+			}
+
+			if left {
+				remaining = append(remaining, h)
+			}
+		}
+
+		for maxSize > 0 && len(remaining) > 0 && walSize(tx.Tx) > maxSize {
+			oldest := 0
+			for i, h := range remaining {
+				if h.entry.Time < remaining[oldest].entry.Time {
+					oldest = i
+				}
+			}
+
+			left, err := advance(remaining[oldest])
+			if err != nil {
+				return err
+			}
+
+			if !left {
+				remaining = append(remaining[:oldest], remaining[oldest+1:]...)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
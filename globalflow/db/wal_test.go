@@ -0,0 +1,144 @@
+package db
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// walSequences returns the sequence numbers of the entries of an origin that follow a sequence number.
+func walSequences(t *testing.T, db *Database, origin string, after uint64) []uint64 {
+	sequences := make([]uint64, 0)
+
+	err := db.WALSince(origin, after, func(entry WALEntry) error {
+		sequences = append(sequences, entry.Sequence)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sequences
+}
+
+func TestDatabase_WAL(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		entry := &WALEntry{Origin: "a", Time: Time(100 + i), Command: []byte(`{"command":"set"}`)}
+
+		if err := db.AppendWAL(entry); err != nil {
+			t.Fatal(err)
+		}
+
+		if entry.Sequence != uint64(i+1) {
+			t.Fatalf("expected sequence %d, got %d", i+1, entry.Sequence)
+		}
+	}
+
+	// An entry written at its own sequence number moves the origin's position forward.
+	if err := db.AppendWAL(&WALEntry{Origin: "b", Sequence: 5, Time: 150, Command: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if sequences := walSequences(t, db, "a", 1); !reflect.DeepEqual(sequences, []uint64{2, 3}) {
+		t.Errorf("expected [2 3], got %v", sequences)
+	}
+
+	if sequences := walSequences(t, db, "c", 0); len(sequences) != 0 {
+		t.Errorf("expected no entries for an unknown origin, got %v", sequences)
+	}
+
+	positions, err := db.WALPositions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(positions, map[string]uint64{"a": 3, "b": 5}) {
+		t.Errorf("unexpected positions %v", positions)
+	}
+
+	err = db.WALSince("a", 2, func(entry WALEntry) error {
+		if entry.Origin != "a" || entry.Time != 102 || string(entry.Command) != `{"command":"set"}` {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDatabase_TrimWAL(t *testing.T) {
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	entries := []*WALEntry{
+		{Origin: "a", Time: 100},
+		{Origin: "b", Time: 200},
+		{Origin: "a", Time: 300},
+		{Origin: "b", Time: 400},
+		{Origin: "a", Time: 500},
+	}
+
+	for _, entry := range entries {
+		entry.Command = []byte(`{}`)
+
+		if err := db.AppendWAL(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := db.TrimWAL(150, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 1 {
+		t.Errorf("expected 1 entry removed by age, got %d", removed)
+	}
+
+	size, err := db.WALSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keeping half of the remaining entries removes the oldest across origins.
+	removed, err = db.TrimWAL(0, size/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 2 {
+		t.Errorf("expected 2 entries removed by size, got %d", removed)
+	}
+
+	if sequences := walSequences(t, db, "a", 0); !reflect.DeepEqual(sequences, []uint64{3}) {
+		t.Errorf("expected [3] for origin a, got %v", sequences)
+	}
+
+	if sequences := walSequences(t, db, "b", 0); !reflect.DeepEqual(sequences, []uint64{2}) {
+		t.Errorf("expected [2] for origin b, got %v", sequences)
+	}
+
+	// Positions survive the removal of the entries.
+	positions, err := db.WALPositions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(positions, map[string]uint64{"a": 3, "b": 2}) {
+		t.Errorf("unexpected positions %v", positions)
+	}
+}
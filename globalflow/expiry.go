@@ -0,0 +1,82 @@
+package globalflow
+
+import (
+	"github.com/sirupsen/logrus"
+	"globalflow/globalflow/db"
+	"time"
+)
+
+// SweepExpiredKeys runs until shutdown, periodically deleting keys whose deadline has passed.
+// Every node applies the same replicated deadlines, so expired keys are reclaimed locally without replication.
+func (server *Server) SweepExpiredKeys() {
+	interval := server.container.Configuration.ExpirySweepInterval
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.shutdownCh:
+			return
+
+		case <-ticker.C:
+			reclaimed := server.sweepExpiredKeys(interval / 4)
+
+			if reclaimed > 0 {
+				logrus.WithField("keys", reclaimed).Debug("Reclaimed expired keys")
+			}
+		}
+	}
+}
+
+// sweepExpiredKeys deletes expired keys from every logical database in batches, until there are none left or the
+// budget is spent. Each sweep starts from the database after the one the previous sweep spent its budget on, so that a
+// database with many expired keys does not keep the others from being swept.
+// Returns the number of keys reclaimed.
+func (server *Server) sweepExpiredKeys(budget time.Duration) int {
+	batchSize := server.container.Configuration.ExpirySweepBatchSize
+	start := time.Now()
+	reclaimed := 0
+
+	for i := 0; i < db.Databases; i++ {
+		index := (server.sweepIndex + i) % db.Databases
+
+		database, err := server.db.Select(index)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to select database")
+
+			return reclaimed
+		}
+
+		for {
+			deleted, err := database.DeleteExpired(server.now(), batchSize)
+			if err != nil {
+				logrus.WithError(err).Warn("failed to delete expired keys")
+
+				return reclaimed
+			}
+
+			reclaimed += len(deleted)
+			server.deliver(server.tracking.Invalidate(nil, deleted...))
+			server.expiredKeys.Add(uint64(len(deleted)))
+
+			// A partial batch means there is nothing left to reclaim yet.
+			if len(deleted) < batchSize {
+				break
+			}
+
+			if time.Since(start) > budget {
+				server.sweepIndex = (index + 1) % db.Databases
+
+				return reclaimed
+			}
+		}
+	}
+
+	return reclaimed
+}
+
+// ExpiredKeys returns the total number of expired keys reclaimed since the server started.
+func (server *Server) ExpiredKeys() uint64 {
+	return server.expiredKeys.Load()
+}
@@ -0,0 +1,49 @@
+package globalflow
+
+import (
+	"globalflow/globalflow/db"
+	"testing"
+)
+
+func TestServer_SweepExpiredKeysRoundRobin(t *testing.T) {
+	server := newTestServer(t, "a")
+	server.container.Configuration.ExpirySweepBatchSize = 1
+
+	first, err := server.db.Select(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := server.db.Select(db.Databases - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if _, err := first.Set(key, db.Version{}, "value", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := last.Set("f", db.Version{}, "value", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every sweep spends its budget on a single batch, and database 0 always has more expired keys.
+	for i := 0; i < 2; i++ {
+		server.sweepExpiredKeys(0)
+	}
+
+	remaining, err := last.DeleteExpired(server.now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(remaining) != 0 {
+		t.Errorf("expected the expired key of the last database to be reclaimed, got %v", remaining)
+	}
+
+	if server.ExpiredKeys() != 2 {
+		t.Errorf("expected 2 keys to be reclaimed, got %d", server.ExpiredKeys())
+	}
+}
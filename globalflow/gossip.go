@@ -10,6 +10,12 @@ type GossipMetadata struct {
 	Region   string `json:"region"`
 	Zone     string `json:"zone"`
 	Hostname string `json:"hostname"`
+
+	// Loading is true while the node is bootstrapping its data from a peer.
+	Loading bool `json:"loading,omitempty"`
+
+	// Horizon is the wall clock time, in Unix milliseconds, before which the node has received every write.
+	Horizon int64 `json:"horizon,omitempty"`
 }
 
 // StartGossip starts the gossip server
@@ -22,6 +28,13 @@ func (server *Server) StartGossip() error {
 		return err
 	}
 
+	// A bootstrapping node joins the cluster already marked as loading, so that it is never part of the rings before
+	// it has caught up.
+	err = g.SetLoading(server.loading.Load())
+	if err != nil {
+		return err
+	}
+
 	err = g.Start()
 	if err != nil {
 		return err
@@ -50,6 +63,16 @@ func (server *Server) StreamMessages() {
 			case CommandMessage:
 				server.handleCommand(&v)
 
+			case PublishMessage:
+				server.handlePublish(&v)
+
+			case ResendMessage:
+				// Resending can wait on the network, which must not hold up the messages that follow.
+				go server.handleResend(&v)
+
+			case SyncMessage:
+				go server.handleSync(&v)
+
 			default:
 				logrus.Warnf("Unknown message type: %T", v)
 			}
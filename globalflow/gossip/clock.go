@@ -0,0 +1,89 @@
+package gossip
+
+import (
+	"encoding/binary"
+	"github.com/hashicorp/memberlist"
+	"sync"
+	"time"
+)
+
+// clockSmoothing is the weight of a new reading in a peer's offset estimate, which smooths out the jitter of the round
+// trip times that readings are corrected with.
+const clockSmoothing = 0.25
+
+// ClockOffset is the estimated offset of a peer's wall clock from the local node's.
+type ClockOffset struct {
+	// Offset is how far the peer's clock is ahead of the local clock. It is negative if the peer's clock is behind.
+	Offset time.Duration
+
+	// RTT is the round trip time of the probe that the most recent reading was received with.
+	RTT time.Duration
+
+	// Updated is when the most recent reading was received.
+	Updated time.Time
+}
+
+// ClockDelegate exchanges wall clock readings in the acks of the probes that memberlist sends to check that nodes are
+// alive. A node replies to a probe with its wall clock time, which the prober compares with its own clock at the middle
+// of the round trip to estimate the offset of the peer's clock.
+type ClockDelegate struct {
+	offsets map[string]ClockOffset
+
+	// now returns the wall clock time.
+	now func() time.Time
+
+	mu sync.Mutex
+}
+
+// NewClockDelegate creates a new clock delegate.
+func NewClockDelegate() *ClockDelegate {
+	return &ClockDelegate{
+		offsets: make(map[string]ClockOffset),
+		now:     time.Now,
+	}
+}
+
+// AckPayload returns the local wall clock time, in Unix microseconds, to be sent in the ack of a probe.
+func (d *ClockDelegate) AckPayload() []byte {
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(d.now().UnixMicro()))
+
+	return payload
+}
+
+// NotifyPingComplete updates the offset of a peer with the wall clock time it sent in the ack of a probe.
+func (d *ClockDelegate) NotifyPingComplete(other *memberlist.Node, rtt time.Duration, payload []byte) {
+	if len(payload) != 8 {
+		return
+	}
+
+	now := d.now()
+
+	// The peer read its clock at about the middle of the round trip.
+	remote := time.UnixMicro(int64(binary.BigEndian.Uint64(payload)))
+	offset := remote.Sub(now.Add(-rtt / 2))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if previous, ok := d.offsets[other.Name]; ok {
+		offset = previous.Offset + time.Duration(clockSmoothing*float64(offset-previous.Offset))
+	}
+
+	d.offsets[other.Name] = ClockOffset{Offset: offset, RTT: rtt, Updated: now}
+}
+
+// Offsets returns the estimated clock offset of every peer that has acked a probe.
+func (d *ClockDelegate) Offsets() map[string]ClockOffset {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	offsets := make(map[string]ClockOffset, len(d.offsets))
+	for name, offset := range d.offsets {
+		offsets[name] = offset
+	}
+
+	return offsets
+}
+
+var _ memberlist.PingDelegate = &ClockDelegate{}
@@ -0,0 +1,43 @@
+package gossip
+
+import (
+	"github.com/hashicorp/memberlist"
+	"testing"
+	"time"
+)
+
+func TestClockDelegate_Offsets(t *testing.T) {
+	local, remote := NewClockDelegate(), NewClockDelegate()
+	node := &memberlist.Node{Name: "b"}
+
+	// The peer's clock is 2s ahead, and reads it 50ms into a 100ms round trip.
+	remote.now = func() time.Time { return time.UnixMilli(12050) }
+	local.now = func() time.Time { return time.UnixMilli(10100) }
+
+	local.NotifyPingComplete(node, 100*time.Millisecond, remote.AckPayload())
+
+	offset := local.Offsets()["b"]
+	if offset.Offset != 2*time.Second {
+		t.Errorf("expected an offset of 2s, got %s", offset.Offset)
+	}
+
+	if offset.RTT != 100*time.Millisecond || !offset.Updated.Equal(time.UnixMilli(10100)) {
+		t.Errorf("unexpected reading %+v", offset)
+	}
+
+	// Later readings are smoothed into the estimate.
+	local.NotifyPingComplete(node, 100*time.Millisecond, remote.AckPayload())
+	remote.now = func() time.Time { return time.UnixMilli(14050) }
+	local.NotifyPingComplete(node, 100*time.Millisecond, remote.AckPayload())
+
+	if offset := local.Offsets()["b"].Offset; offset != 2500*time.Millisecond {
+		t.Errorf("expected an offset of 2.5s, got %s", offset)
+	}
+
+	// Acks without a reading are ignored.
+	local.NotifyPingComplete(&memberlist.Node{Name: "c"}, time.Millisecond, nil)
+
+	if _, ok := local.Offsets()["c"]; ok {
+		t.Error("expected no offset for a peer without a reading")
+	}
+}
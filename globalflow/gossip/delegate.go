@@ -4,15 +4,22 @@ import (
 	"encoding/json"
 	"github.com/hashicorp/memberlist"
 	"github.com/sirupsen/logrus"
+	"sync"
 )
 
 type Delegate struct {
 	Metadata    GossipMetadata
 	MessageChan chan []byte
+
+	// mutex must be held when accessing Metadata once gossip has started.
+	mutex sync.Mutex
 }
 
 func (d *Delegate) NodeMeta(limit int) []byte {
+	d.mutex.Lock()
 	encoded, err := json.Marshal(d.Metadata)
+	d.mutex.Unlock()
+
 	if err != nil {
 		panic(err)
 	}
@@ -24,6 +31,22 @@ func (d *Delegate) NodeMeta(limit int) []byte {
 	return encoded
 }
 
+// SetLoading sets whether the node is advertised as bootstrapping its data.
+func (d *Delegate) SetLoading(loading bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.Metadata.Loading = loading
+}
+
+// SetHorizon sets the time before which the node is advertised to have received every write.
+func (d *Delegate) SetHorizon(horizon int64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.Metadata.Horizon = horizon
+}
+
 func (d *Delegate) NotifyMsg(bytes []byte) {
 	logrus.Debug("Received message")
 
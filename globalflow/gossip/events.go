@@ -9,9 +9,20 @@ import (
 type EventDelegate struct {
 	Members map[string]*memberlist.Node
 
+	// AliveCh receives the name of a node when it joins or is updated, so that messages held for it can be delivered.
+	AliveCh chan string
+
 	mu sync.Mutex
 }
 
+// notifyAlive sends the name of a node to AliveCh, unless it is full.
+func (e *EventDelegate) notifyAlive(node *memberlist.Node) {
+	select {
+	case e.AliveCh <- node.Name:
+	default:
+	}
+}
+
 func (e *EventDelegate) NotifyJoin(node *memberlist.Node) {
 	logrus.WithField("node", node.Name).Debug("Node joined")
 
@@ -19,6 +30,8 @@ func (e *EventDelegate) NotifyJoin(node *memberlist.Node) {
 	defer e.mu.Unlock()
 
 	e.Members[node.Name] = node
+
+	e.notifyAlive(node)
 }
 
 func (e *EventDelegate) NotifyLeave(node *memberlist.Node) {
@@ -37,12 +50,15 @@ func (e *EventDelegate) NotifyUpdate(node *memberlist.Node) {
 	defer e.mu.Unlock()
 
 	e.Members[node.Name] = node
+
+	e.notifyAlive(node)
 }
 
 // NewEventDelegate creates a new event delegate.
 func NewEventDelegate() *EventDelegate {
 	return &EventDelegate{
 		Members: make(map[string]*memberlist.Node),
+		AliveCh: make(chan string, 128),
 	}
 }
 
@@ -7,20 +7,36 @@ import (
 	"time"
 )
 
+// metadataUpdateTimeout is how long to wait for an update of the node's metadata to be broadcast.
+const metadataUpdateTimeout = 10 * time.Second
+
 // Gossip is a gossip protocol.
 type Gossip struct {
 	configuration *config.Configuration
 	m             *memberlist.Memberlist
 	events        *EventDelegate
 	messageCh     chan []byte
+	delegate      *Delegate
+	clocks        *ClockDelegate
 }
 
 // NewGossip creates a new gossip protocol.
 func NewGossip(cfg *config.Configuration) (*Gossip, error) {
+	messageCh := make(chan []byte, 128)
+
 	return &Gossip{
 		configuration: cfg,
 		events:        NewEventDelegate(),
-		messageCh:     make(chan []byte, 128),
+		messageCh:     messageCh,
+		delegate: &Delegate{
+			Metadata: GossipMetadata{
+				Region:   cfg.NodeRegion,
+				Zone:     cfg.NodeZone,
+				Hostname: cfg.NodeHostname,
+			},
+			MessageChan: messageCh,
+		},
+		clocks: NewClockDelegate(),
 	}, nil
 }
 
@@ -35,15 +51,14 @@ func (g *Gossip) Start() error {
 	//cfg.AdvertiseAddr = g.configuration.NodeAddress
 	cfg.AdvertisePort = g.configuration.NodePort
 	cfg.LogOutput = &LogrusLogger{}
-	cfg.Delegate = &Delegate{
-		Metadata: GossipMetadata{
-			Region:   g.configuration.NodeRegion,
-			Zone:     g.configuration.NodeZone,
-			Hostname: g.configuration.NodeHostname,
-		},
-		MessageChan: g.messageCh,
-	}
+	cfg.Delegate = g.delegate
 	cfg.Events = g.events
+	cfg.Ping = g.clocks
+
+	// With a gossip key, every message between nodes over gossip is encrypted and authenticated.
+	if len(g.configuration.GossipKey) > 0 {
+		cfg.SecretKey = g.configuration.GossipKey
+	}
 
 	m, err := memberlist.Create(cfg)
 	if err != nil {
@@ -80,6 +95,29 @@ func (g *Gossip) Close() (err error) {
 	return
 }
 
+// SetLoading sets whether the node is advertised to the cluster as bootstrapping its data.
+// Before the gossip protocol starts, it sets the metadata that the node joins with.
+func (g *Gossip) SetLoading(loading bool) error {
+	g.delegate.SetLoading(loading)
+
+	if g.m == nil {
+		return nil
+	}
+
+	return g.m.UpdateNode(metadataUpdateTimeout)
+}
+
+// SetHorizon sets the time, in Unix milliseconds, before which the node is advertised to have received every write.
+func (g *Gossip) SetHorizon(horizon int64) error {
+	g.delegate.SetHorizon(horizon)
+
+	if g.m == nil {
+		return nil
+	}
+
+	return g.m.UpdateNode(metadataUpdateTimeout)
+}
+
 // Members returns the members in the cluster. This can include the local node, and suspect nodes.
 func (g *Gossip) Members() []*memberlist.Node {
 	return g.m.Members()
@@ -90,11 +128,21 @@ func (g *Gossip) MessageCh() chan []byte {
 	return g.messageCh
 }
 
+// AliveCh returns a channel that receives the name of a node when it joins the cluster or its state is updated.
+func (g *Gossip) AliveCh() chan string {
+	return g.events.AliveCh
+}
+
+// ClockOffsets returns the estimated offset of the wall clock of every peer that has been probed from the local clock.
+func (g *Gossip) ClockOffsets() map[string]ClockOffset {
+	return g.clocks.Offsets()
+}
+
 // SendReliable reliably sends a message to a node.
 func (g *Gossip) SendReliable(to *memberlist.Node, msg []byte) (err error) {
 	// Retry sending the message 3 times.
 	for i := 0; i < 3; i++ {
-		err := g.m.SendReliable(to, msg)
+		err = g.m.SendReliable(to, msg)
 		if err == nil {
 			return nil
 		}
@@ -5,4 +5,12 @@ type GossipMetadata struct {
 	Region   string `json:"region"`
 	Zone     string `json:"zone"`
 	Hostname string `json:"hostname"`
+
+	// Loading is true while the node is bootstrapping its data from a peer, during which other nodes leave it out of
+	// their rings.
+	Loading bool `json:"loading,omitempty"`
+
+	// Horizon is the wall clock time, in Unix milliseconds, before which the node has received every write, which
+	// tombstones are only purged before.
+	Horizon int64 `json:"horizon,omitempty"`
 }
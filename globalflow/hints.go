@@ -0,0 +1,184 @@
+package globalflow
+
+import (
+	"github.com/sirupsen/logrus"
+	"globalflow/globalflow/db"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// When a command cannot be sent to the next node in a ring, it is stored as a hint for that node, so that it is handed
+// off once the node can be reached again rather than only recovered by anti-entropy. Hints are replayed in the order
+// they were stored when gossip reports the node alive, and retried periodically while any are left. Hints older than
+// the hint retention are removed, and no more are stored once they reach their maximum size.
+
+// hintReplayInterval is how often the hints of reachable nodes are retried and expired hints are removed.
+const hintReplayInterval = 10 * time.Second
+
+// hintReplayBatch is the most hints read from the database at once while replaying them.
+const hintReplayBatch = 100
+
+// hints contains the nodes whose hints are being replayed and the statistics of hinted handoff.
+type hints struct {
+	// mutex must be held when accessing replaying.
+	mutex sync.Mutex
+
+	// replaying contains the IDs of the nodes whose hints are being replayed.
+	replaying map[string]bool
+
+	stored   atomic.Uint64
+	replayed atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+func newHints() *hints {
+	return &hints{
+		replaying: make(map[string]bool),
+	}
+}
+
+// hint stores an encoded message for a node that it could not be sent to.
+func (server *Server) hint(node *Node, encoded []byte) {
+	hint := &db.Hint{
+		Node:    node.NodeID(),
+		Time:    db.Time(time.Now().UnixMilli()),
+		Message: encoded,
+	}
+
+	err := server.db.AddHint(hint, server.container.Configuration.HintMaxSize)
+	if err == db.ErrHintsFull {
+		server.hints.dropped.Add(1)
+		logrus.WithField("node", node.NodeID()).Warn("hints are full, dropping message")
+
+		return
+	}
+
+	if err != nil {
+		server.hints.dropped.Add(1)
+		logrus.WithError(err).WithField("node", node.NodeID()).Warn("failed to store hint")
+
+		return
+	}
+
+	server.hints.stored.Add(1)
+}
+
+// ReplayHints runs until shutdown, replaying the hints of nodes as gossip reports them alive, and periodically
+// retrying the hints of every reachable node and removing expired hints.
+func (server *Server) ReplayHints() {
+	ticker := time.NewTicker(hintReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.shutdownCh:
+			return
+
+		case nodeID := <-server.gossip.AliveCh():
+			go server.replayHints(nodeID)
+
+		case <-ticker.C:
+			server.trimHints(time.Now())
+
+			nodes, err := server.db.HintNodes()
+			if err != nil {
+				logrus.WithError(err).Warn("failed to read hints")
+				continue
+			}
+
+			for _, nodeID := range nodes {
+				go server.replayHints(nodeID)
+			}
+		}
+	}
+}
+
+// replayHints sends the hints of a node to it in the order they were stored, removing each batch once it has been
+// delivered. It stops at the first message that cannot be sent, leaving the rest for a later attempt.
+func (server *Server) replayHints(nodeID string) {
+	server.hints.mutex.Lock()
+	if server.hints.replaying[nodeID] {
+		server.hints.mutex.Unlock()
+		return
+	}
+	server.hints.replaying[nodeID] = true
+	server.hints.mutex.Unlock()
+
+	defer func() {
+		server.hints.mutex.Lock()
+		delete(server.hints.replaying, nodeID)
+		server.hints.mutex.Unlock()
+	}()
+
+	for {
+		// The node is looked up again for each batch, in case it has failed or is bootstrapping its data.
+		node := server.Peer(nodeID)
+		if node == nil {
+			return
+		}
+
+		hints, err := server.db.Hints(nodeID, hintReplayBatch)
+		if err != nil {
+			logrus.WithError(err).WithField("node", nodeID).Warn("failed to read hints")
+			return
+		}
+
+		if len(hints) == 0 {
+			return
+		}
+
+		delivered := uint64(0)
+
+		for _, hint := range hints {
+			err = server.send(node, hint.Message)
+			if err != nil {
+				break
+			}
+
+			delivered = hint.Sequence
+			server.hints.replayed.Add(1)
+		}
+
+		if delivered > 0 {
+			if err := server.db.DeleteHints(nodeID, delivered); err != nil {
+				logrus.WithError(err).WithField("node", nodeID).Warn("failed to remove delivered hints")
+				return
+			}
+		}
+
+		if err != nil {
+			logrus.WithError(err).WithField("node", nodeID).Debug("failed to replay hints")
+			return
+		}
+
+		logrus.WithField("node", nodeID).WithField("hints", len(hints)).Debug("Replayed hints")
+	}
+}
+
+// trimHints removes the hints that are older than the hint retention.
+func (server *Server) trimHints(now time.Time) {
+	retention := server.container.Configuration.HintRetention
+	if retention <= 0 {
+		return
+	}
+
+	removed, err := server.db.TrimHints(db.Time(now.Add(-retention).UnixMilli()))
+	if err != nil {
+		logrus.WithError(err).Warn("failed to remove expired hints")
+		return
+	}
+
+	if removed > 0 {
+		server.hints.dropped.Add(uint64(removed))
+		logrus.WithField("hints", removed).Info("Removed expired hints")
+	}
+}
+
+// HintStats returns the number of messages that the node has stored as hints, replayed to their nodes, and dropped
+// because the hints were full or had expired.
+func (server *Server) HintStats() (stored uint64, replayed uint64, dropped uint64) {
+	h := server.hints
+
+	return h.stored.Load(), h.replayed.Load(), h.dropped.Load()
+}
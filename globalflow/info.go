@@ -0,0 +1,351 @@
+package globalflow
+
+import (
+	"fmt"
+	"github.com/hashicorp/memberlist"
+	"globalflow/globalflow/db"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// infoSections are the sections of INFO, in the order they are written.
+var infoSections = []string{"server", "clients", "stats", "persistence", "replication", "keyspace"}
+
+// parseInfoSections returns the sections requested by the arguments of INFO.
+// Without arguments, or with "default", "all" or "everything", every section is returned. Unknown sections are ignored.
+func parseInfoSections(args []string) map[string]bool {
+	sections := make(map[string]bool)
+
+	if len(args) == 0 {
+		args = []string{"all"}
+	}
+
+	for _, arg := range args {
+		switch section := strings.ToLower(arg); section {
+		case "default", "all", "everything":
+			for _, s := range infoSections {
+				sections[s] = true
+			}
+
+		default:
+			sections[section] = true
+		}
+	}
+
+	return sections
+}
+
+// peerReplication contains the replication state of a peer, as observed from the commands it originated.
+type peerReplication struct {
+	// Time is the hybrid logical clock time of the most recent command received from the peer.
+	Time Time
+
+	// Lag is the delay between the peer creating its most recent command and this node receiving it.
+	Lag time.Duration
+
+	// Received is when the most recent command from the peer was received.
+	Received time.Time
+}
+
+// replicationStats contains the replication state of every peer that this node has received commands from.
+type replicationStats struct {
+	mutex sync.Mutex
+
+	// peers is a map of node ID to replication state.
+	peers map[string]peerReplication
+}
+
+func newReplicationStats() *replicationStats {
+	return &replicationStats{
+		peers: make(map[string]peerReplication),
+	}
+}
+
+// Received records that a command originated by a peer was received.
+// Commands received out of order do not replace the state of a more recent command.
+func (r *replicationStats) Received(cmd *CommandMessage, now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	peer, ok := r.peers[cmd.Originator]
+	if ok && cmd.Time <= peer.Time {
+		return
+	}
+
+	peer = peerReplication{
+		Time:     cmd.Time,
+		Received: now,
+	}
+
+	// The lag is only known if the command carries the time it was sent, and is measured with the wall clocks of
+	// both nodes, so it is only as accurate as their synchronization.
+	if cmd.SentAt != 0 {
+		peer.Lag = now.Sub(time.UnixMilli(cmd.SentAt))
+		if peer.Lag < 0 {
+			peer.Lag = 0
+		}
+	}
+
+	r.peers[cmd.Originator] = peer
+}
+
+// Peer returns the replication state of a peer, and false if no command has been received from it.
+func (r *replicationStats) Peer(nodeID string) (peerReplication, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	peer, ok := r.peers[nodeID]
+
+	return peer, ok
+}
+
+// info returns the requested sections of INFO.
+func (server *Server) info(sections map[string]bool) (string, error) {
+	writers := map[string]func(b *strings.Builder) error{
+		"server":      server.infoServer,
+		"clients":     server.infoClients,
+		"stats":       server.infoStats,
+		"persistence": server.infoPersistence,
+		"replication": server.infoReplication,
+		"keyspace":    server.infoKeyspace,
+	}
+
+	b := &strings.Builder{}
+
+	for _, section := range infoSections {
+		if !sections[section] {
+			continue
+		}
+
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+
+		b.WriteString("# " + strings.ToUpper(section[:1]) + section[1:] + "\r\n")
+
+		err := writers[section](b)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return b.String(), nil
+}
+
+// writeInfoField writes a field of an INFO section.
+func writeInfoField(b *strings.Builder, name string, value interface{}) {
+	fmt.Fprintf(b, "%s:%v\r\n", name, value)
+}
+
+func (server *Server) infoServer(b *strings.Builder) error {
+	configuration := server.container.Configuration
+	uptime := time.Since(server.startedAt)
+
+	writeInfoField(b, "redis_version", redisVersion)
+	writeInfoField(b, "redis_mode", "standalone")
+	writeInfoField(b, "process_id", os.Getpid())
+	writeInfoField(b, "tcp_port", configuration.RedisPort)
+	writeInfoField(b, "uptime_in_seconds", int64(uptime.Seconds()))
+	writeInfoField(b, "uptime_in_days", int64(uptime.Hours()/24))
+	writeInfoField(b, "node_id", configuration.NodeID)
+	writeInfoField(b, "node_region", configuration.NodeRegion)
+	writeInfoField(b, "node_zone", configuration.NodeZone)
+	writeInfoField(b, "hlc_clock", server.clock.Current())
+
+	return nil
+}
+
+func (server *Server) infoClients(b *strings.Builder) error {
+	writeInfoField(b, "connected_clients", server.connectedClients.Load())
+
+	return nil
+}
+
+func (server *Server) infoStats(b *strings.Builder) error {
+	writeInfoField(b, "total_connections_received", server.totalConnections.Load())
+	writeInfoField(b, "total_commands_processed", server.commandsProcessed.Load())
+	writeInfoField(b, "expired_keys", server.ExpiredKeys())
+	writeInfoField(b, "tombstones_purged", server.tombstonesPurged.Load())
+
+	rounds, sent, received, repaired := server.AntiEntropyStats()
+	writeInfoField(b, "anti_entropy_rounds", rounds)
+	writeInfoField(b, "anti_entropy_bytes_sent", sent)
+	writeInfoField(b, "anti_entropy_bytes_received", received)
+	writeInfoField(b, "anti_entropy_keys_repaired", repaired)
+
+	stored, replayed, dropped := server.HintStats()
+	writeInfoField(b, "hints_stored", stored)
+	writeInfoField(b, "hints_replayed", replayed)
+	writeInfoField(b, "hints_dropped", dropped)
+
+	return nil
+}
+
+func (server *Server) infoPersistence(b *strings.Builder) error {
+	size, err := server.db.FileSize()
+	if err != nil {
+		return err
+	}
+
+	walSize, err := server.db.WALSize()
+	if err != nil {
+		return err
+	}
+
+	hintsSize, err := server.db.HintsSize()
+	if err != nil {
+		return err
+	}
+
+	loading := 0
+	if server.loading.Load() {
+		loading = 1
+	}
+
+	writeInfoField(b, "loading", loading)
+	writeInfoField(b, "bolt_db_size", size)
+	writeInfoField(b, "wal_size", walSize)
+	writeInfoField(b, "hints_size", hintsSize)
+
+	return nil
+}
+
+// infoReplication writes the replication section. Every node accepts writes, so each one is a master without
+// replicas; the section instead describes the node's successors in the ring and the peers it replicates with.
+func (server *Server) infoReplication(b *strings.Builder) error {
+	configuration := server.container.Configuration
+	nodes := server.Nodes()
+
+	regions := map[string]bool{configuration.NodeRegion: true}
+	zones := map[string]bool{configuration.NodeRegion + "/" + configuration.NodeZone: true}
+
+	peers := make([]*Node, 0, len(nodes))
+
+	for _, node := range nodes {
+		if node.NodeID() == configuration.NodeID {
+			continue
+		}
+
+		peers = append(peers, node)
+
+		metadata := node.Metadata()
+		if metadata == nil || node.node.State != memberlist.StateAlive {
+			continue
+		}
+
+		regions[metadata.Region] = true
+		zones[metadata.Region+"/"+metadata.Zone] = true
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].NodeID() < peers[j].NodeID()
+	})
+
+	writeInfoField(b, "role", "master")
+	writeInfoField(b, "connected_slaves", 0)
+	writeInfoField(b, "next_local_node", nodeName(server.NextLocalNode()))
+	writeInfoField(b, "next_remote_node", nodeName(server.NextRemoteNode()))
+	writeInfoField(b, "regions", len(regions))
+	writeInfoField(b, "zones", len(zones))
+	writeInfoField(b, "peers", len(peers))
+
+	now := time.Now()
+
+	// Gaps are only counted once they have been outstanding for the threshold, as commands are often briefly reordered.
+	writeInfoField(b, "replication_gaps", len(server.sequences.Outstanding(now, configuration.ReplicationGapThreshold)))
+
+	fenced := 0
+	if server.clocks.fenced.Load() {
+		fenced = 1
+	}
+
+	writeInfoField(b, "clock_skew_ms", time.Duration(server.clocks.skew.Load()).Milliseconds())
+	writeInfoField(b, "clock_fenced", fenced)
+
+	offsets := server.clockOffsets(now)
+
+	for i, node := range peers {
+		region, zone := "", ""
+		if metadata := node.Metadata(); metadata != nil {
+			region, zone = metadata.Region, metadata.Zone
+		}
+
+		// The lag and the time since the last command are -1 until a command has been received from the peer.
+		clock, lag, last := Time(0), int64(-1), int64(-1)
+		if peer, ok := server.replication.Peer(node.NodeID()); ok {
+			clock, lag, last = peer.Time, peer.Lag.Milliseconds(), int64(now.Sub(peer.Received).Seconds())
+		}
+
+		sequence, missing := server.sequences.Position(node.NodeID())
+
+		line := fmt.Sprintf(
+			"name=%s,addr=%s,region=%s,zone=%s,state=%s,clock=%d,lag_ms=%d,last_received_seconds_ago=%d,sequence=%d,missing=%d",
+			node.NodeID(), node.Address(), region, zone, nodeState(node.node.State), clock, lag, last, sequence, missing,
+		)
+
+		// The clock offset is only known once the peer has been probed recently.
+		if offset, ok := offsets[node.NodeID()]; ok {
+			line += fmt.Sprintf(",clock_offset_ms=%d", offset.Milliseconds())
+		}
+
+		writeInfoField(b, fmt.Sprintf("peer%d", i), line)
+	}
+
+	return nil
+}
+
+func (server *Server) infoKeyspace(b *strings.Builder) error {
+	now := server.now()
+
+	for index := 0; index < db.Databases; index++ {
+		database, err := server.db.Select(index)
+		if err != nil {
+			return err
+		}
+
+		info, err := database.Info(now)
+		if err != nil {
+			return err
+		}
+
+		// Like Redis, empty databases are left out.
+		if info.Keys == 0 {
+			continue
+		}
+
+		writeInfoField(b, fmt.Sprintf("db%d", index), fmt.Sprintf("keys=%d,expires=%d,avg_ttl=0", info.Keys, info.Expires))
+	}
+
+	return nil
+}
+
+// nodeName returns the ID of a node, or an empty string if there is no node.
+func nodeName(node *Node) string {
+	if node == nil {
+		return ""
+	}
+
+	return node.NodeID()
+}
+
+// nodeState returns the name of a memberlist state.
+func nodeState(state memberlist.NodeStateType) string {
+	switch state {
+	case memberlist.StateAlive:
+		return "alive"
+
+	case memberlist.StateSuspect:
+		return "suspect"
+
+	case memberlist.StateDead:
+		return "dead"
+
+	case memberlist.StateLeft:
+		return "left"
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,85 @@
+package globalflow
+
+import (
+	"globalflow/globalflow/db"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInfoSections(t *testing.T) {
+	all := make(map[string]bool)
+	for _, section := range infoSections {
+		all[section] = true
+	}
+
+	if sections := parseInfoSections(nil); !reflect.DeepEqual(sections, all) {
+		t.Errorf("expected every section, got %v", sections)
+	}
+
+	if sections := parseInfoSections([]string{"Keyspace", "replication"}); !reflect.DeepEqual(sections, map[string]bool{"keyspace": true, "replication": true}) {
+		t.Errorf("unexpected sections %v", sections)
+	}
+}
+
+func TestReplicationStats_Received(t *testing.T) {
+	r := newReplicationStats()
+	now := time.UnixMilli(10000)
+
+	r.Received(&CommandMessage{Time: 5, Originator: "b", SentAt: 9800}, now)
+
+	// A command that was overtaken by a more recent one does not change the state.
+	r.Received(&CommandMessage{Time: 4, Originator: "b", SentAt: 9000}, now.Add(time.Second))
+
+	peer, ok := r.Peer("b")
+	if !ok {
+		t.Fatal("expected peer b")
+	}
+
+	if peer.Time != 5 || peer.Lag != 200*time.Millisecond || !peer.Received.Equal(now) {
+		t.Errorf("unexpected state %+v", peer)
+	}
+
+	if _, ok := r.Peer("c"); ok {
+		t.Error("expected no state for peer c")
+	}
+}
+
+func TestServer_InfoKeyspace(t *testing.T) {
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer database.Close()
+
+	selected, err := database.Select(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := selected.Set("a", db.Version{}, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := selected.Set("b", db.Version{}, "value", db.Time(time.Now().Add(time.Hour).UnixMilli())); err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{db: database, clock: NewClock(0)}
+
+	info, err := server.info(map[string]bool{"keyspace": true, "persistence": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(info, "# Persistence\r\nloading:0\r\nbolt_db_size:") {
+		t.Errorf("unexpected persistence section %q", info)
+	}
+
+	if !strings.HasSuffix(info, "\r\n\r\n# Keyspace\r\ndb3:keys=2,expires=1,avg_ttl=0\r\n") {
+		t.Errorf("unexpected keyspace section %q", info)
+	}
+}
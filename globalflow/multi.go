@@ -0,0 +1,275 @@
+package globalflow
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/redcon"
+	"globalflow/globalflow/db"
+	"sync"
+)
+
+// Transactions queue commands between MULTI and EXEC, then run them in a single database transaction.
+// The writes of the transaction are broadcast together as one "exec" command, which other nodes also apply in a single
+// transaction, so no reader in any region sees some of a transaction's writes without the others.
+
+// watchedKey identifies a key in a logical database.
+type watchedKey struct {
+	db  int
+	key string
+}
+
+// watches tracks the keys that clients have watched, and which clients have seen a watched key modified.
+type watches struct {
+	mutex sync.Mutex
+
+	// clients contains the clients watching each key.
+	clients map[watchedKey]map[*Client]bool
+
+	// keys contains the keys watched by each client.
+	keys map[*Client]map[watchedKey]bool
+
+	// dirty contains the clients that have seen a watched key modified since they watched it.
+	dirty map[*Client]bool
+}
+
+func newWatches() *watches {
+	return &watches{
+		clients: make(map[watchedKey]map[*Client]bool),
+		keys:    make(map[*Client]map[watchedKey]bool),
+		dirty:   make(map[*Client]bool),
+	}
+}
+
+// Watch watches keys of a logical database on behalf of a client.
+func (w *watches) Watch(client *Client, database int, keys ...string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.keys[client] == nil {
+		w.keys[client] = make(map[watchedKey]bool)
+	}
+
+	for _, key := range keys {
+		k := watchedKey{db: database, key: key}
+
+		if w.clients[k] == nil {
+			w.clients[k] = make(map[*Client]bool)
+		}
+
+		w.clients[k][client] = true
+		w.keys[client][k] = true
+	}
+}
+
+// Unwatch forgets every key watched by a client.
+func (w *watches) Unwatch(client *Client) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for k := range w.keys[client] {
+		delete(w.clients[k], client)
+
+		if len(w.clients[k]) == 0 {
+			delete(w.clients, k)
+		}
+	}
+
+	delete(w.keys, client)
+	delete(w.dirty, client)
+}
+
+// Dirty returns true if a key watched by the client has been modified since it was watched.
+func (w *watches) Dirty(client *Client) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.dirty[client]
+}
+
+// Touch marks the clients watching any of the given keys as dirty.
+func (w *watches) Touch(database int, keys ...string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(w.clients) == 0 {
+		return
+	}
+
+	for _, key := range keys {
+		for client := range w.clients[watchedKey{db: database, key: key}] {
+			w.dirty[client] = true
+		}
+	}
+}
+
+// TouchDatabase marks the clients watching any key of a logical database as dirty.
+func (w *watches) TouchDatabase(database int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for k, clients := range w.clients {
+		if k.db != database {
+			continue
+		}
+
+		for client := range clients {
+			w.dirty[client] = true
+		}
+	}
+}
+
+// TouchAll marks every client watching a key as dirty.
+func (w *watches) TouchAll() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for client := range w.keys {
+		w.dirty[client] = true
+	}
+}
+
+// commandKeys returns the keys that a replicated command writes to.
+func commandKeys(cmd *CommandMessage) []string {
+	command := lookupCommand(cmd.Command)
+	if command == nil {
+		return nil
+	}
+
+	return command.ArgumentKeys(cmd.Arguments)
+}
+
+// queue queues a command sent between MULTI and EXEC, replying QUEUED.
+// Returns false if the command controls the transaction and must run immediately instead.
+func (server *Server) queue(conn redcon.Conn, client *Client, command *Command, cmd redcon.Command) bool {
+	switch command.Name {
+	case "multi", "exec", "discard", "watch":
+		return false
+	}
+
+	if command.Flags&CommandNoMulti != 0 {
+		client.Aborted = true
+		conn.WriteError("ERR Command not allowed inside a transaction")
+
+		return true
+	}
+
+	// The arguments of a command refer to the connection's read buffer, so they are copied before being kept.
+	queued := redcon.Command{Args: make([][]byte, len(cmd.Args))}
+	for i, arg := range cmd.Args {
+		queued.Args[i] = append([]byte{}, arg...)
+	}
+
+	client.Queued = append(client.Queued, queued)
+
+	conn.WriteString("QUEUED")
+
+	return true
+}
+
+// exec runs the commands queued by a client in a single transaction and broadcasts their writes as one command.
+func (server *Server) exec(conn redcon.Conn, client *Client) {
+	queued, aborted := client.Queued, client.Aborted
+	client.Multi, client.Queued, client.Aborted = false, nil, false
+
+	defer server.watches.Unwatch(client)
+
+	if aborted {
+		conn.WriteError("EXECABORT Transaction discarded because of previous errors.")
+		return
+	}
+
+	replies := make([]*replyRecorder, 0, len(queued))
+	batch := make([]*CommandMessage, 0)
+	modified := false
+
+	// message is the command that the transaction's writes are replicated with, or nil if it wrote nothing.
+	var message *CommandMessage
+
+	err := server.db.Batch(func(tx *db.Database) error {
+		// Watched keys are checked once no other write can be applied, so that the check and the transaction are atomic.
+		if server.watches.Dirty(client) {
+			modified = true
+			return nil
+		}
+
+		for _, cmd := range queued {
+			database, err := tx.Select(client.DB)
+			if err != nil {
+				return err
+			}
+
+			reply := &replyRecorder{Conn: conn}
+
+			server.handle(&Request{server: server, client: client, database: database, batch: &batch}, reply, cmd)
+
+			replies = append(replies, reply)
+		}
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		message = server.NewCommandMessage(client.DB, "exec", nil)
+		message.Batch = batch
+
+		return server.writeWAL(tx, message)
+	})
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if modified {
+		writeNullArray(conn)
+		return
+	}
+
+	if message != nil {
+		server.invalidate(message, client)
+
+		err := server.broadcast(message)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to broadcast transaction")
+		}
+	}
+
+	conn.WriteArray(len(replies))
+
+	for _, reply := range replies {
+		conn.WriteRaw(reply.buf)
+	}
+}
+
+// applyBatch applies the commands of a transaction replicated from another node in a single database transaction.
+// As on the originating node, a command that fails does not prevent the others from being applied.
+func (server *Server) applyBatch(root *db.Database, batch []*CommandMessage) error {
+	return root.Batch(func(tx *db.Database) error {
+		for _, cmd := range batch {
+			_, err := server.applyCommand(tx, cmd)
+			if err != nil && err != errFlushed {
+				logrus.WithError(err).WithField("command", cmd.Command).Warn("failed to apply command in transaction")
+			}
+		}
+
+		return nil
+	})
+}
+
+// replyRecorder is a connection that records the replies written to it, so that the replies to the commands of a
+// transaction can be written together once it has committed.
+type replyRecorder struct {
+	redcon.Conn
+
+	buf []byte
+}
+
+func (r *replyRecorder) WriteError(msg string)       { r.buf = redcon.AppendError(r.buf, msg) }
+func (r *replyRecorder) WriteString(str string)      { r.buf = redcon.AppendString(r.buf, str) }
+func (r *replyRecorder) WriteBulk(bulk []byte)       { r.buf = redcon.AppendBulk(r.buf, bulk) }
+func (r *replyRecorder) WriteBulkString(bulk string) { r.buf = redcon.AppendBulkString(r.buf, bulk) }
+func (r *replyRecorder) WriteInt(num int)            { r.buf = redcon.AppendInt(r.buf, int64(num)) }
+func (r *replyRecorder) WriteInt64(num int64)        { r.buf = redcon.AppendInt(r.buf, num) }
+func (r *replyRecorder) WriteUint64(num uint64)      { r.buf = redcon.AppendUint(r.buf, num) }
+func (r *replyRecorder) WriteArray(count int)        { r.buf = redcon.AppendArray(r.buf, count) }
+func (r *replyRecorder) WriteNull()                  { r.buf = redcon.AppendNull(r.buf) }
+func (r *replyRecorder) WriteRaw(data []byte)        { r.buf = append(r.buf, data...) }
+func (r *replyRecorder) WriteAny(v interface{})      { r.buf = redcon.AppendAny(r.buf, v) }
@@ -0,0 +1,63 @@
+package globalflow
+
+import (
+	"github.com/tidwall/redcon"
+	"testing"
+)
+
+func TestWatches_Touch(t *testing.T) {
+	w := newWatches()
+	a := &Client{}
+	b := &Client{}
+
+	w.Watch(a, 0, "foo")
+	w.Watch(b, 1, "foo")
+
+	w.Touch(0, "foo", "bar")
+
+	if !w.Dirty(a) {
+		t.Error("expected a to be dirty after its watched key was modified")
+	}
+
+	if w.Dirty(b) {
+		t.Error("expected b not to be dirty, as it watches a key in another database")
+	}
+
+	w.Unwatch(a)
+
+	if w.Dirty(a) {
+		t.Error("expected a not to be dirty after unwatching")
+	}
+
+	w.TouchDatabase(1)
+
+	if !w.Dirty(b) {
+		t.Error("expected b to be dirty after its database was flushed")
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	keys := commandKeys(&CommandMessage{Command: "lmove", Arguments: []string{"src", "dst", "left", "right"}})
+	if len(keys) != 2 || keys[0] != "src" || keys[1] != "dst" {
+		t.Errorf("expected [src dst], got %v", keys)
+	}
+
+	keys = commandKeys(&CommandMessage{Command: "hset", Arguments: []string{"hash", "field", "value"}})
+	if len(keys) != 1 || keys[0] != "hash" {
+		t.Errorf("expected [hash], got %v", keys)
+	}
+}
+
+func TestReplyRecorder(t *testing.T) {
+	r := &replyRecorder{}
+
+	r.WriteArray(2)
+	r.WriteInt(1)
+	r.WriteBulkString("foo")
+
+	if string(r.buf) != "*2\r\n:1\r\n$3\r\nfoo\r\n" {
+		t.Errorf("unexpected reply %q", r.buf)
+	}
+
+	var _ redcon.Conn = r
+}
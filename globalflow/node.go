@@ -53,6 +53,11 @@ func (n *Node) Address() string {
 	return fmt.Sprintf("%s:%d", n.node.Addr.String(), n.node.Port)
 }
 
+// SocketAddress returns the address that the node accepts websocket connections on.
+func (n *Node) SocketAddress() string {
+	return fmt.Sprintf("%s:%d", n.node.Addr.String(), int(n.node.Port)+websocketPortOffset)
+}
+
 // Nodes returns the nodes in the cluster.
 func (server *Server) Nodes() []*Node {
 	nodes := make([]*Node, 0)
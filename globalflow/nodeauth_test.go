@@ -0,0 +1,67 @@
+package globalflow
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifyNodeToken(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	now := time.Unix(1000, 0)
+	token := nodeToken(key, "a", now.Unix())
+
+	if err := verifyNodeToken(key, token, "a", now.Add(30*time.Second)); err != nil {
+		t.Errorf("expected the token to be accepted, got %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		key   []byte
+		token string
+		node  string
+		now   time.Time
+	}{
+		{name: "other node", key: key, token: token, node: "b", now: now},
+		{name: "other key", key: []byte("fedcba9876543210"), token: token, node: "a", now: now},
+		{name: "expired", key: key, token: token, node: "a", now: now.Add(2 * time.Minute)},
+		{name: "malformed", key: key, token: "token", node: "a", now: now},
+		{name: "missing", key: key, token: "", node: "a", now: now},
+	}
+
+	for _, test := range tests {
+		if err := verifyNodeToken(test.key, test.token, test.node, test.now); err != errNodeToken {
+			t.Errorf("%s: expected errNodeToken, got %v", test.name, err)
+		}
+	}
+}
+
+func TestServer_AuthenticateNode(t *testing.T) {
+	server := newTestServer(t, "a")
+
+	// Without TLS or a gossip key, nodes are not authenticated, and their data is not served.
+	if server.nodesAuthenticated() {
+		t.Error("expected nodes not to be authenticated")
+	}
+
+	server.container.Configuration.GossipKey = []byte("0123456789abcdef")
+
+	if !server.nodesAuthenticated() {
+		t.Error("expected nodes to be authenticated with the gossip key")
+	}
+
+	request := httptest.NewRequest("GET", snapshotPath, nil)
+	request.Header.Set(NodeNameHTTPHeader, "b")
+
+	if err := server.authenticateNode(request); err == nil {
+		t.Error("expected a request without a token to be rejected")
+	}
+
+	peer := newTestServer(t, "b")
+	peer.container.Configuration.GossipKey = server.container.Configuration.GossipKey
+	request.Header = peer.nodeHeader()
+
+	if err := server.authenticateNode(request); err != nil {
+		t.Errorf("expected the request of a node with the gossip key to be accepted, got %v", err)
+	}
+}
@@ -5,14 +5,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"globalflow/globalflow/db"
 	"nhooyr.io/websocket"
 	"strings"
+	"time"
 )
 
 type MessageType string
 
 const (
 	MessageTypeCommand MessageType = "command"
+	MessageTypePublish MessageType = "publish"
+	MessageTypeResend  MessageType = "resend"
+	MessageTypeSync    MessageType = "sync"
 )
 
 type Message interface {
@@ -33,6 +38,28 @@ type CommandMessage struct {
 	Arguments  []string `json:"arguments"`
 	Originator string   `json:"originator"`
 	TTL        int      `json:"ttl"`
+
+	// DB is the logical database that the command writes to.
+	DB int `json:"db,omitempty"`
+
+	// ExpiresAt is the absolute deadline of the key, computed once on the originating node.
+	// It is zero if the key does not expire.
+	ExpiresAt db.Time `json:"expiresAt,omitempty"`
+
+	// Batch contains the commands of a transaction, which are applied atomically.
+	// It is only set for the "exec" command.
+	Batch []*CommandMessage `json:"batch,omitempty"`
+
+	// Tags contains the add tags that the originating node observed for each set member removed by the command.
+	Tags map[string][]string `json:"tags,omitempty"`
+
+	// SentAt is the wall clock time at which the originating node created the command, in Unix milliseconds.
+	// It is used to measure replication lag.
+	SentAt int64 `json:"sentAt,omitempty"`
+
+	// Sequence is the position of the command among the commands of its originating node, which is assigned when the
+	// command is recorded in that node's write-ahead log. The commands in a transaction's batch have none.
+	Sequence uint64 `json:"sequence,omitempty"`
 }
 
 func (CommandMessage) MessageType() MessageType {
@@ -51,6 +78,125 @@ func (message *CommandMessage) GetOriginator() string {
 	return message.Originator
 }
 
+// Version returns the version of the writes made by the command.
+func (message *CommandMessage) Version() db.Version {
+	return db.Version{
+		Time: db.Time(message.Time),
+		Node: message.Originator,
+	}
+}
+
+// PublishMessage carries a message published to a Pub/Sub channel.
+// It is forwarded to every node like a command, but is only delivered to subscribers and never written to the database.
+type PublishMessage struct {
+	Time       Time   `json:"clock"`
+	Channel    string `json:"channel"`
+	Message    string `json:"message"`
+	Originator string `json:"originator"`
+	TTL        int    `json:"ttl"`
+}
+
+func (PublishMessage) MessageType() MessageType {
+	return MessageTypePublish
+}
+
+func (message *PublishMessage) GetTTL() int {
+	return message.TTL
+}
+
+func (message *PublishMessage) DecrementTTL() {
+	message.TTL--
+}
+
+func (message *PublishMessage) GetOriginator() string {
+	return message.Originator
+}
+
+// ID uniquely identifies the message, so that it is only delivered once on each node.
+func (message *PublishMessage) ID() string {
+	return fmt.Sprintf("%d@%s", message.Time, message.Originator)
+}
+
+// ResendMessage asks a node to resend the commands of an origin that the requester is missing, from its write-ahead log.
+// It is sent directly to one node and never forwarded.
+type ResendMessage struct {
+	// Origin is the node that originated the missing commands.
+	Origin string `json:"origin"`
+
+	// From and To are the sequence numbers of the first and last missing commands.
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+
+	// Requester is the node that is missing the commands.
+	Requester string `json:"requester"`
+}
+
+func (ResendMessage) MessageType() MessageType {
+	return MessageTypeResend
+}
+
+func (message *ResendMessage) GetTTL() int {
+	return 0
+}
+
+func (message *ResendMessage) DecrementTTL() {}
+
+func (message *ResendMessage) GetOriginator() string {
+	return message.Requester
+}
+
+// SyncMessage is exchanged between two nodes during a round of anti-entropy, to find the keys whose state differs and
+// repair them. It is sent directly to one node and never forwarded.
+type SyncMessage struct {
+	// Round identifies the round of anti-entropy, so that both nodes compare the hash trees they built for it.
+	Round string `json:"round"`
+
+	// Sender is the node that sent the message, which any reply is sent to.
+	Sender string `json:"sender"`
+
+	// Hashes contains the sender's hashes of the nodes of its hash tree that the receiver should compare, by path.
+	Hashes map[string][]byte `json:"hashes,omitempty"`
+
+	// Digests contains the sender's digests of the keys in the leaves that differ, by path and key.
+	Digests map[string]map[string][]byte `json:"digests,omitempty"`
+
+	// Entries contains the sender's state of keys that differ, which the receiver merges.
+	Entries []SyncEntry `json:"entries,omitempty"`
+
+	// Want contains the keys whose state the sender wants from the receiver.
+	Want []SyncKey `json:"want,omitempty"`
+
+	// size is the size of the encoded message, as it was received.
+	size int
+}
+
+// SyncKey identifies a key in a logical database.
+type SyncKey struct {
+	DB  int    `json:"db"`
+	Key string `json:"key"`
+}
+
+// SyncEntry is the state of a key in a logical database.
+type SyncEntry struct {
+	DB int `json:"db"`
+
+	db.Entry
+}
+
+func (SyncMessage) MessageType() MessageType {
+	return MessageTypeSync
+}
+
+func (message *SyncMessage) GetTTL() int {
+	return 0
+}
+
+func (message *SyncMessage) DecrementTTL() {}
+
+func (message *SyncMessage) GetOriginator() string {
+	return message.Sender
+}
+
 // decodeMessage decodes a message from a byte slice.
 func decodeMessage(data []byte) (interface{}, error) {
 	var message internalMessage
@@ -66,6 +212,32 @@ func decodeMessage(data []byte) (interface{}, error) {
 		}
 
 		return command, nil
+
+	case MessageTypePublish:
+		var publish PublishMessage
+		if err := json.Unmarshal(message.Payload, &publish); err != nil {
+			return nil, err
+		}
+
+		return publish, nil
+
+	case MessageTypeResend:
+		var resend ResendMessage
+		if err := json.Unmarshal(message.Payload, &resend); err != nil {
+			return nil, err
+		}
+
+		return resend, nil
+
+	case MessageTypeSync:
+		var sync SyncMessage
+		if err := json.Unmarshal(message.Payload, &sync); err != nil {
+			return nil, err
+		}
+
+		sync.size = len(data)
+
+		return sync, nil
 	}
 
 	return nil, nil
@@ -86,17 +258,33 @@ func encodeMessage(message Message) ([]byte, error) {
 	return json.Marshal(internal)
 }
 
-func (server *Server) NewCommandMessage(command string, arguments []string) *CommandMessage {
+func (server *Server) NewCommandMessage(database int, command string, arguments []string) *CommandMessage {
 	return &CommandMessage{
+		DB:         database,
 		Time:       server.clock.Get(),
 		Command:    strings.ToLower(command),
 		Arguments:  arguments,
 		Originator: server.container.Configuration.NodeID,
 		TTL:        len(server.gossip.Members()) + 1,
+		SentAt:     time.Now().UnixMilli(),
 	}
 }
 
-// broadcast broadcasts a message to other nodes.
+func (server *Server) NewPublishMessage(channel string, message string) *PublishMessage {
+	return &PublishMessage{
+		Time:       server.clock.Get(),
+		Channel:    channel,
+		Message:    message,
+		Originator: server.container.Configuration.NodeID,
+		TTL:        len(server.gossip.Members()) + 1,
+	}
+}
+
+// sendTimeout is how long writing a message to a websocket may take, after which the socket is considered broken.
+// A write that fails can leave the socket unusable without closing it, so later writes would otherwise wait forever.
+const sendTimeout = 10 * time.Second
+
+// broadcast broadcasts a message to the next node in each ring.
 // Returns an error if no nodes are available.
 func (server *Server) broadcast(message Message) error {
 	logrus.WithField("ttl", message.GetTTL()).Debugf("broadcasting message: %s", message)
@@ -108,32 +296,24 @@ func (server *Server) broadcast(message Message) error {
 
 	count := 0
 
-	next := server.NextLocalNode()
-	if next != nil {
-		logrus.Debug("broadcasting to local node")
-
-		err := server.gossip.SendReliable(next.node, encoded)
-
-		if err == nil {
-			count++
-		} else {
-			logrus.Error(err)
+	for _, next := range []*Node{server.NextLocalNode(), server.NextRemoteNode()} {
+		if next == nil {
+			continue
 		}
-	}
 
-	nextRemote := server.NextRemoteNode()
-	if nextRemote != nil {
-		logrus.Debug("broadcasting to remote node")
+		err := server.send(next, encoded)
+		if err != nil {
+			logrus.WithError(err).WithField("node", next.NodeID()).Error("failed to send message")
 
-		c, err := server.GetSocket(nextRemote)
-		if err == nil {
-			err := c.Write(context.Background(), websocket.MessageText, encoded)
-			if err == nil {
-				count++
+			// Commands are handed off to the node once it can be reached again. Published messages are not stored.
+			if message.MessageType() == MessageTypeCommand {
+				server.hint(next, encoded)
 			}
-		} else {
-			logrus.Error(err)
+
+			continue
 		}
+
+		count++
 	}
 
 	if count == 0 {
@@ -142,3 +322,40 @@ func (server *Server) broadcast(message Message) error {
 
 	return nil
 }
+
+// sendMessage sends a message to a single node.
+func (server *Server) sendMessage(node *Node, message Message) error {
+	encoded, err := encodeMessage(message)
+	if err != nil {
+		return err
+	}
+
+	return server.send(node, encoded)
+}
+
+// send sends an encoded message to a node, over gossip if it is in the same region and over a websocket otherwise.
+func (server *Server) send(node *Node, encoded []byte) error {
+	// With TLS, messages to nodes in the same region are also sent over an authenticated websocket rather than gossip.
+	metadata := node.Metadata()
+	if server.nodeTLS == nil && metadata != nil && metadata.Region == server.container.Configuration.NodeRegion {
+		return server.gossip.SendReliable(node.node, encoded)
+	}
+
+	c, err := server.GetSocket(node)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	err = c.Write(ctx, websocket.MessageText, encoded)
+	if err != nil {
+		// The socket is discarded, so that the next message to the node dials a new one.
+		server.dropSocket(node, c)
+
+		return err
+	}
+
+	return nil
+}
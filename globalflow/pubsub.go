@@ -0,0 +1,442 @@
+package globalflow
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/redcon"
+	"globalflow/globalflow/db"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// publishDedupWindow is how long the IDs of published messages are remembered.
+// A message can reach a node along more than one path through the rings, and is only delivered the first time.
+const publishDedupWindow = time.Minute
+
+// pubSub delivers published messages to the subscribers connected to this node.
+type pubSub struct {
+	mutex sync.RWMutex
+
+	// channels contains the subscribers of each channel.
+	channels map[string]map[*subscriber]bool
+
+	// patterns contains the subscribers of each pattern.
+	patterns map[string]map[*subscriber]bool
+
+	// seen contains the IDs of published messages received from other nodes, with the time they were received.
+	seen map[string]time.Time
+
+	// pruned is the last time expired IDs were removed from seen.
+	pruned time.Time
+}
+
+// subscriber is a Redis connection that has been detached from the command handler to receive published messages.
+type subscriber struct {
+	*pushConn
+
+	// channels and patterns are guarded by the pubSub mutex.
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		channels: make(map[string]map[*subscriber]bool),
+		patterns: make(map[string]map[*subscriber]bool),
+		seen:     make(map[string]time.Time),
+	}
+}
+
+func newSubscriber(p *pushConn) *subscriber {
+	s := &subscriber{
+		pushConn: p,
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool),
+	}
+
+	p.subscriber = s
+
+	return s
+}
+
+// registry returns the subscriptions of either channels or patterns.
+func (ps *pubSub) registry(pattern bool) map[string]map[*subscriber]bool {
+	if pattern {
+		return ps.patterns
+	}
+
+	return ps.channels
+}
+
+// subscriptions returns the channels or patterns that a subscriber is subscribed to.
+func (s *subscriber) subscriptions(pattern bool) map[string]bool {
+	if pattern {
+		return s.patterns
+	}
+
+	return s.channels
+}
+
+// Subscribe subscribes to a channel or pattern.
+// Returns the number of channels and patterns the subscriber is now subscribed to.
+func (ps *pubSub) Subscribe(s *subscriber, pattern bool, name string) int {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	registry := ps.registry(pattern)
+
+	if registry[name] == nil {
+		registry[name] = make(map[*subscriber]bool)
+	}
+
+	registry[name][s] = true
+	s.subscriptions(pattern)[name] = true
+
+	return len(s.channels) + len(s.patterns)
+}
+
+// Unsubscribe unsubscribes from a channel or pattern.
+// Returns the number of channels and patterns the subscriber is still subscribed to.
+func (ps *pubSub) Unsubscribe(s *subscriber, pattern bool, name string) int {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	registry := ps.registry(pattern)
+
+	delete(registry[name], s)
+	if len(registry[name]) == 0 {
+		delete(registry, name)
+	}
+
+	delete(s.subscriptions(pattern), name)
+
+	return len(s.channels) + len(s.patterns)
+}
+
+// Subscribed returns true if a subscriber is subscribed to a channel.
+func (ps *pubSub) Subscribed(s *subscriber, channel string) bool {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return s.channels[channel]
+}
+
+// Subscriptions returns the channels or patterns that a subscriber is subscribed to, in sorted order.
+func (ps *pubSub) Subscriptions(s *subscriber, pattern bool) []string {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	names := make([]string, 0)
+	for name := range s.subscriptions(pattern) {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Remove removes every subscription of a subscriber whose connection has closed.
+func (ps *pubSub) Remove(s *subscriber) {
+	for _, pattern := range []bool{false, true} {
+		for _, name := range ps.Subscriptions(s, pattern) {
+			ps.Unsubscribe(s, pattern, name)
+		}
+	}
+}
+
+// Publish delivers a message to the subscribers of a channel and of the patterns that match it.
+// Returns the number of deliveries.
+func (ps *pubSub) Publish(channel string, message string) int {
+	type delivery struct {
+		subscriber *subscriber
+		pattern    string
+	}
+
+	deliveries := make([]delivery, 0)
+
+	ps.mutex.RLock()
+
+	for s := range ps.channels[channel] {
+		deliveries = append(deliveries, delivery{subscriber: s})
+	}
+
+	for pattern, subscribers := range ps.patterns {
+		if !db.Match(pattern, channel) {
+			continue
+		}
+
+		for s := range subscribers {
+			deliveries = append(deliveries, delivery{subscriber: s, pattern: pattern})
+		}
+	}
+
+	ps.mutex.RUnlock()
+
+	// Messages are written outside the lock, so that a slow subscriber does not hold up subscriptions.
+	for _, d := range deliveries {
+		pattern := d.pattern
+
+		d.subscriber.write(func(conn redcon.DetachedConn) {
+			if pattern == "" {
+				writePush(conn, 3)
+				conn.WriteBulkString("message")
+			} else {
+				writePush(conn, 4)
+				conn.WriteBulkString("pmessage")
+				conn.WriteBulkString(pattern)
+			}
+
+			conn.WriteBulkString(channel)
+			conn.WriteBulkString(message)
+		})
+	}
+
+	return len(deliveries)
+}
+
+// Channels returns the channels with at least one subscriber that match a pattern, in sorted order.
+// An empty pattern matches every channel.
+func (ps *pubSub) Channels(pattern string) []string {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	channels := make([]string, 0)
+
+	for channel := range ps.channels {
+		if pattern == "" || db.Match(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+
+	sort.Strings(channels)
+
+	return channels
+}
+
+// NumSub returns the number of subscribers of a channel, not counting pattern subscribers.
+func (ps *pubSub) NumSub(channel string) int {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return len(ps.channels[channel])
+}
+
+// NumPat returns the number of patterns with at least one subscriber.
+func (ps *pubSub) NumPat() int {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return len(ps.patterns)
+}
+
+// Seen records that a published message has been received.
+// Returns true if it had already been received.
+func (ps *pubSub) Seen(id string, now time.Time) bool {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if now.Sub(ps.pruned) > publishDedupWindow {
+		for seen, at := range ps.seen {
+			if now.Sub(at) > publishDedupWindow {
+				delete(ps.seen, seen)
+			}
+		}
+
+		ps.pruned = now
+	}
+
+	if _, ok := ps.seen[id]; ok {
+		return true
+	}
+
+	ps.seen[id] = now
+
+	return false
+}
+
+// subscribe detaches a connection from the command handler and serves its subscriptions until it closes.
+func (server *Server) subscribe(conn redcon.Conn, client *Client, cmd redcon.Command) {
+	s := newSubscriber(server.detach(conn, client))
+
+	server.handleSubscriberCommand(s, cmd)
+
+	// A subscriber can receive the invalidation messages redirected to it.
+	server.tracking.Register(client.ID, s.pushConn)
+
+	go server.serveSubscriber(s)
+}
+
+// serveSubscriber reads the commands of a subscribed connection until it closes.
+func (server *Server) serveSubscriber(s *subscriber) {
+	defer func() {
+		server.pubsub.Remove(s)
+
+		if client, ok := s.conn.Context().(*Client); ok {
+			server.release(client)
+		}
+
+		err := s.conn.Close()
+		if err != nil {
+			logrus.WithError(err).Debug("failed to close subscriber")
+		}
+	}()
+
+	for {
+		cmd, err := s.conn.ReadCommand()
+		if err != nil {
+			return
+		}
+
+		server.commandsProcessed.Add(1)
+
+		if !server.handleSubscriberCommand(s, cmd) {
+			return
+		}
+	}
+}
+
+// handleSubscriberCommand handles a command sent by a subscribed connection.
+// Returns false if the connection should be closed.
+func (server *Server) handleSubscriberCommand(s *subscriber, cmd redcon.Command) bool {
+	command := strings.ToLower(string(cmd.Args[0]))
+	args := arguments(cmd)
+
+	// Commands that are unknown or not allowed in this context are refused below.
+	client, ok := s.conn.Context().(*Client)
+	if c := lookupCommand(command); ok && c != nil {
+		err := server.authorize(client, c, args)
+		if err != nil {
+			s.write(func(conn redcon.DetachedConn) {
+				conn.WriteError(err.Error())
+			})
+
+			return true
+		}
+	}
+
+	switch command {
+	default:
+		s.write(func(conn redcon.DetachedConn) {
+			conn.WriteError("ERR Can't execute '" + command + "': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+		})
+
+	case "subscribe", "psubscribe":
+		if len(args) == 0 {
+			s.write(func(conn redcon.DetachedConn) {
+				conn.WriteError("ERR wrong number of arguments for '" + command + "' command")
+			})
+
+			return true
+		}
+
+		pattern := command == "psubscribe"
+
+		for _, name := range args {
+			count := server.pubsub.Subscribe(s, pattern, name)
+
+			s.write(func(conn redcon.DetachedConn) {
+				writePush(conn, 3)
+				conn.WriteBulkString(command)
+				conn.WriteBulkString(name)
+				conn.WriteInt(count)
+			})
+		}
+
+	case "unsubscribe", "punsubscribe":
+		pattern := command == "punsubscribe"
+
+		// Without arguments, every channel or pattern is unsubscribed.
+		if len(args) == 0 {
+			args = server.pubsub.Subscriptions(s, pattern)
+		}
+
+		if len(args) == 0 {
+			count := server.pubsub.Unsubscribe(s, pattern, "")
+
+			s.write(func(conn redcon.DetachedConn) {
+				writePush(conn, 3)
+				conn.WriteBulkString(command)
+				writeNull(conn)
+				conn.WriteInt(count)
+			})
+		}
+
+		for _, name := range args {
+			count := server.pubsub.Unsubscribe(s, pattern, name)
+
+			s.write(func(conn redcon.DetachedConn) {
+				writePush(conn, 3)
+				conn.WriteBulkString(command)
+				conn.WriteBulkString(name)
+				conn.WriteInt(count)
+			})
+		}
+
+	case "ping":
+		s.write(func(conn redcon.DetachedConn) {
+			// RESP3 connections receive the usual reply, as published messages are distinguishable as pushes.
+			if resp3(conn) {
+				if len(args) > 0 {
+					conn.WriteBulkString(args[0])
+				} else {
+					conn.WriteString("PONG")
+				}
+
+				return
+			}
+
+			conn.WriteArray(2)
+			conn.WriteBulkString("pong")
+
+			if len(args) > 0 {
+				conn.WriteBulkString(args[0])
+			} else {
+				conn.WriteBulkString("")
+			}
+		})
+
+	case "quit":
+		s.write(func(conn redcon.DetachedConn) {
+			conn.WriteString("OK")
+		})
+
+		return false
+	}
+
+	return true
+}
+
+// publish delivers a message to the subscribers on this node and broadcasts it to the rest of the cluster.
+// Returns the number of deliveries on this node.
+func (server *Server) publish(channel string, message string) int {
+	count := server.pubsub.Publish(channel, message)
+
+	err := server.broadcast(server.NewPublishMessage(channel, message))
+	if err != nil {
+		logrus.WithError(err).Warn("failed to broadcast published message")
+	}
+
+	return count
+}
+
+// handlePublish delivers a message published on another node and forwards it along the rings.
+func (server *Server) handlePublish(message *PublishMessage) {
+	server.clock.Set(message.Time)
+
+	if message.Originator == server.container.Configuration.NodeID || server.pubsub.Seen(message.ID(), time.Now()) {
+		return
+	}
+
+	server.pubsub.Publish(message.Channel, message.Message)
+
+	message.DecrementTTL()
+
+	if message.GetTTL() > 0 {
+		err := server.broadcast(message)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to broadcast published message")
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package globalflow
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPubSub_Subscriptions(t *testing.T) {
+	ps := newPubSub()
+	a := newSubscriber(&pushConn{})
+	b := newSubscriber(&pushConn{})
+
+	ps.Subscribe(a, false, "news")
+	ps.Subscribe(b, false, "news")
+	ps.Subscribe(b, false, "sport")
+
+	if count := ps.Subscribe(b, true, "n*"); count != 3 {
+		t.Errorf("expected 3 subscriptions, got %d", count)
+	}
+
+	if channels := ps.Channels("n*"); !reflect.DeepEqual(channels, []string{"news"}) {
+		t.Errorf("expected [news], got %v", channels)
+	}
+
+	if n := ps.NumSub("news"); n != 2 {
+		t.Errorf("expected 2 subscribers, got %d", n)
+	}
+
+	if n := ps.NumPat(); n != 1 {
+		t.Errorf("expected 1 pattern, got %d", n)
+	}
+
+	ps.Remove(b)
+
+	if channels := ps.Channels(""); !reflect.DeepEqual(channels, []string{"news"}) {
+		t.Errorf("expected [news], got %v", channels)
+	}
+
+	if n := ps.NumPat(); n != 0 {
+		t.Errorf("expected no patterns, got %d", n)
+	}
+}
+
+func TestPubSub_Seen(t *testing.T) {
+	ps := newPubSub()
+	now := time.Now()
+
+	if ps.Seen("1@a", now) {
+		t.Error("expected the first delivery not to be seen")
+	}
+
+	if !ps.Seen("1@a", now.Add(time.Second)) {
+		t.Error("expected a duplicate delivery to be seen")
+	}
+
+	if ps.Seen("1@a", now.Add(2*publishDedupWindow)) {
+		t.Error("expected the ID to be forgotten after the dedup window")
+	}
+}
@@ -1,97 +1,2251 @@
 package globalflow
 
 import (
+	"errors"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"github.com/tidwall/redcon"
 	"globalflow/globalflow/db"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+var (
+	errSyntax     = errors.New("ERR syntax error")
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+)
+
+// expireUnits maps the EXPIRE family to the equivalent SET expiry option.
+var expireUnits = map[string]string{
+	"expire":    "ex",
+	"pexpire":   "px",
+	"expireat":  "exat",
+	"pexpireat": "pxat",
+}
+
 func (server *Server) Redis(conn redcon.Conn, cmd redcon.Command) {
-	switch strings.ToLower(string(cmd.Args[0])) {
+	client := server.client(conn)
+
+	server.commandsProcessed.Add(1)
+
+	command, err := checkCommand(cmd)
+	if err == nil {
+		err = server.authorize(client, command, arguments(cmd))
+	}
+
+	if err == nil && command.Flags&CommandLoading == 0 && server.loading.Load() {
+		err = errLoading
+	}
+
+	if err == nil && command.Flags&CommandWrite != 0 && server.clocks.fenced.Load() {
+		err = errClockSkew
+	}
+
+	if err != nil {
+		// A command that is unknown or refused inside a transaction aborts it, as if it could not be queued.
+		if client.Multi {
+			client.Aborted = true
+		}
+
+		conn.WriteError(err.Error())
+
+		return
+	}
+
+	if client.Multi && server.queue(conn, client, command, cmd) {
+		return
+	}
+
+	database, err := server.db.Select(client.DB)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	req := &Request{server: server, client: client, database: database}
+
+	if command.Flags&(CommandWrite|CommandAdmin) != 0 {
+		server.handleWrite(req, conn, cmd)
+	} else {
+		server.handle(req, conn, cmd)
+	}
+
+	// CLIENT CACHING applies to the command that follows it, or to every command of the transaction that follows it.
+	if !client.Multi && !(len(cmd.Args) > 1 && strings.EqualFold(string(cmd.Args[0]), "client") && strings.EqualFold(string(cmd.Args[1]), "caching")) {
+		client.Caching = ""
+	}
+
+	// A RESP3 connection that receives its own invalidation messages is detached, so that they can be pushed to it
+	// between replies.
+	if !client.Detached && client.Protocol == protocolRESP3 {
+		if options, ok := server.tracking.Options(client); ok && options.Redirect == 0 {
+			p := server.detach(conn, client)
+			server.tracking.Register(client.ID, p)
+
+			go server.serveTracked(p, client)
+		}
+	}
+}
+
+// handle runs a Redis command and writes its reply to the connection.
+// The command must have been checked with checkCommand.
+func (server *Server) handle(req *Request, conn redcon.Conn, cmd redcon.Command) {
+	command := lookupCommand(string(cmd.Args[0]))
+
+	req.name, req.cmd = command.Name, cmd
+	args := arguments(cmd)
+
+	server.trackRead(req.client, command, args)
+
+	command.Handler(req, conn, args)
+}
+
+// handleWrite runs a Redis command that may write in a single database transaction, in which the commands it replicates
+// are also recorded in the write-ahead log. They are broadcast once the transaction has committed, and the reply is
+// only written then, so that a client is never told a write succeeded that was rolled back.
+func (server *Server) handleWrite(req *Request, conn redcon.Conn, cmd redcon.Command) {
+	client := req.client
+
+	batch := make([]*CommandMessage, 0)
+	reply := &replyRecorder{Conn: conn}
+
+	err := server.db.Batch(func(tx *db.Database) error {
+		database, err := tx.Select(client.DB)
+		if err != nil {
+			return err
+		}
+
+		req.database, req.batch = database, &batch
+
+		server.handle(req, reply, cmd)
+
+		for _, message := range batch {
+			err := server.writeWAL(tx, message)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	for _, message := range batch {
+		server.invalidate(message, client)
+
+		err := server.broadcast(message)
+		if err != nil {
+			logrus.WithError(err).WithField("command", message.Command).Warn("failed to broadcast command")
+		}
+	}
+
+	conn.WriteRaw(reply.buf)
+}
+
+// multiCommand runs MULTI.
+func multiCommand(req *Request, conn redcon.Conn, args []string) {
+	client := req.client
+
+	if client.Multi {
+		conn.WriteError("ERR MULTI calls can not be nested")
+		return
+	}
+
+	client.Multi = true
+
+	conn.WriteString("OK")
+}
+
+// execCommand runs EXEC.
+func execCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	if !client.Multi {
+		conn.WriteError("ERR EXEC without MULTI")
+		return
+	}
+
+	server.exec(conn, client)
+}
+
+// discardCommand runs DISCARD.
+func discardCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	if !client.Multi {
+		conn.WriteError("ERR DISCARD without MULTI")
+		return
+	}
+
+	client.Multi, client.Queued, client.Aborted = false, nil, false
+	server.watches.Unwatch(client)
+
+	conn.WriteString("OK")
+}
+
+// watchCommand runs WATCH.
+func watchCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	if client.Multi {
+		conn.WriteError("ERR WATCH inside MULTI is not allowed")
+		return
+	}
+
+	server.watches.Watch(client, client.DB, args...)
+
+	conn.WriteString("OK")
+}
+
+// unwatchCommand runs UNWATCH.
+func unwatchCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	server.watches.Unwatch(client)
+
+	conn.WriteString("OK")
+}
+
+// helloCommand runs HELLO.
+func helloCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	options, err := parseHelloOptions(args)
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	if options.Auth {
+		err := server.authenticate(options.Username, options.Password)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+
+		client.User = options.Username
+	} else if server.user(client) == nil {
+		conn.WriteError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+
+	if options.Protocol != 0 {
+		client.Protocol = options.Protocol
+	}
+
+	if options.SetName {
+		client.Name = options.Name
+	}
+
+	// The protocol is switched before replying, so that the reply is already written in the requested protocol.
+	writeMap(conn, 7)
+	conn.WriteBulkString("server")
+	conn.WriteBulkString("redis")
+	conn.WriteBulkString("version")
+	conn.WriteBulkString(redisVersion)
+	conn.WriteBulkString("proto")
+	conn.WriteInt(client.Protocol)
+	conn.WriteBulkString("id")
+	conn.WriteInt64(client.ID)
+	conn.WriteBulkString("mode")
+	conn.WriteBulkString("standalone")
+	conn.WriteBulkString("role")
+	conn.WriteBulkString("master")
+	conn.WriteBulkString("modules")
+	conn.WriteArray(0)
+}
+
+// clientCommand runs CLIENT.
+func clientCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	switch strings.ToLower(args[0]) {
 	default:
-		conn.WriteError("ERR unknown command '" + string(cmd.Args[0]) + "'")
+		conn.WriteError("ERR unknown subcommand '" + args[0] + "'")
 
-	case "get":
-		if len(cmd.Args) != 2 {
-			conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+	case "id":
+		conn.WriteInt64(client.ID)
+
+	case "getname":
+		if client.Name == "" {
+			writeNull(conn)
 			return
 		}
 
-		key := string(cmd.Args[1])
+		conn.WriteBulkString(client.Name)
 
-		v, err := server.db.Get(db.Time(time.Now().UnixMilli()), key)
-		if db.IsErrorNotFound(err) {
-			conn.WriteNull()
+	case "setname":
+		if len(args) != 2 {
+			conn.WriteError("ERR wrong number of arguments for 'client|setname' command")
 			return
 		}
-		if err != nil {
-			conn.WriteError("ERR " + err.Error())
+
+		if !validClientName(args[1]) {
+			conn.WriteError(errClientName.Error())
 			return
 		}
 
-		conn.WriteString(v)
+		client.Name = args[1]
 
-		return
+		conn.WriteString("OK")
 
-	case "set":
-		if len(cmd.Args) != 3 {
-			conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+	case "setinfo":
+		// Client libraries describe themselves on connecting, which is accepted but not recorded.
+		if len(args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'client|setinfo' command")
 			return
 		}
 
-		args := make([]string, len(cmd.Args)-1)
+		conn.WriteString("OK")
+
+	case "tracking":
+		if len(args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'client|tracking' command")
+			return
+		}
 
-		for i := 1; i < len(cmd.Args); i++ {
-			args[i-1] = string(cmd.Args[i])
+		if req.batch != nil {
+			conn.WriteError("ERR Command not allowed inside a transaction")
+			return
 		}
 
-		message := server.NewCommandMessage(
-			string(cmd.Args[0]),
-			args,
-		)
+		switch strings.ToLower(args[1]) {
+		default:
+			conn.WriteError(errSyntax.Error())
 
-		server.processCommand(message)
-		err := server.broadcast(message)
-		if err != nil {
-			conn.WriteError("ERR " + err.Error())
+		case "on":
+			options, err := parseTrackingOptions(args[2:])
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+
+			err = server.tracking.Enable(client, options)
+			if err != nil {
+				conn.WriteError(err.Error())
+				return
+			}
+
+			conn.WriteString("OK")
+
+		case "off":
+			server.tracking.Disable(client)
+
+			conn.WriteString("OK")
+		}
+
+	case "caching":
+		if len(args) != 2 {
+			conn.WriteError("ERR wrong number of arguments for 'client|caching' command")
+			return
+		}
+
+		options, _ := server.tracking.Options(client)
+
+		switch strings.ToLower(args[1]) {
+		default:
+			conn.WriteError(errSyntax.Error())
 			return
+
+		case "yes":
+			if !options.OptIn {
+				conn.WriteError("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+				return
+			}
+
+		case "no":
+			if !options.OptOut {
+				conn.WriteError("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+				return
+			}
 		}
 
+		client.Caching = strings.ToLower(args[1])
+
 		conn.WriteString("OK")
 
+	case "getredir":
+		options, ok := server.tracking.Options(client)
+		if !ok {
+			conn.WriteInt(-1)
+			return
+		}
+
+		conn.WriteInt64(options.Redirect)
+	}
+}
+
+// authCommand runs AUTH.
+func authCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	var username, password string
+
+	switch len(args) {
+	default:
+		conn.WriteError(errWrongArity(req.name).Error())
+		return
+
+	case 1:
+		if user := server.acl.User("default"); user != nil && user.NoPass {
+			conn.WriteError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+			return
+		}
+
+		username, password = "default", args[0]
+
+	case 2:
+		username, password = args[0], args[1]
+	}
+
+	err := server.authenticate(username, password)
+	if err != nil {
+		conn.WriteError(err.Error())
 		return
+	}
+
+	client.User = username
+
+	conn.WriteString("OK")
+}
+
+// pingCommand runs PING.
+func pingCommand(req *Request, conn redcon.Conn, args []string) {
+	switch len(args) {
+	case 0:
+		conn.WriteString("PONG")
+
+	case 1:
+		conn.WriteBulkString(args[0])
+
+	default:
+		conn.WriteError(errWrongArity(req.name).Error())
+	}
+}
 
-	case "del":
-		if len(cmd.Args) != 2 {
-			conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
+// quitCommand runs QUIT.
+func quitCommand(req *Request, conn redcon.Conn, args []string) {
+	conn.WriteString("OK")
+
+	err := conn.Close()
+	if err != nil {
+		logrus.WithError(err).Debug("failed to close connection")
+	}
+}
+
+// aclCommand runs ACL.
+func aclCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	switch strings.ToLower(args[0]) {
+	default:
+		conn.WriteError("ERR unknown subcommand '" + args[0] + "'")
+
+	case "setuser":
+		if len(args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'acl|setuser' command")
 			return
 		}
 
-		args := make([]string, len(cmd.Args)-1)
+		name := args[1]
 
-		for i := 1; i < len(cmd.Args); i++ {
-			args[i-1] = string(cmd.Args[i])
+		user := server.acl.User(name)
+		if user == nil {
+			user = &aclUser{Name: name}
 		}
 
-		message := server.NewCommandMessage(
-			string(cmd.Args[0]),
-			args,
-		)
+		user, err := user.with(args[2:])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
 
-		server.processCommand(message)
-		err := server.broadcast(message)
+		// The complete definition is replicated, so that every node ends up with the same user.
+		_, err = req.Replicate(server.NewCommandMessage(client.DB, "aclsetuser", append([]string{name}, user.Rules()...)))
 		if err != nil {
-			conn.WriteError("ERR " + err.Error())
+			writeError(conn, err)
 			return
 		}
 
 		conn.WriteString("OK")
 
+	case "deluser":
+		if len(args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'acl|deluser' command")
+			return
+		}
+
+		deleted := 0
+
+		for _, name := range args[1:] {
+			if name == "default" {
+				conn.WriteError("ERR The 'default' user cannot be removed")
+				return
+			}
+
+			if server.acl.User(name) != nil {
+				deleted++
+			}
+		}
+
+		_, err := req.Replicate(server.NewCommandMessage(client.DB, "acldeluser", args[1:]))
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+
+		conn.WriteInt(deleted)
+
+	case "getuser":
+		if len(args) != 2 {
+			conn.WriteError("ERR wrong number of arguments for 'acl|getuser' command")
+			return
+		}
+
+		user := server.acl.User(args[1])
+		if user == nil {
+			writeNull(conn)
+			return
+		}
+
+		writeUser(conn, user)
+
+	case "list":
+		users := server.acl.Users()
+
+		conn.WriteArray(len(users))
+
+		for _, user := range users {
+			conn.WriteBulkString("user " + user.Name + " " + strings.Join(user.Rules(), " "))
+		}
+
+	case "users":
+		users := server.acl.Users()
+
+		conn.WriteArray(len(users))
+
+		for _, user := range users {
+			conn.WriteBulkString(user.Name)
+		}
+
+	case "whoami":
+		user := server.user(client)
+		if user == nil {
+			conn.WriteError(errNoAuth.Error())
+			return
+		}
+
+		conn.WriteBulkString(user.Name)
+
+	case "cat":
+		if len(args) == 1 {
+			categories := aclCategories()
+
+			conn.WriteArray(len(categories))
+
+			for _, category := range categories {
+				conn.WriteBulkString(category)
+			}
+
+			return
+		}
+
+		category := strings.ToLower(args[1])
+		if !knownCategory(category) {
+			conn.WriteError("ERR Unknown category '" + args[1] + "'")
+			return
+		}
+
+		commands := make([]string, 0)
+		for _, command := range clientCommands() {
+			if command.inCategory(category) {
+				commands = append(commands, command.Name)
+			}
+		}
+
+		conn.WriteArray(len(commands))
+
+		for _, command := range commands {
+			conn.WriteBulkString(command)
+		}
+	}
+}
+
+// selectCommand runs SELECT.
+func selectCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	index, err := parseInt(args[0])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	_, err = server.db.Select(index)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	client.DB = index
+
+	conn.WriteString("OK")
+}
+
+// flushdbCommand runs FLUSHDB and FLUSHALL.
+func flushdbCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	if len(args) > 1 {
+		conn.WriteError(errWrongArity(req.name).Error())
+		return
+	}
+
+	// Flushes are always applied synchronously, so ASYNC is accepted but has no effect.
+	if len(args) == 1 {
+		mode := strings.ToLower(args[0])
+		if mode != "async" && mode != "sync" {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	_, err := req.Replicate(server.NewCommandMessage(client.DB, req.name, nil))
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteString("OK")
+}
+
+// getCommand runs GET.
+func getCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	key := args[0]
+
+	v, err := database.Get(req.server.now(), key)
+	if db.IsErrorNotFound(err) {
+		writeNull(conn)
+		return
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteString(v)
+}
+
+// setCommand runs SET.
+func setCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client, database := req.server, req.client, req.database
+
+	now := server.clock.Current().Physical()
+
+	options, err := parseSetOptions(now, args[2:])
+	if err != nil {
+		conn.WriteError(err.Error())
 		return
+	}
 
-	case "info":
-		conn.WriteBulkString(fmt.Sprintf("peers:%d\r\n", len(server.gossip.Members())))
+	existing, err := database.Lookup(db.Time(now.UnixMilli()), args[0])
+	if err != nil && !db.IsErrorNotFound(err) {
+		writeError(conn, err)
+		return
 	}
+
+	if options.Get && existing != nil && existing.Type != db.DataTypeString {
+		writeError(conn, &db.ErrorWrongType{Key: args[0]})
+		return
+	}
+
+	if (options.NX && existing != nil) || (options.XX && existing == nil) {
+		if options.Get && existing != nil {
+			conn.WriteBulkString(existing.StringValue)
+		} else {
+			writeNull(conn)
+		}
+
+		return
+	}
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args[:2],
+	)
+
+	message.ExpiresAt = options.ExpiresAt
+	if options.KeepTTL && existing != nil {
+		message.ExpiresAt = existing.ExpiresAt
+	}
+
+	_, err = req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if !options.Get {
+		conn.WriteString("OK")
+	} else if existing != nil {
+		conn.WriteBulkString(existing.StringValue)
+	} else {
+		writeNull(conn)
+	}
+}
+
+// delCommand runs DEL and UNLINK.
+func delCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	deleted, err := req.Replicate(server.NewCommandMessage(client.DB, "del", args))
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(deleted.(int))
+}
+
+// existsCommand runs EXISTS.
+func existsCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	count, err := database.Exists(req.server.now(), args...)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(count)
+}
+
+// typeCommand runs TYPE.
+func typeCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	t, err := database.Type(req.server.now(), args[0])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteString(t)
+}
+
+// keysCommand runs KEYS.
+func keysCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	keys, err := database.Keys(req.server.now(), args[0])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	writeStrings(conn, keys)
+}
+
+// scanCommand runs SCAN.
+func scanCommand(req *Request, conn redcon.Conn, args []string) {
+	server, database := req.server, req.database
+
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		conn.WriteError("ERR invalid cursor")
+		return
+	}
+
+	options, err := parseScanOptions(args[1:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	after, ok := server.scanCursors.Load(cursor)
+	if !ok {
+		conn.WriteError("ERR invalid cursor")
+		return
+	}
+
+	keys, next, err := database.Scan(req.server.now(), after, options.Count, options.Match, options.Type)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	cursor = 0
+	if next != "" {
+		cursor = server.scanCursors.Save(next)
+	}
+
+	conn.WriteArray(2)
+	conn.WriteBulkString(strconv.FormatUint(cursor, 10))
+	writeStrings(conn, keys)
+}
+
+// dbsizeCommand runs DBSIZE.
+func dbsizeCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	size, err := database.Size(req.server.now())
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(size)
+}
+
+// randomkeyCommand runs RANDOMKEY.
+func randomkeyCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	key, err := database.RandomKey(req.server.now())
+	if db.IsErrorNotFound(err) {
+		writeNull(conn)
+		return
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteBulkString(key)
+}
+
+// renameCommand runs RENAME and RENAMENX.
+func renameCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client, database := req.server, req.client, req.database
+
+	nx := req.name == "renamenx"
+
+	// RENAMENX is evaluated against this node's keyspace and replicated as an unconditional rename.
+	if nx {
+		count, err := database.Exists(req.server.now(), args...)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+
+		if count == 0 {
+			writeError(conn, db.ErrNoSuchKey)
+			return
+		}
+
+		if count == 2 && args[0] != args[1] {
+			conn.WriteInt(0)
+			return
+		}
+	}
+
+	_, err := req.Replicate(server.NewCommandMessage(client.DB, "rename", args))
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if nx {
+		conn.WriteInt(1)
+	} else {
+		conn.WriteString("OK")
+	}
+}
+
+// expireCommand runs EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT.
+func expireCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client, database := req.server, req.client, req.database
+
+	command := req.name
+	now := server.clock.Current().Physical()
+
+	expiresAt, err := parseExpireTime(now, command, expireUnits[command], args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	conditions, err := parseExpireConditions(args[2:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	existing, err := database.Lookup(db.Time(now.UnixMilli()), args[0])
+	if db.IsErrorNotFound(err) {
+		conn.WriteInt(0)
+		return
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if !conditions.Allows(existing.ExpiresAt, expiresAt) {
+		conn.WriteInt(0)
+		return
+	}
+
+	var message *CommandMessage
+
+	// A deadline in the past deletes the key immediately, as Redis does.
+	if expiresAt <= db.Time(now.UnixMilli()) {
+		message = server.NewCommandMessage(client.DB, "del", args[:1])
+	} else {
+		message = server.NewCommandMessage(client.DB, "pexpireat", args[:1])
+		message.ExpiresAt = expiresAt
+	}
+
+	_, err = req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(1)
+}
+
+// ttlCommand runs TTL and PTTL.
+func ttlCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	ttl, err := database.TTL(req.server.now(), args[0])
+	if db.IsErrorNotFound(err) {
+		conn.WriteInt(-2)
+		return
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if ttl == db.NoExpiry {
+		conn.WriteInt(-1)
+		return
+	}
+
+	if req.name == "ttl" {
+		ttl = (ttl + 500) / 1000
+	}
+
+	conn.WriteInt64(int64(ttl))
+}
+
+// persistCommand runs PERSIST.
+func persistCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client, database := req.server, req.client, req.database
+
+	ttl, err := database.TTL(req.server.now(), args[0])
+	if db.IsErrorNotFound(err) || ttl == db.NoExpiry {
+		conn.WriteInt(0)
+		return
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	_, err = req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(1)
+}
+
+// lpushCommand runs LPUSH, RPUSH, LPUSHX and RPUSHX.
+func lpushCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	length, err := req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(length.(int))
+}
+
+// lpopCommand runs LPOP and RPOP.
+func lpopCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	if len(args) > 2 {
+		conn.WriteError(errWrongArity(req.name).Error())
+		return
+	}
+
+	count := 1
+
+	if len(args) == 2 {
+		n, err := parseInt(args[1])
+		if err != nil || n < 0 {
+			conn.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+
+		count = n
+	}
+
+	// Lists are not versioned, so a pop from a missing key would be logged and replicated without changing anything.
+	existing, err := req.database.Lookup(server.now(), args[0])
+	if err != nil && !db.IsErrorNotFound(err) {
+		writeError(conn, err)
+		return
+	}
+
+	if existing == nil {
+		if len(args) == 2 {
+			writeNullArray(conn)
+		} else {
+			writeNull(conn)
+		}
+
+		return
+	}
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		[]string{args[0], strconv.Itoa(count)},
+	)
+
+	result, err := req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	popped := result.([]string)
+
+	if len(args) == 2 {
+		if popped == nil {
+			writeNullArray(conn)
+			return
+		}
+
+		writeStrings(conn, popped)
+		return
+	}
+
+	if len(popped) == 0 {
+		writeNull(conn)
+		return
+	}
+
+	conn.WriteBulkString(popped[0])
+}
+
+// lrangeCommand runs LRANGE.
+func lrangeCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	start, err := parseInt(args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	stop, err := parseInt(args[2])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	values, err := database.LRange(req.server.now(), args[0], start, stop)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	writeStrings(conn, values)
+}
+
+// llenCommand runs LLEN.
+func llenCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	length, err := database.LLen(req.server.now(), args[0])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(length)
+}
+
+// lindexCommand runs LINDEX.
+func lindexCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	index, err := parseInt(args[1])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	value, err := database.LIndex(req.server.now(), args[0], index)
+	if db.IsErrorNotFound(err) {
+		writeNull(conn)
+		return
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteBulkString(value)
+}
+
+// lsetCommand runs LSET and LTRIM.
+func lsetCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	if _, err := parseInt(args[1]); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	// LTRIM also takes an integer stop index.
+	if req.name == "ltrim" {
+		if _, err := parseInt(args[2]); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+	}
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	_, err := req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteString("OK")
+}
+
+// lremCommand runs LREM.
+func lremCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	if _, err := parseInt(args[1]); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	removed, err := req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(removed.(int))
+}
+
+// linsertCommand runs LINSERT.
+func linsertCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	where := strings.ToLower(args[1])
+	if where != "before" && where != "after" {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	length, err := req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(length.(int))
+}
+
+// lmoveCommand runs LMOVE and RPOPLPUSH.
+func lmoveCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	// RPOPLPUSH is equivalent to LMOVE with RIGHT and LEFT.
+	if req.name == "rpoplpush" {
+		args = append(args, string(db.ListTail), string(db.ListHead))
+	}
+
+	for i := 2; i < 4; i++ {
+		args[i] = strings.ToLower(args[i])
+
+		if args[i] != string(db.ListHead) && args[i] != string(db.ListTail) {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	message := server.NewCommandMessage(client.DB, "lmove", args)
+
+	value, err := req.Replicate(message)
+	if db.IsErrorNotFound(err) {
+		writeNull(conn)
+		return
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteBulkString(value.(string))
+}
+
+// hsetCommand runs HSET and HMSET.
+func hsetCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	// The key is followed by field and value pairs.
+	if len(args)%2 == 0 {
+		conn.WriteError(errWrongArity(req.name).Error())
+		return
+	}
+
+	message := server.NewCommandMessage(client.DB, "hset", args)
+
+	added, err := req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if req.name == "hmset" {
+		conn.WriteString("OK")
+		return
+	}
+
+	conn.WriteInt(added.(int))
+}
+
+// hsetnxCommand runs HSETNX.
+func hsetnxCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	set, err := req.Apply(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if !set.(bool) {
+		conn.WriteInt(0)
+		return
+	}
+
+	// Other regions may not have seen the field yet, so the write is replicated unconditionally.
+	message.Command = "hset"
+
+	err = req.Broadcast(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(1)
+}
+
+// hincrbyCommand runs HINCRBY.
+func hincrbyCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	if _, err := strconv.ParseInt(args[2], 10, 64); err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	value, err := req.Apply(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	// Replicate the result rather than the increment, so that the field converges under last-writer-wins.
+	message.Command = "hset"
+	message.Arguments = []string{args[0], args[1], strconv.FormatInt(value.(int64), 10)}
+
+	err = req.Broadcast(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt64(value.(int64))
+}
+
+// hdelCommand runs HDEL.
+func hdelCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	removed, err := req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(removed.(int))
+}
+
+// hgetCommand runs HGET.
+func hgetCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	value, err := database.HGet(req.server.now(), args[0], args[1])
+	if db.IsErrorNotFound(err) {
+		writeNull(conn)
+		return
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteBulkString(value)
+}
+
+// hmgetCommand runs HMGET.
+func hmgetCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	fields, err := database.HGetAll(req.server.now(), args[0])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteArray(len(args) - 1)
+
+	for _, name := range args[1:] {
+		value, ok := fields[name]
+		if !ok {
+			writeNull(conn)
+			continue
+		}
+
+		conn.WriteBulkString(value)
+	}
+}
+
+// hgetallCommand runs HGETALL, HKEYS, HVALS and HLEN.
+func hgetallCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	fields, err := database.HGetAll(req.server.now(), args[0])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+
+	// Sort the fields so that HKEYS and HVALS are returned in a consistent order.
+	sort.Strings(names)
+
+	switch req.name {
+	case "hgetall":
+		writeMap(conn, len(names))
+
+		for _, name := range names {
+			conn.WriteBulkString(name)
+			conn.WriteBulkString(fields[name])
+		}
+
+	case "hkeys":
+		writeStrings(conn, names)
+
+	case "hvals":
+		conn.WriteArray(len(names))
+
+		for _, name := range names {
+			conn.WriteBulkString(fields[name])
+		}
+
+	case "hlen":
+		conn.WriteInt(len(names))
+	}
+}
+
+// hexistsCommand runs HEXISTS.
+func hexistsCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	_, err := database.HGet(req.server.now(), args[0], args[1])
+	if db.IsErrorNotFound(err) {
+		conn.WriteInt(0)
+		return
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(1)
+}
+
+// saddCommand runs SADD.
+func saddCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	added, err := req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(added.(int))
+}
+
+// sremCommand runs SREM.
+func sremCommand(req *Request, conn redcon.Conn, args []string) {
+	removed, err := req.removeMembers(args[0], args[1:])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(removed)
+}
+
+// spopCommand runs SPOP and SRANDMEMBER.
+func spopCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	if len(args) > 2 {
+		conn.WriteError(errWrongArity(req.name).Error())
+		return
+	}
+
+	pop := req.name == "spop"
+	count := 1
+
+	if len(args) == 2 {
+		n, err := parseInt(args[1])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+
+		if pop && n < 0 {
+			conn.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+
+		count = n
+	}
+
+	members, err := database.SMembers(req.server.now(), args[0])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	chosen := make([]string, 0)
+
+	if count < 0 {
+		// A negative count allows the same member to be returned more than once.
+		for i := 0; i < -count && len(members) > 0; i++ {
+			chosen = append(chosen, members[rand.Intn(len(members))])
+		}
+	} else {
+		rand.Shuffle(len(members), func(i, j int) {
+			members[i], members[j] = members[j], members[i]
+		})
+
+		if count > len(members) {
+			count = len(members)
+		}
+
+		chosen = members[:count]
+	}
+
+	if pop && len(chosen) > 0 {
+		_, err := req.removeMembers(args[0], chosen)
+		if err != nil {
+			writeError(conn, err)
+			return
+		}
+	}
+
+	if len(args) == 2 {
+		writeStrings(conn, chosen)
+		return
+	}
+
+	if len(chosen) == 0 {
+		writeNull(conn)
+		return
+	}
+
+	conn.WriteBulkString(chosen[0])
+}
+
+// smembersCommand runs SMEMBERS, SUNION, SINTER and SDIFF.
+func smembersCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	var members []string
+	var err error
+
+	now := req.server.now()
+	keys := args
+
+	switch req.name {
+	case "smembers", "sunion":
+		members, err = database.SUnion(now, keys...)
+	case "sinter":
+		members, err = database.SInter(now, keys...)
+	case "sdiff":
+		members, err = database.SDiff(now, keys...)
+	}
+
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	writeSet(conn, len(members))
+
+	for _, member := range members {
+		conn.WriteBulkString(member)
+	}
+}
+
+// sismemberCommand runs SISMEMBER and SMISMEMBER.
+func sismemberCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	present, err := database.SIsMember(req.server.now(), args[0], args[1:]...)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if req.name == "smismember" {
+		conn.WriteArray(len(present))
+	}
+
+	for _, p := range present {
+		if p {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+	}
+}
+
+// scardCommand runs SCARD.
+func scardCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	count, err := database.SCard(req.server.now(), args[0])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(count)
+}
+
+// zaddCommand runs ZADD.
+func zaddCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	options, ch, _, err := parseZAddArguments(args[1:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	r, err := req.Apply(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	result := r.(*db.ZAddResult)
+
+	err = req.replicateSortedSetMembers(message, args[0], result.Applied)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	switch {
+	case options.Incr && len(result.Applied) == 0:
+		writeNull(conn)
+	case options.Incr:
+		writeDouble(conn, result.Applied[0].Score)
+	case ch:
+		conn.WriteInt(result.Changed)
+	default:
+		conn.WriteInt(result.Added)
+	}
+}
+
+// zincrbyCommand runs ZINCRBY.
+func zincrbyCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	if _, err := parseScore(args[1]); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	score, err := req.Apply(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	err = req.replicateSortedSetMembers(message, args[0], []db.SortedSetMember{{Member: args[2], Score: score.(float64)}})
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	writeDouble(conn, score.(float64))
+}
+
+// zremCommand runs ZREM.
+func zremCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	message := server.NewCommandMessage(
+		client.DB,
+		req.name,
+		args,
+	)
+
+	removed, err := req.Replicate(message)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(removed.(int))
+}
+
+// zpopCommand runs ZPOPMIN and ZPOPMAX.
+func zpopCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	if len(args) > 2 {
+		conn.WriteError(errWrongArity(req.name).Error())
+		return
+	}
+
+	count := 1
+
+	if len(args) == 2 {
+		n, err := parseInt(args[1])
+		if err != nil || n < 0 {
+			conn.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+
+		count = n
+	}
+
+	query := db.ZRangeQuery{
+		Start: 0,
+		Stop:  count - 1,
+		Rev:   req.name == "zpopmax",
+	}
+
+	members, err := database.ZRange(req.server.now(), args[0], query)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if count == 0 {
+		members = members[:0]
+	}
+
+	_, err = req.removeSortedSetMembers(args[0], members)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	writeSortedSetMembers(conn, members, true)
+}
+
+// zremrangeCommand runs ZREMRANGEBYSCORE, ZREMRANGEBYRANK and ZREMRANGEBYLEX.
+func zremrangeCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	// These are equivalent to removing the members of the matching ZRANGE.
+	command := map[string]string{
+		"zremrangebyscore": "zrangebyscore",
+		"zremrangebyrank":  "zrange",
+		"zremrangebylex":   "zrangebylex",
+	}[req.name]
+
+	query, _, err := parseZRange(command, args[1:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	members, err := database.ZRange(req.server.now(), args[0], *query)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	removed, err := req.removeSortedSetMembers(args[0], members)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(removed)
+}
+
+// zrangeCommand runs ZRANGE, ZREVRANGE, ZRANGEBYSCORE, ZREVRANGEBYSCORE, ZRANGEBYLEX and ZREVRANGEBYLEX.
+func zrangeCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	query, withScores, err := parseZRange(req.name, args[1:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	members, err := database.ZRange(req.server.now(), args[0], *query)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	writeSortedSetMembers(conn, members, withScores)
+}
+
+// zcountCommand runs ZCOUNT and ZLEXCOUNT.
+func zcountCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	command := "zrangebyscore"
+	if req.name == "zlexcount" {
+		command = "zrangebylex"
+	}
+
+	query, _, err := parseZRange(command, args[1:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	members, err := database.ZRange(req.server.now(), args[0], *query)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(len(members))
+}
+
+// zscoreCommand runs ZSCORE and ZMSCORE.
+func zscoreCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	scores, err := database.ZScore(req.server.now(), args[0], args[1:]...)
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if req.name == "zmscore" {
+		conn.WriteArray(len(scores))
+	}
+
+	for _, score := range scores {
+		if score == nil {
+			writeNull(conn)
+		} else {
+			writeDouble(conn, *score)
+		}
+	}
+}
+
+// zcardCommand runs ZCARD.
+func zcardCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	size, err := database.ZCard(req.server.now(), args[0])
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteInt(size)
+}
+
+// zrankCommand runs ZRANK and ZREVRANK.
+func zrankCommand(req *Request, conn redcon.Conn, args []string) {
+	database := req.database
+
+	if len(args) > 3 {
+		conn.WriteError(errWrongArity(req.name).Error())
+		return
+	}
+
+	withScore := len(args) == 3
+	if withScore && strings.ToLower(args[2]) != "withscore" {
+		conn.WriteError(errSyntax.Error())
+		return
+	}
+
+	rev := req.name == "zrevrank"
+
+	rank, score, err := database.ZRank(req.server.now(), args[0], args[1], rev)
+	if db.IsErrorNotFound(err) {
+		writeNull(conn)
+		return
+	}
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	if !withScore {
+		conn.WriteInt(rank)
+		return
+	}
+
+	conn.WriteArray(2)
+	conn.WriteInt(rank)
+	writeDouble(conn, score)
+}
+
+// subscribeCommand runs SUBSCRIBE and PSUBSCRIBE.
+func subscribeCommand(req *Request, conn redcon.Conn, args []string) {
+	server, client := req.server, req.client
+
+	server.subscribe(conn, client, req.cmd)
+}
+
+// unsubscribeCommand runs UNSUBSCRIBE and PUNSUBSCRIBE.
+func unsubscribeCommand(req *Request, conn redcon.Conn, args []string) {
+	// The connection is not subscribed to anything, or it would have been detached.
+	command := req.name
+	if len(args) == 0 {
+		writePush(conn, 3)
+		conn.WriteBulkString(command)
+		writeNull(conn)
+		conn.WriteInt(0)
+
+		return
+	}
+
+	for _, name := range args {
+		writePush(conn, 3)
+		conn.WriteBulkString(command)
+		conn.WriteBulkString(name)
+		conn.WriteInt(0)
+	}
+}
+
+// publishCommand runs PUBLISH.
+func publishCommand(req *Request, conn redcon.Conn, args []string) {
+	server := req.server
+
+	conn.WriteInt(server.publish(args[0], args[1]))
+}
+
+// pubsubCommand runs PUBSUB.
+func pubsubCommand(req *Request, conn redcon.Conn, args []string) {
+	server := req.server
+
+	switch strings.ToLower(args[0]) {
+	case "channels":
+		if len(args) > 2 {
+			conn.WriteError("ERR wrong number of arguments for 'pubsub|channels' command")
+			return
+		}
+
+		pattern := ""
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+
+		writeStrings(conn, server.pubsub.Channels(pattern))
+
+	case "numsub":
+		conn.WriteArray(len(args[1:]) * 2)
+
+		for _, channel := range args[1:] {
+			conn.WriteBulkString(channel)
+			conn.WriteInt(server.pubsub.NumSub(channel))
+		}
+
+	case "numpat":
+		conn.WriteInt(server.pubsub.NumPat())
+
+	default:
+		conn.WriteError("ERR unknown subcommand '" + args[0] + "'. Try PUBSUB HELP.")
+	}
+}
+
+// infoCommand runs INFO.
+func infoCommand(req *Request, conn redcon.Conn, args []string) {
+	server := req.server
+
+	info, err := server.info(parseInfoSections(args))
+	if err != nil {
+		writeError(conn, err)
+		return
+	}
+
+	conn.WriteBulkString(info)
+}
+
+// arguments copies the arguments of a command, excluding the command name.
+func arguments(cmd redcon.Command) []string {
+	args := make([]string, len(cmd.Args)-1)
+
+	for i := 1; i < len(cmd.Args); i++ {
+		args[i-1] = string(cmd.Args[i])
+	}
+
+	return args
+}
+
+// removeMembers replicates the removal of members from a set.
+// Only the adds observed on this node are removed, so that concurrent adds in other regions survive.
+// Returns the number of members removed.
+func (req *Request) removeMembers(key string, members []string) (int, error) {
+	observed, err := req.database.SObserve(req.server.now(), key, members...)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(observed) == 0 {
+		return 0, nil
+	}
+
+	message := req.server.NewCommandMessage(req.database.Index(), "srem", append([]string{key}, members...))
+	message.Tags = observed
+
+	removed, err := req.Replicate(message)
+	if err != nil {
+		return 0, err
+	}
+
+	return removed.(int), nil
+}
+
+// writeError writes an error to the client, adding the ERR prefix unless the error already has a Redis error code.
+func writeError(conn redcon.Conn, err error) {
+	if db.IsErrorWrongType(err) {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	conn.WriteError("ERR " + err.Error())
+}
+
+// writeStrings writes a list of strings to the client as an array of bulk strings.
+func writeStrings(conn redcon.Conn, values []string) {
+	conn.WriteArray(len(values))
+
+	for _, value := range values {
+		conn.WriteBulkString(value)
+	}
+}
+
+// parseInt parses an integer argument.
+func parseInt(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errNotInteger
+	}
+
+	return n, nil
+}
+
+// scanOptions contains the options of a SCAN command.
+type scanOptions struct {
+	Match string
+	Count int
+	Type  string
+}
+
+// parseScanOptions parses the options of a SCAN command that follow the cursor.
+func parseScanOptions(args []string) (*scanOptions, error) {
+	options := &scanOptions{Count: 10}
+
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return nil, errSyntax
+		}
+
+		switch strings.ToLower(args[i]) {
+		case "match":
+			options.Match = args[i+1]
+
+		case "count":
+			count, err := parseInt(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+
+			if count < 1 {
+				return nil, errSyntax
+			}
+
+			options.Count = count
+
+		case "type":
+			options.Type = strings.ToLower(args[i+1])
+
+		default:
+			return nil, errSyntax
+		}
+
+		i++
+	}
+
+	return options, nil
+}
+
+// setOptions contains the options of a SET command.
+type setOptions struct {
+	NX      bool
+	XX      bool
+	KeepTTL bool
+	Get     bool
+
+	// ExpiresAt is the absolute deadline in milliseconds, or zero.
+	ExpiresAt db.Time
+}
+
+// parseSetOptions parses the options that follow the key and value of a SET command.
+func parseSetOptions(now time.Time, args []string) (*setOptions, error) {
+	options := &setOptions{}
+	expiry := ""
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+
+		switch option {
+		case "nx":
+			if options.XX {
+				return nil, errSyntax
+			}
+			options.NX = true
+
+		case "xx":
+			if options.NX {
+				return nil, errSyntax
+			}
+			options.XX = true
+
+		case "get":
+			options.Get = true
+
+		case "keepttl":
+			if expiry != "" {
+				return nil, errSyntax
+			}
+			options.KeepTTL = true
+
+		case "ex", "px", "exat", "pxat":
+			if expiry != "" || options.KeepTTL || i+1 >= len(args) {
+				return nil, errSyntax
+			}
+
+			i++
+
+			expiresAt, err := parseExpireTime(now, "set", option, args[i])
+			if err != nil {
+				return nil, err
+			}
+
+			expiry = option
+			options.ExpiresAt = expiresAt
+
+		default:
+			return nil, errSyntax
+		}
+	}
+
+	return options, nil
+}
+
+// parseExpireTime converts the time argument of an expiry option to an absolute deadline in milliseconds.
+// The unit is one of "ex", "px", "exat" or "pxat".
+func parseExpireTime(now time.Time, command string, unit string, value string) (db.Time, error) {
+	invalid := fmt.Errorf("ERR invalid expire time in '%s' command", command)
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+
+	// SET rejects non-positive times, whereas the EXPIRE family deletes the key.
+	if command == "set" && n <= 0 {
+		return 0, invalid
+	}
+
+	if unit == "ex" || unit == "exat" {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, invalid
+		}
+
+		n *= 1000
+	}
+
+	if unit == "ex" || unit == "px" {
+		if n > math.MaxInt64-now.UnixMilli() {
+			return 0, invalid
+		}
+
+		n += now.UnixMilli()
+	}
+
+	return db.Time(n), nil
+}
+
+// expireConditions contains the NX, XX, GT and LT options of the EXPIRE family.
+type expireConditions struct {
+	NX bool
+	XX bool
+	GT bool
+	LT bool
+}
+
+// parseExpireConditions parses the options that follow the time argument of the EXPIRE family.
+func parseExpireConditions(args []string) (*expireConditions, error) {
+	conditions := &expireConditions{}
+
+	for _, arg := range args {
+		switch strings.ToLower(arg) {
+		case "nx":
+			conditions.NX = true
+		case "xx":
+			conditions.XX = true
+		case "gt":
+			conditions.GT = true
+		case "lt":
+			conditions.LT = true
+		default:
+			return nil, fmt.Errorf("ERR Unsupported option %s", arg)
+		}
+	}
+
+	if conditions.NX && (conditions.XX || conditions.GT || conditions.LT) {
+		return nil, errors.New("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+
+	if conditions.GT && conditions.LT {
+		return nil, errors.New("ERR GT and LT options at the same time are not compatible")
+	}
+
+	return conditions, nil
+}
+
+// Allows returns true if a key with the current deadline may be given the new deadline.
+// The current deadline is zero if the key does not expire.
+func (conditions *expireConditions) Allows(current db.Time, expiresAt db.Time) bool {
+	if conditions.NX && current != 0 {
+		return false
+	}
+
+	if conditions.XX && current == 0 {
+		return false
+	}
+
+	// A key without a deadline is treated as having an infinite TTL.
+	if conditions.GT && (current == 0 || expiresAt <= current) {
+		return false
+	}
+
+	if conditions.LT && current != 0 && expiresAt >= current {
+		return false
+	}
+
+	return true
 }
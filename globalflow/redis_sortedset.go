@@ -0,0 +1,322 @@
+package globalflow
+
+import (
+	"errors"
+	"github.com/tidwall/redcon"
+	"globalflow/globalflow/db"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNotFloat      = errors.New("ERR value is not a valid float")
+	errMinMaxFloat   = errors.New("ERR min or max is not a float")
+	errMinMaxLex     = errors.New("ERR min or max not valid string range item")
+	errZAddNXXX      = errors.New("ERR XX and NX options at the same time are not compatible")
+	errZAddGTLTNX    = errors.New("ERR GT, LT, and/or NX options at the same time are not compatible")
+	errZAddIncrPairs = errors.New("ERR INCR option supports a single increment-element pair")
+)
+
+// parseScore parses a sorted set score, which may be infinite but not NaN.
+func parseScore(value string) (float64, error) {
+	score, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, errNotFloat
+	}
+
+	return score, nil
+}
+
+// formatScore formats a sorted set score the way Redis does.
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	}
+
+	if math.IsInf(score, -1) {
+		return "-inf"
+	}
+
+	if score != 0 {
+		exponent := math.Floor(math.Log10(math.Abs(score)))
+
+		if exponent < -4 || exponent >= 17 {
+			return strconv.FormatFloat(score, 'e', -1, 64)
+		}
+	}
+
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// parseScoreBound parses the minimum or maximum of a score range, which is exclusive if prefixed with "(".
+func parseScoreBound(value string) (db.ScoreBound, error) {
+	bound := db.ScoreBound{}
+
+	if strings.HasPrefix(value, "(") {
+		bound.Exclusive = true
+		value = value[1:]
+	}
+
+	score, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(score) {
+		return bound, errMinMaxFloat
+	}
+
+	bound.Score = score
+
+	return bound, nil
+}
+
+// parseLexBound parses the minimum or maximum of a lexicographical range.
+func parseLexBound(value string) (db.LexBound, error) {
+	switch {
+	case value == "-":
+		return db.LexBound{Infinite: -1}, nil
+	case value == "+":
+		return db.LexBound{Infinite: 1}, nil
+	case strings.HasPrefix(value, "["):
+		return db.LexBound{Value: value[1:]}, nil
+	case strings.HasPrefix(value, "("):
+		return db.LexBound{Value: value[1:], Exclusive: true}, nil
+	}
+
+	return db.LexBound{}, errMinMaxLex
+}
+
+// parseZAddArguments parses the arguments of a ZADD command that follow the key.
+// Returns the options, whether CH was given, and the members to add.
+func parseZAddArguments(args []string) (db.ZAddOptions, bool, []db.SortedSetMember, error) {
+	options := db.ZAddOptions{}
+	ch := false
+	i := 0
+
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			options.NX = true
+		case "xx":
+			options.XX = true
+		case "gt":
+			options.GT = true
+		case "lt":
+			options.LT = true
+		case "ch":
+			ch = true
+		case "incr":
+			options.Incr = true
+		default:
+			break options
+		}
+	}
+
+	pairs := args[i:]
+
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return options, ch, nil, errSyntax
+	}
+
+	if options.NX && options.XX {
+		return options, ch, nil, errZAddNXXX
+	}
+
+	if (options.GT && options.LT) || (options.NX && (options.GT || options.LT)) {
+		return options, ch, nil, errZAddGTLTNX
+	}
+
+	if options.Incr && len(pairs) != 2 {
+		return options, ch, nil, errZAddIncrPairs
+	}
+
+	members := make([]db.SortedSetMember, 0, len(pairs)/2)
+
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := parseScore(pairs[j])
+		if err != nil {
+			return options, ch, nil, err
+		}
+
+		members = append(members, db.SortedSetMember{Member: pairs[j+1], Score: score})
+	}
+
+	return options, ch, members, nil
+}
+
+// parseZRange parses the range and options of ZRANGE and its older variants, which follow the key.
+// Returns the query and whether scores should be included in the reply.
+func parseZRange(command string, args []string) (*db.ZRangeQuery, bool, error) {
+	query := &db.ZRangeQuery{Count: -1}
+	withScores := false
+	limit := false
+
+	switch command {
+	case "zrangebyscore":
+		query.By = db.ZRangeByScore
+	case "zrevrangebyscore":
+		query.By = db.ZRangeByScore
+		query.Rev = true
+	case "zrangebylex":
+		query.By = db.ZRangeByLex
+	case "zrevrangebylex":
+		query.By = db.ZRangeByLex
+		query.Rev = true
+	case "zrevrange":
+		query.Rev = true
+	}
+
+	for i := 2; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+
+		switch {
+		case option == "withscores" && command != "zrangebylex" && command != "zrevrangebylex":
+			withScores = true
+
+		case option == "limit" && command != "zrevrange" && i+2 < len(args):
+			offset, err := parseInt(args[i+1])
+			if err != nil {
+				return nil, false, err
+			}
+
+			count, err := parseInt(args[i+2])
+			if err != nil {
+				return nil, false, err
+			}
+
+			query.Offset, query.Count = offset, count
+			limit = true
+			i += 2
+
+		case command == "zrange" && option == "byscore":
+			query.By = db.ZRangeByScore
+
+		case command == "zrange" && option == "bylex":
+			query.By = db.ZRangeByLex
+
+		case command == "zrange" && option == "rev":
+			query.Rev = true
+
+		default:
+			return nil, false, errSyntax
+		}
+	}
+
+	if limit && query.By == db.ZRangeByRank {
+		return nil, false, errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+
+	if withScores && query.By == db.ZRangeByLex {
+		return nil, false, errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	// Reversed score and lexicographical ranges are given from the maximum to the minimum.
+	min, max := args[0], args[1]
+	if query.Rev && query.By != db.ZRangeByRank {
+		min, max = max, min
+	}
+
+	switch query.By {
+	case db.ZRangeByRank:
+		start, err := parseInt(args[0])
+		if err != nil {
+			return nil, false, err
+		}
+
+		stop, err := parseInt(args[1])
+		if err != nil {
+			return nil, false, err
+		}
+
+		query.Start, query.Stop = start, stop
+
+	case db.ZRangeByScore:
+		var err error
+
+		query.Min, err = parseScoreBound(min)
+		if err != nil {
+			return nil, false, err
+		}
+
+		query.Max, err = parseScoreBound(max)
+		if err != nil {
+			return nil, false, err
+		}
+
+	case db.ZRangeByLex:
+		var err error
+
+		query.LexMin, err = parseLexBound(min)
+		if err != nil {
+			return nil, false, err
+		}
+
+		query.LexMax, err = parseLexBound(max)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	return query, withScores, nil
+}
+
+// writeSortedSetMembers writes members of a sorted set to the client, optionally followed by their scores.
+// RESP3 connections receive each member and its score as a pair.
+func writeSortedSetMembers(conn redcon.Conn, members []db.SortedSetMember, withScores bool) {
+	switch {
+	case !withScores:
+		conn.WriteArray(len(members))
+	case resp3(conn):
+		conn.WriteArray(len(members))
+	default:
+		conn.WriteArray(len(members) * 2)
+	}
+
+	for _, m := range members {
+		if withScores && resp3(conn) {
+			conn.WriteArray(2)
+		}
+
+		conn.WriteBulkString(m.Member)
+
+		if withScores {
+			writeDouble(conn, m.Score)
+		}
+	}
+}
+
+// replicateSortedSetMembers broadcasts the resulting scores of a sorted set write as an unconditional ZADD,
+// so that conditional writes and increments converge under last-writer-wins on other nodes.
+func (req *Request) replicateSortedSetMembers(message *CommandMessage, key string, members []db.SortedSetMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	message.Command = "zadd"
+	message.Arguments = []string{key}
+
+	for _, m := range members {
+		message.Arguments = append(message.Arguments, strconv.FormatFloat(m.Score, 'g', -1, 64), m.Member)
+	}
+
+	return req.Broadcast(message)
+}
+
+// removeSortedSetMembers replicates the removal of members from a sorted set.
+// Returns the number of members removed.
+func (req *Request) removeSortedSetMembers(key string, members []db.SortedSetMember) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+
+	args := []string{key}
+	for _, m := range members {
+		args = append(args, m.Member)
+	}
+
+	removed, err := req.Replicate(req.server.NewCommandMessage(req.database.Index(), "zrem", args))
+	if err != nil {
+		return 0, err
+	}
+
+	return removed.(int), nil
+}
@@ -0,0 +1,184 @@
+package globalflow
+
+import (
+	"github.com/tidwall/redcon"
+	"globalflow/globalflow/db"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseSetOptions(t *testing.T) {
+	now := time.UnixMilli(1000000)
+
+	options, err := parseSetOptions(now, []string{"EX", "10", "NX", "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !options.NX || !options.Get || options.XX || options.KeepTTL {
+		t.Errorf("unexpected options %+v", options)
+	}
+
+	if options.ExpiresAt != db.Time(1010000) {
+		t.Errorf("expected deadline 1010000, got %d", options.ExpiresAt)
+	}
+
+	options, err = parseSetOptions(now, []string{"pxat", "2000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.ExpiresAt != db.Time(2000000) {
+		t.Errorf("expected deadline 2000000, got %d", options.ExpiresAt)
+	}
+
+	invalid := [][]string{
+		{"NX", "XX"},
+		{"EX", "10", "PX", "10"},
+		{"EX", "10", "KEEPTTL"},
+		{"EX"},
+		{"EX", "0"},
+		{"FOO"},
+	}
+
+	for _, args := range invalid {
+		if _, err := parseSetOptions(now, args); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+}
+
+func TestExpireConditions_Allows(t *testing.T) {
+	tests := []struct {
+		args      []string
+		current   db.Time
+		expiresAt db.Time
+		allowed   bool
+	}{
+		{[]string{}, 0, 100, true},
+		{[]string{"NX"}, 0, 100, true},
+		{[]string{"NX"}, 50, 100, false},
+		{[]string{"XX"}, 0, 100, false},
+		{[]string{"GT"}, 0, 100, false},
+		{[]string{"GT"}, 50, 100, true},
+		{[]string{"LT"}, 0, 100, true},
+		{[]string{"LT"}, 50, 100, false},
+		{[]string{"XX", "LT"}, 0, 100, false},
+	}
+
+	for _, test := range tests {
+		conditions, err := parseExpireConditions(test.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if conditions.Allows(test.current, test.expiresAt) != test.allowed {
+			t.Errorf("expected %v for %v with current deadline %d", test.allowed, test.args, test.current)
+		}
+	}
+
+	if _, err := parseExpireConditions([]string{"NX", "GT"}); err == nil {
+		t.Error("expected NX and GT to be incompatible")
+	}
+
+	if _, err := parseExpireConditions([]string{"GT", "LT"}); err == nil {
+		t.Error("expected GT and LT to be incompatible")
+	}
+}
+
+func TestParseZRange(t *testing.T) {
+	query, withScores, err := parseZRange("zrange", []string{"(5", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2", "WITHSCORES"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !withScores || !query.Rev || query.By != db.ZRangeByScore || query.Offset != 1 || query.Count != 2 {
+		t.Errorf("unexpected query %+v", query)
+	}
+
+	if !query.Max.Exclusive || query.Max.Score != 5 || !math.IsInf(query.Min.Score, -1) {
+		t.Errorf("expected reversed bounds, got min %+v and max %+v", query.Min, query.Max)
+	}
+
+	if _, _, err := parseZRange("zrange", []string{"0", "1", "LIMIT", "0", "1"}); err == nil {
+		t.Error("expected LIMIT to require BYSCORE or BYLEX")
+	}
+
+	if _, _, err := parseZRange("zrangebylex", []string{"a", "[b"}); err == nil {
+		t.Error("expected an invalid lexicographical bound to be rejected")
+	}
+}
+
+func TestFormatScore(t *testing.T) {
+	for score, expected := range map[float64]string{
+		10:          "10",
+		1.5:         "1.5",
+		-0.25:       "-0.25",
+		1000000:     "1000000",
+		1e20:        "1e+20",
+		math.Inf(1): "inf",
+	} {
+		if formatted := formatScore(score); formatted != expected {
+			t.Errorf("expected %s, got %s", expected, formatted)
+		}
+	}
+}
+
+func TestParseScanOptions(t *testing.T) {
+	options, err := parseScanOptions([]string{"MATCH", "user:*", "COUNT", "100", "TYPE", "HASH"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if options.Match != "user:*" || options.Count != 100 || options.Type != "hash" {
+		t.Errorf("unexpected options %+v", options)
+	}
+
+	invalid := [][]string{
+		{"MATCH"},
+		{"COUNT", "0"},
+		{"COUNT", "x"},
+		{"LIMIT", "10"},
+	}
+
+	for _, args := range invalid {
+		if _, err := parseScanOptions(args); err == nil {
+			t.Errorf("expected %v to be rejected", args)
+		}
+	}
+}
+
+func TestLPopMissingKeyIsNotReplicated(t *testing.T) {
+	server := newTestServer(t, "a")
+	conn := contextConn{replyRecorder: &replyRecorder{}, client: &Client{Protocol: protocolRESP2}}
+
+	for _, test := range []struct {
+		args     []string
+		expected string
+	}{
+		{args: []string{"LPOP", "missing"}, expected: "$-1\r\n"},
+		{args: []string{"RPOP", "missing", "2"}, expected: "*-1\r\n"},
+	} {
+		args := make([][]byte, 0, len(test.args))
+		for _, arg := range test.args {
+			args = append(args, []byte(arg))
+		}
+
+		conn.buf = nil
+		server.Redis(conn, redcon.Command{Args: args})
+
+		if string(conn.buf) != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.args, test.expected, conn.buf)
+		}
+	}
+
+	err := server.WALSince("a", 0, func(sequence uint64, cmd *CommandMessage) error {
+		t.Errorf("expected nothing to be logged, got %s", cmd.Command)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,169 @@
+package globalflow
+
+import (
+	"errors"
+	"github.com/tidwall/redcon"
+	"strconv"
+	"strings"
+)
+
+// Connections speak RESP2 until they switch to RESP3 with HELLO.
+// RESP3 adds reply types such as maps, sets, doubles and nulls, which are written here as raw RESP3 when the connection
+// has opted in, and as their RESP2 equivalents otherwise.
+
+const (
+	protocolRESP2 = 2
+	protocolRESP3 = 3
+)
+
+// resp3 returns true if the connection has switched to RESP3.
+func resp3(conn redcon.Conn) bool {
+	client, ok := conn.Context().(*Client)
+
+	return ok && client.Protocol == protocolRESP3
+}
+
+// writeMap writes the header of a map with the given number of key-value pairs, which follow as alternating replies.
+// RESP2 connections receive a flat array.
+func writeMap(conn redcon.Conn, pairs int) {
+	if resp3(conn) {
+		conn.WriteRaw([]byte("%" + strconv.Itoa(pairs) + "\r\n"))
+		return
+	}
+
+	conn.WriteArray(pairs * 2)
+}
+
+// writeSet writes the header of a set with the given number of members.
+// RESP2 connections receive an array.
+func writeSet(conn redcon.Conn, count int) {
+	if resp3(conn) {
+		conn.WriteRaw([]byte("~" + strconv.Itoa(count) + "\r\n"))
+		return
+	}
+
+	conn.WriteArray(count)
+}
+
+// writePush writes the header of an out-of-band push message with the given number of elements.
+// RESP2 connections receive an array.
+func writePush(conn redcon.Conn, count int) {
+	if resp3(conn) {
+		conn.WriteRaw([]byte(">" + strconv.Itoa(count) + "\r\n"))
+		return
+	}
+
+	conn.WriteArray(count)
+}
+
+// writeDouble writes a floating point number.
+// RESP2 connections receive it as a bulk string.
+func writeDouble(conn redcon.Conn, f float64) {
+	if resp3(conn) {
+		conn.WriteRaw([]byte("," + formatScore(f) + "\r\n"))
+		return
+	}
+
+	conn.WriteBulkString(formatScore(f))
+}
+
+// writeNull writes a missing value.
+// RESP2 connections receive a null bulk string.
+func writeNull(conn redcon.Conn) {
+	if resp3(conn) {
+		conn.WriteRaw([]byte("_\r\n"))
+		return
+	}
+
+	conn.WriteNull()
+}
+
+// writeNullArray writes a missing array, such as the reply to an aborted transaction.
+// RESP2 connections receive a null array.
+func writeNullArray(conn redcon.Conn) {
+	if resp3(conn) {
+		conn.WriteRaw([]byte("_\r\n"))
+		return
+	}
+
+	conn.WriteRaw([]byte("*-1\r\n"))
+}
+
+// redisVersion is the version of Redis reported by HELLO, which some clients use to decide which commands to send.
+const redisVersion = "7.0.0"
+
+var errClientName = errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+
+// helloOptions are the arguments of HELLO.
+type helloOptions struct {
+	// Protocol is the requested protocol version, or 0 to keep the current one.
+	Protocol int
+
+	Auth     bool
+	Username string
+	Password string
+
+	SetName bool
+	Name    string
+}
+
+// parseHelloOptions parses the arguments of HELLO [protover [AUTH username password] [SETNAME clientname]].
+func parseHelloOptions(args []string) (helloOptions, error) {
+	options := helloOptions{}
+
+	if len(args) == 0 {
+		return options, nil
+	}
+
+	protocol, err := strconv.Atoi(args[0])
+	if err != nil {
+		return options, errors.New("ERR Protocol version is not an integer or out of range")
+	}
+
+	if protocol != protocolRESP2 && protocol != protocolRESP3 {
+		return options, errors.New("NOPROTO unsupported protocol version")
+	}
+
+	options.Protocol = protocol
+
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		default:
+			return options, errSyntax
+
+		case "auth":
+			if i+2 >= len(args) {
+				return options, errSyntax
+			}
+
+			options.Auth, options.Username, options.Password = true, args[i+1], args[i+2]
+			i += 2
+
+		case "setname":
+			if i+1 >= len(args) {
+				return options, errSyntax
+			}
+
+			if !validClientName(args[i+1]) {
+				return options, errClientName
+			}
+
+			options.SetName, options.Name = true, args[i+1]
+			i++
+		}
+	}
+
+	return options, nil
+}
+
+// validClientName returns true if a name can be given to a connection.
+// As in Redis, names are limited to printable characters other than spaces.
+func validClientName(name string) bool {
+	for _, c := range name {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
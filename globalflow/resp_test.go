@@ -0,0 +1,70 @@
+package globalflow
+
+import "testing"
+
+// contextConn is a connection with a client that records its replies.
+type contextConn struct {
+	*replyRecorder
+
+	client *Client
+}
+
+func (c contextConn) Context() interface{} { return c.client }
+
+func TestWriteMap(t *testing.T) {
+	for protocol, expected := range map[int]string{
+		protocolRESP2: "*2\r\n$1\r\na\r\n$3\r\n1.5\r\n",
+		protocolRESP3: "%1\r\n$1\r\na\r\n,1.5\r\n",
+	} {
+		conn := contextConn{replyRecorder: &replyRecorder{}, client: &Client{Protocol: protocol}}
+
+		writeMap(conn, 1)
+		conn.WriteBulkString("a")
+		writeDouble(conn, 1.5)
+
+		if string(conn.buf) != expected {
+			t.Errorf("unexpected RESP%d reply %q", protocol, conn.buf)
+		}
+	}
+}
+
+func TestWriteNull(t *testing.T) {
+	for protocol, expected := range map[int]string{
+		protocolRESP2: "$-1\r\n*-1\r\n",
+		protocolRESP3: "_\r\n_\r\n",
+	} {
+		conn := contextConn{replyRecorder: &replyRecorder{}, client: &Client{Protocol: protocol}}
+
+		writeNull(conn)
+		writeNullArray(conn)
+
+		if string(conn.buf) != expected {
+			t.Errorf("unexpected RESP%d reply %q", protocol, conn.buf)
+		}
+	}
+}
+
+func TestParseHelloOptions(t *testing.T) {
+	options, err := parseHelloOptions([]string{"3", "AUTH", "default", "secret", "SETNAME", "worker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := helloOptions{Protocol: 3, Auth: true, Username: "default", Password: "secret", SetName: true, Name: "worker"}
+	if options != expected {
+		t.Errorf("unexpected options %+v", options)
+	}
+
+	for _, args := range [][]string{
+		{"4"},
+		{"three"},
+		{"3", "AUTH", "default"},
+		{"3", "SETNAME", "a b"},
+		{"3", "FOO"},
+	} {
+		_, err := parseHelloOptions(args)
+		if err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
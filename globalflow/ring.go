@@ -27,35 +27,22 @@ func (a ByRingIndex) Len() int           { return len(a) }
 func (a ByRingIndex) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByRingIndex) Less(i, j int) bool { return a[i].RingIndex() < a[j].RingIndex() }
 
+// LocalNodes returns the nodes in the ring of the node's region.
 func (server *Server) LocalNodes() []*Node {
-	nodes := make([]*Node, 0)
-
-	for _, node := range server.Nodes() {
-		if node.NodeID() == server.container.Configuration.NodeID {
-			continue
-		}
-
-		metadata := node.Metadata()
-		if metadata == nil {
-			continue
-		}
-
-		if node.node.State != memberlist.StateAlive {
-			continue
-		}
-
-		if metadata.Region == server.container.Configuration.NodeRegion {
-			nodes = append(nodes, node)
-		}
-	}
-
-	return nodes
+	return server.ringMembers(server.Nodes(), true)
 }
 
+// RemoteNodes returns the nodes in other regions, which form the ring between regions.
 func (server *Server) RemoteNodes() []*Node {
+	return server.ringMembers(server.Nodes(), false)
+}
+
+// ringMembers returns the live members of the cluster, other than the node itself, in the node's region if local is
+// true and in other regions otherwise.
+func (server *Server) ringMembers(members []*Node, local bool) []*Node {
 	nodes := make([]*Node, 0)
 
-	for _, node := range server.Nodes() {
+	for _, node := range members {
 		if node.NodeID() == server.container.Configuration.NodeID {
 			continue
 		}
@@ -65,11 +52,12 @@ func (server *Server) RemoteNodes() []*Node {
 			continue
 		}
 
-		if node.node.State != memberlist.StateAlive {
+		// Nodes that are bootstrapping their data are left out of the rings until they have caught up.
+		if node.node.State != memberlist.StateAlive || metadata.Loading {
 			continue
 		}
 
-		if metadata.Region != server.container.Configuration.NodeRegion {
+		if (metadata.Region == server.container.Configuration.NodeRegion) == local {
 			nodes = append(nodes, node)
 		}
 	}
@@ -79,12 +67,21 @@ func (server *Server) RemoteNodes() []*Node {
 
 // NextLocalNode returns the next node in the ring in the same datacenter.
 func (server *Server) NextLocalNode() *Node {
-	nodes := server.LocalNodes()
+	return nextNode(server.LocalNodes(), server.RingIndex())
+}
 
+// NextRemoteNode returns the next node in the ring in a different datacenter.
+func (server *Server) NextRemoteNode() *Node {
+	return nextNode(server.RemoteNodes(), server.RingIndex())
+}
+
+// nextNode returns the node of a ring that follows a ring index, wrapping around to the first, or nil if the ring is
+// empty. Only the nodes of the ring are considered, so that messages are never routed to a node outside of it.
+func nextNode(nodes []*Node, index uint32) *Node {
 	sort.Sort(ByRingIndex(nodes))
 
-	for _, node := range server.Nodes() {
-		if node.RingIndex() > server.RingIndex() {
+	for _, node := range nodes {
+		if node.RingIndex() > index {
 			return node
 		}
 	}
@@ -96,21 +93,13 @@ func (server *Server) NextLocalNode() *Node {
 	return nil
 }
 
-// NextRemoteNode returns the next node in the ring in a different datacenter.
-func (server *Server) NextRemoteNode() *Node {
-	nodes := server.RemoteNodes()
-
-	sort.Sort(ByRingIndex(nodes))
-
-	for _, node := range server.Nodes() {
-		if node.RingIndex() > server.RingIndex() {
+// Peer returns the live node with an ID, or nil if it is not a member of the cluster.
+func (server *Server) Peer(nodeID string) *Node {
+	for _, node := range append(server.LocalNodes(), server.RemoteNodes()...) {
+		if node.NodeID() == nodeID {
 			return node
 		}
 	}
 
-	if len(nodes) > 0 {
-		return nodes[0]
-	}
-
 	return nil
 }
@@ -0,0 +1,82 @@
+package globalflow
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/memberlist"
+	"sort"
+	"testing"
+)
+
+// newTestNode returns a member of the cluster with gossip metadata.
+func newTestNode(t *testing.T, nodeID string, state memberlist.NodeStateType, metadata GossipMetadata) *Node {
+	meta, err := json.Marshal(metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewNode(&memberlist.Node{Name: nodeID, State: state, Meta: meta})
+}
+
+func TestServer_RingMembers(t *testing.T) {
+	server := newTestServer(t, "a")
+	server.container.Configuration.NodeRegion = "eu"
+
+	members := []*Node{
+		newTestNode(t, "a", memberlist.StateAlive, GossipMetadata{Region: "eu"}),
+		newTestNode(t, "b", memberlist.StateAlive, GossipMetadata{Region: "eu"}),
+		newTestNode(t, "c", memberlist.StateAlive, GossipMetadata{Region: "eu", Loading: true}),
+		newTestNode(t, "d", memberlist.StateDead, GossipMetadata{Region: "eu"}),
+		newTestNode(t, "e", memberlist.StateAlive, GossipMetadata{Region: "us"}),
+		newTestNode(t, "f", memberlist.StateAlive, GossipMetadata{Region: "us", Loading: true}),
+	}
+
+	// The node itself, bootstrapping nodes and failed nodes are left out of the rings.
+	local := server.ringMembers(members, true)
+	if len(local) != 1 || local[0].NodeID() != "b" {
+		t.Errorf("expected the local ring to be [b], got %v", nodeIDs(local))
+	}
+
+	remote := server.ringMembers(members, false)
+	if len(remote) != 1 || remote[0].NodeID() != "e" {
+		t.Errorf("expected the remote ring to be [e], got %v", nodeIDs(remote))
+	}
+
+	// Whatever the ring indexes of the other nodes, the next node is the only one in the ring.
+	if next := nextNode(local, server.RingIndex()); next == nil || next.NodeID() != "b" {
+		t.Errorf("expected the next local node to be b, got %v", next)
+	}
+}
+
+func TestNextNode(t *testing.T) {
+	nodes := []*Node{
+		NewNode(&memberlist.Node{Name: "a"}),
+		NewNode(&memberlist.Node{Name: "b"}),
+		NewNode(&memberlist.Node{Name: "c"}),
+	}
+
+	sorted := append([]*Node{}, nodes...)
+	sort.Sort(ByRingIndex(sorted))
+
+	// Each node is followed by the next in ring order, and the last by the first.
+	for i, node := range sorted {
+		expected := sorted[(i+1)%len(sorted)]
+
+		if next := nextNode(nodes, node.RingIndex()); next != expected {
+			t.Errorf("expected %s to be followed by %s, got %s", node.NodeID(), expected.NodeID(), next.NodeID())
+		}
+	}
+
+	if next := nextNode(nil, 0); next != nil {
+		t.Errorf("expected no next node in an empty ring, got %s", next.NodeID())
+	}
+}
+
+// nodeIDs returns the IDs of nodes.
+func nodeIDs(nodes []*Node) []string {
+	ids := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		ids = append(ids, node.NodeID())
+	}
+
+	return ids
+}
@@ -0,0 +1,55 @@
+package globalflow
+
+import "sync"
+
+// maxScanCursors is the number of SCAN cursors that are remembered.
+// Once exceeded, the oldest cursors are forgotten and can no longer be resumed.
+const maxScanCursors = 10000
+
+// scanCursors maps the integer cursors returned by SCAN to the key that the scan should continue after.
+// Redis clients expect cursors to be integers, whereas the keyspace is iterated in key order.
+type scanCursors struct {
+	mutex   sync.Mutex
+	last    uint64
+	keys    map[uint64]string
+	cursors []uint64
+}
+
+func newScanCursors() *scanCursors {
+	return &scanCursors{
+		keys: make(map[uint64]string),
+	}
+}
+
+// Save returns a new cursor that continues after the given key.
+func (c *scanCursors) Save(after string) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.cursors) >= maxScanCursors {
+		delete(c.keys, c.cursors[0])
+		c.cursors = c.cursors[1:]
+	}
+
+	c.last++
+
+	c.keys[c.last] = after
+	c.cursors = append(c.cursors, c.last)
+
+	return c.last
+}
+
+// Load returns the key that a cursor continues after.
+// Cursor 0 starts a new scan. Returns false if the cursor is unknown.
+func (c *scanCursors) Load(cursor uint64) (string, bool) {
+	if cursor == 0 {
+		return "", true
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	after, ok := c.keys[cursor]
+
+	return after, ok
+}
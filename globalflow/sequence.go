@@ -0,0 +1,409 @@
+package globalflow
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Every command that a node originates is given the next sequence number of that node when it is recorded in the
+// node's write-ahead log. Receivers track the sequence numbers they have applied for each origin, which lets them drop
+// commands that reach them more than once, and notice the commands that never reached them: a command that arrives
+// ahead of its predecessors leaves a gap, which is re-requested from another node's log if it is not filled in time.
+
+// resendDelay is how long a gap is left to be filled by commands that are only late, before the missing commands are
+// requested again.
+const resendDelay = time.Second
+
+// resendInterval is how long to wait for a request for missing commands to be answered before requesting them again.
+const resendInterval = 5 * time.Second
+
+// resendLimit is the most commands resent in answer to a single request. The requester requests the rest again once
+// the first ones have filled its gap.
+const resendLimit = 1000
+
+// errResendComplete stops iterating over the write-ahead log once the requested commands have been resent.
+var errResendComplete = errors.New("resend complete")
+
+// originSequence is the progress of the commands received from one origin.
+type originSequence struct {
+	// contiguous is the sequence number up to which every command has been received.
+	contiguous uint64
+
+	// received contains the sequence numbers above contiguous that have been received.
+	received map[uint64]bool
+
+	// highest is the highest sequence number received.
+	highest uint64
+
+	// gapSince is when commands were last found missing below highest, or the zero time if there is no gap.
+	gapSince time.Time
+
+	// requested is when the missing commands were last requested, and attempts is how many times they have been.
+	requested time.Time
+	attempts  int
+
+	// reported is true once the gap has been logged as outstanding.
+	reported bool
+}
+
+// missing returns the number of commands below the highest received that have not been received.
+func (o *originSequence) missing() int {
+	if o.highest <= o.contiguous {
+		return 0
+	}
+
+	return int(o.highest-o.contiguous) - len(o.received)
+}
+
+// sequenceGap describes the commands missing from one origin.
+type sequenceGap struct {
+	Origin string
+
+	// From and To are the first and last sequence numbers that may be missing.
+	From uint64
+	To   uint64
+
+	// Missing is the number of commands missing between From and To.
+	Missing int
+
+	// Since is when the commands were found missing.
+	Since time.Time
+
+	// Attempts is the number of times the missing commands have been requested.
+	Attempts int
+}
+
+// sequences tracks the commands received from every origin.
+type sequences struct {
+	mutex sync.Mutex
+
+	origins map[string]*originSequence
+}
+
+func newSequences() *sequences {
+	return &sequences{
+		origins: make(map[string]*originSequence),
+	}
+}
+
+// Restore sets the sequence number up to which every command of each origin has been received, such as the positions
+// of the write-ahead log when the node starts.
+func (s *sequences) Restore(positions map[string]uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for origin, position := range positions {
+		s.origins[origin] = &originSequence{
+			contiguous: position,
+			highest:    position,
+			received:   make(map[uint64]bool),
+		}
+	}
+}
+
+// Receive records that a command of an origin has been received.
+// Returns false if it had already been received, in which case it should be ignored.
+// The first command received from an unknown origin is taken as the start of its sequence, as the node has no record
+// of the commands before it.
+func (s *sequences) Receive(origin string, sequence uint64, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	o, ok := s.origins[origin]
+	if !ok {
+		o = &originSequence{
+			contiguous: sequence - 1,
+			highest:    sequence - 1,
+			received:   make(map[uint64]bool),
+		}
+
+		s.origins[origin] = o
+	}
+
+	if sequence <= o.contiguous || o.received[sequence] {
+		return false
+	}
+
+	o.received[sequence] = true
+
+	if sequence > o.highest {
+		o.highest = sequence
+	}
+
+	advanced := false
+
+	for o.received[o.contiguous+1] {
+		delete(o.received, o.contiguous+1)
+		o.contiguous++
+
+		advanced = true
+	}
+
+	switch {
+	case o.contiguous == o.highest:
+		if o.reported {
+			logrus.WithField("origin", origin).WithField("sequence", o.contiguous).Info("Replication gap filled")
+		}
+
+		o.gapSince, o.requested, o.attempts, o.reported = time.Time{}, time.Time{}, 0, false
+
+	case o.gapSince.IsZero() || advanced:
+		// The gap has just opened, or the earliest missing commands have arrived and the next ones are missing.
+		o.gapSince, o.requested, o.attempts = now, time.Time{}, 0
+	}
+
+	return true
+}
+
+// Skip gives up on the commands missing from an origin up to a sequence number, so that they are no longer requested.
+func (s *sequences) Skip(origin string, to uint64, now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	o, ok := s.origins[origin]
+	if !ok || to <= o.contiguous {
+		return
+	}
+
+	for sequence := range o.received {
+		if sequence <= to {
+			delete(o.received, sequence)
+		}
+	}
+
+	o.contiguous = to
+
+	for o.received[o.contiguous+1] {
+		delete(o.received, o.contiguous+1)
+		o.contiguous++
+	}
+
+	if o.contiguous >= o.highest {
+		o.contiguous = o.highest
+		o.gapSince, o.requested, o.attempts, o.reported = time.Time{}, time.Time{}, 0, false
+	} else {
+		o.gapSince, o.requested, o.attempts, o.reported = now, time.Time{}, 0, false
+	}
+}
+
+// Position returns the sequence number up to which every command of an origin has been received, and the number of
+// commands missing above it.
+func (s *sequences) Position(origin string) (uint64, int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	o, ok := s.origins[origin]
+	if !ok {
+		return 0, 0
+	}
+
+	return o.contiguous, o.missing()
+}
+
+// Due returns the gaps whose missing commands should be requested, and records that they have been.
+// A gap is due once it has been open for the resend delay, and again every resend interval until it is filled.
+func (s *sequences) Due(now time.Time) []sequenceGap {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	gaps := make([]sequenceGap, 0)
+
+	for origin, o := range s.origins {
+		if o.gapSince.IsZero() || now.Sub(o.gapSince) < resendDelay || now.Sub(o.requested) < resendInterval {
+			continue
+		}
+
+		o.requested = now
+		o.attempts++
+
+		gaps = append(gaps, o.gap(origin))
+	}
+
+	sort.Slice(gaps, func(i, j int) bool {
+		return gaps[i].Origin < gaps[j].Origin
+	})
+
+	return gaps
+}
+
+// Outstanding returns the gaps that have been open for longer than a threshold, in order of origin.
+func (s *sequences) Outstanding(now time.Time, threshold time.Duration) []sequenceGap {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	gaps := make([]sequenceGap, 0)
+
+	for origin, o := range s.origins {
+		if !o.gapSince.IsZero() && now.Sub(o.gapSince) >= threshold {
+			gaps = append(gaps, o.gap(origin))
+		}
+	}
+
+	sort.Slice(gaps, func(i, j int) bool {
+		return gaps[i].Origin < gaps[j].Origin
+	})
+
+	return gaps
+}
+
+// Report records that the gap of an origin has been logged as outstanding.
+// Returns false if it already had been.
+func (s *sequences) Report(origin string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	o, ok := s.origins[origin]
+	if !ok || o.reported {
+		return false
+	}
+
+	o.reported = true
+
+	return true
+}
+
+// gap describes the commands missing from the origin.
+func (o *originSequence) gap(origin string) sequenceGap {
+	return sequenceGap{
+		Origin:   origin,
+		From:     o.contiguous + 1,
+		To:       o.highest - 1,
+		Missing:  o.missing(),
+		Since:    o.gapSince,
+		Attempts: o.attempts,
+	}
+}
+
+// RequestMissingCommands runs until shutdown, periodically requesting the commands missing from each origin and logging
+// the gaps that remain open for too long.
+func (server *Server) RequestMissingCommands() {
+	ticker := time.NewTicker(resendDelay)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.shutdownCh:
+			return
+
+		case <-ticker.C:
+			if !server.loading.Load() {
+				server.requestMissingCommands(time.Now())
+			}
+		}
+	}
+}
+
+// requestMissingCommands requests the commands missing from each origin whose gap is due.
+// Gaps older than the retention of the write-ahead log are given up on, as no node can still have their commands.
+func (server *Server) requestMissingCommands(now time.Time) {
+	configuration := server.container.Configuration
+
+	for _, gap := range server.sequences.Outstanding(now, configuration.ReplicationGapThreshold) {
+		log := logrus.WithField("origin", gap.Origin).WithField("from", gap.From).WithField("to", gap.To).
+			WithField("missing", gap.Missing).WithField("attempts", gap.Attempts)
+
+		if configuration.WALRetention > 0 && now.Sub(gap.Since) > configuration.WALRetention {
+			log.Error("Giving up on missing commands older than the write-ahead log retention")
+
+			server.sequences.Skip(gap.Origin, gap.To, now)
+
+			continue
+		}
+
+		if server.sequences.Report(gap.Origin) {
+			log.Warn("Replication gap outstanding")
+		}
+	}
+
+	for _, gap := range server.sequences.Due(now) {
+		target := server.resendTarget(gap.Origin, gap.Attempts)
+		if target == nil {
+			continue
+		}
+
+		message := &ResendMessage{
+			Origin:    gap.Origin,
+			From:      gap.From,
+			To:        gap.To,
+			Requester: server.container.Configuration.NodeID,
+		}
+
+		err := server.sendMessage(target, message)
+		if err != nil {
+			logrus.WithError(err).WithField("node", target.NodeID()).Warn("failed to request missing commands")
+		}
+	}
+}
+
+// resendTarget returns the node to request the missing commands of an origin from, or nil if no node is available.
+// Requests alternate between the node's neighbours in the rings, which have received the same commands, and the origin,
+// which recorded every command it originated.
+func (server *Server) resendTarget(origin string, attempt int) *Node {
+	candidates := make([]*Node, 0, 3)
+
+	for _, node := range []*Node{server.NextLocalNode(), server.NextRemoteNode()} {
+		if node != nil && node.NodeID() != origin {
+			candidates = append(candidates, node)
+		}
+	}
+
+	for _, node := range append(server.LocalNodes(), server.RemoteNodes()...) {
+		if node.NodeID() == origin {
+			candidates = append(candidates, node)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return candidates[(attempt-1)%len(candidates)]
+}
+
+// handleResend resends the commands that another node is missing from this node's write-ahead log.
+// The commands are only applied by the requester, which requests any that this node does not have from another node.
+// They are read from the log before any is sent, so that a slow requester does not hold the read transaction open.
+func (server *Server) handleResend(message *ResendMessage) {
+	requester := server.Peer(message.Requester)
+	if requester == nil {
+		logrus.WithField("node", message.Requester).Debug("cannot resend commands to an unknown node")
+		return
+	}
+
+	commands := make([]*CommandMessage, 0)
+
+	err := server.WALSince(message.Origin, message.From-1, func(sequence uint64, cmd *CommandMessage) error {
+		if sequence > message.To || len(commands) == resendLimit {
+			return errResendComplete
+		}
+
+		cmd.Sequence = sequence
+		cmd.TTL = 1
+
+		commands = append(commands, cmd)
+
+		return nil
+	})
+	if err != nil && err != errResendComplete {
+		logrus.WithError(err).WithField("node", message.Requester).Warn("failed to read commands to resend")
+		return
+	}
+
+	resent := 0
+
+	for _, cmd := range commands {
+		err := server.sendMessage(requester, cmd)
+		if err != nil {
+			logrus.WithError(err).WithField("node", message.Requester).Warn("failed to resend commands")
+			break
+		}
+
+		resent++
+	}
+
+	logrus.WithField("node", message.Requester).WithField("origin", message.Origin).WithField("commands", resent).
+		Debug("Resent missing commands")
+}
@@ -0,0 +1,95 @@
+package globalflow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSequences_Receive(t *testing.T) {
+	s := newSequences()
+	now := time.Unix(1000, 0)
+
+	s.Restore(map[string]uint64{"a": 3})
+
+	for _, sequence := range []uint64{4, 6, 8} {
+		if !s.Receive("a", sequence, now) {
+			t.Fatalf("expected %d to be new", sequence)
+		}
+	}
+
+	// Commands that reach the node again are ignored, whether or not they are below the gap.
+	if s.Receive("a", 6, now) || s.Receive("a", 2, now) {
+		t.Error("expected duplicates to be ignored")
+	}
+
+	if position, missing := s.Position("a"); position != 4 || missing != 2 {
+		t.Errorf("expected position 4 with 2 missing, got %d with %d", position, missing)
+	}
+
+	if gaps := s.Due(now); len(gaps) != 0 {
+		t.Errorf("expected no requests before the resend delay, got %+v", gaps)
+	}
+
+	gaps := s.Due(now.Add(resendDelay))
+	if len(gaps) != 1 || gaps[0].From != 5 || gaps[0].To != 7 || gaps[0].Missing != 2 || gaps[0].Attempts != 1 {
+		t.Fatalf("unexpected gaps %+v", gaps)
+	}
+
+	if gaps := s.Due(now.Add(resendDelay + time.Second)); len(gaps) != 0 {
+		t.Errorf("expected no requests before the resend interval, got %+v", gaps)
+	}
+
+	if gaps := s.Outstanding(now.Add(time.Minute), time.Minute); len(gaps) != 1 {
+		t.Errorf("expected an outstanding gap, got %+v", gaps)
+	}
+
+	if !s.Report("a") || s.Report("a") {
+		t.Error("expected the gap to be reported once")
+	}
+
+	s.Receive("a", 5, now)
+	s.Receive("a", 7, now)
+
+	if position, missing := s.Position("a"); position != 8 || missing != 0 {
+		t.Errorf("expected position 8 with none missing, got %d with %d", position, missing)
+	}
+
+	if gaps := s.Outstanding(now.Add(time.Hour), time.Minute); len(gaps) != 0 {
+		t.Errorf("expected no outstanding gaps, got %+v", gaps)
+	}
+}
+
+func TestSequences_ReceiveUnknownOrigin(t *testing.T) {
+	s := newSequences()
+	now := time.Unix(1000, 0)
+
+	// The node has no record of the commands before the first one it receives.
+	if !s.Receive("b", 100, now) {
+		t.Fatal("expected the first command to be new")
+	}
+
+	if position, missing := s.Position("b"); position != 100 || missing != 0 {
+		t.Errorf("expected position 100 with none missing, got %d with %d", position, missing)
+	}
+}
+
+func TestSequences_Skip(t *testing.T) {
+	s := newSequences()
+	now := time.Unix(1000, 0)
+
+	for _, sequence := range []uint64{1, 3, 5} {
+		s.Receive("a", sequence, now)
+	}
+
+	s.Skip("a", 2, now)
+
+	if position, missing := s.Position("a"); position != 3 || missing != 1 {
+		t.Errorf("expected position 3 with 1 missing, got %d with %d", position, missing)
+	}
+
+	s.Skip("a", 4, now)
+
+	if position, missing := s.Position("a"); position != 5 || missing != 0 {
+		t.Errorf("expected position 5 with none missing, got %d with %d", position, missing)
+	}
+}
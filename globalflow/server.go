@@ -2,6 +2,8 @@ package globalflow
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/tidwall/redcon"
@@ -10,8 +12,12 @@ import (
 	"net"
 	"net/http"
 	"nhooyr.io/websocket"
+	"os"
 	"path"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -36,8 +42,8 @@ type Server struct {
 	// db is the database.
 	db *db.Database
 
-	// clock contains a Lamport clock.
-	clock *LamportCLock
+	// clock contains a hybrid logical clock, which stamps messages and against which keys expire.
+	clock *HybridClock
 
 	// gossip contains the Gossip protocol implementation
 	gossip *gossip.Gossip
@@ -47,6 +53,68 @@ type Server struct {
 
 	// httpServer is the http server.
 	httpServer *http.Server
+
+	// expiredKeys is the number of expired keys reclaimed by the sweeper.
+	expiredKeys atomic.Uint64
+
+	// sweepIndex is the logical database that the next sweep of expired keys starts from. It is only used by the
+	// sweeper.
+	sweepIndex int
+
+	// tombstonesPurged is the number of tombstones of deleted keys purged since the server started.
+	tombstonesPurged atomic.Uint64
+
+	// scanCursors contains the cursors of SCAN commands in progress.
+	scanCursors *scanCursors
+
+	// pubsub contains the Pub/Sub subscriptions of connections to this node.
+	pubsub *pubSub
+
+	// watches contains the keys watched by clients for transactions.
+	watches *watches
+
+	// acl contains the users that Redis connections authenticate as.
+	acl *acl
+
+	// tracking contains the keys tracked for client-side caching.
+	tracking *tracking
+
+	// nodeTLS contains the certificates that nodes use to authenticate each other, or is nil if nodes connect to each
+	// other without TLS.
+	nodeTLS *nodeTLS
+
+	// clientIDs is the ID of the most recent Redis connection.
+	clientIDs atomic.Int64
+
+	// startedAt is when the server started.
+	startedAt time.Time
+
+	// connectedClients is the number of open Redis connections.
+	connectedClients atomic.Int64
+
+	// totalConnections is the number of Redis connections accepted since the server started.
+	totalConnections atomic.Uint64
+
+	// commandsProcessed is the number of Redis commands run since the server started.
+	commandsProcessed atomic.Uint64
+
+	// replication contains the replication state of the peers that commands have been received from.
+	replication *replicationStats
+
+	// sequences contains the sequence numbers of the commands received from each origin.
+	sequences *sequences
+
+	// antiEntropy contains the state of the rounds of anti-entropy in progress and their statistics.
+	antiEntropy *antiEntropy
+
+	// hints contains the state of the replay of messages held for nodes they could not be sent to, and its statistics.
+	hints *hints
+
+	// loading is true while the node is bootstrapping its data from a peer.
+	loading atomic.Bool
+
+	// clocks contains the skew of the node's clock from the cluster's.
+	clocks clockSkew
 }
 
 // Channels contains channels for communicating with other nodes.
@@ -54,6 +122,15 @@ type Channels struct{}
 
 const NodeNameHTTPHeader = "X-Node-Name"
 
+// websocketPortOffset is the offset of the port that nodes accept websocket connections on from their gossip port.
+const websocketPortOffset = 10
+
+// maxMessageSize is the largest message that nodes accept over a websocket.
+const maxMessageSize = 64 << 20
+
+// errFlushed is returned when a command is discarded because it was made before the database was last flushed.
+var errFlushed = errors.New("write was discarded by a concurrent flush")
+
 // NewServer creates a new server.
 func NewServer(container *Container) *Server {
 	return &Server{
@@ -61,14 +138,36 @@ func NewServer(container *Container) *Server {
 		sockets:       make(map[string]*websocket.Conn),
 		socketMutexes: make(map[string]*sync.Mutex),
 		channels:      Channels{},
-		clock:         NewClock(),
+		clock:         NewClock(container.Configuration.ClockMaxOffset),
+		shutdownCh:    make(chan struct{}),
+		scanCursors:   newScanCursors(),
+		pubsub:        newPubSub(),
+		watches:       newWatches(),
+		tracking:      newTracking(),
+		acl:           newACL(container.Configuration.RedisPassword),
+		replication:   newReplicationStats(),
+		sequences:     newSequences(),
+		antiEntropy:   newAntiEntropy(),
+		hints:         newHints(),
+		startedAt:     time.Now(),
 	}
 }
 
 // Run runs the server until terminated.
 func (server *Server) Run(ctx context.Context) error {
+	err := server.container.Configuration.Validate()
+	if err != nil {
+		return err
+	}
+
 	dbPath := path.Join(server.container.Configuration.DatabasePath, fmt.Sprintf("%s.db", server.container.Configuration.NodeID))
 
+	// A node that starts without a database file bootstraps its data from a peer, unless it is the only node.
+	_, err = os.Stat(dbPath)
+	bootstrap := os.IsNotExist(err) && len(server.container.Configuration.NodePeers) > 0
+
+	server.loading.Store(bootstrap)
+
 	db, err := db.NewDatabase(dbPath)
 	if err != nil {
 		return err
@@ -76,32 +175,92 @@ func (server *Server) Run(ctx context.Context) error {
 
 	server.db = db
 
+	err = server.acl.Load(db)
+	if err != nil {
+		return err
+	}
+
+	positions, err := db.WALPositions()
+	if err != nil {
+		return err
+	}
+
+	server.sequences.Restore(positions)
+
+	server.nodeTLS, err = newNodeTLS(server.container.Configuration)
+	if err != nil {
+		return err
+	}
+
+	// Peers only serve their data to nodes that authenticate, so without TLS or a gossip key the node starts with an
+	// empty database, which anti-entropy repairs.
+	if bootstrap && !server.nodesAuthenticated() {
+		logrus.Warn("Cannot bootstrap without node TLS or a gossip key, starting with an empty database")
+
+		bootstrap = false
+		server.loading.Store(false)
+	}
+
+	redisTLS, err := redisTLSConfig(server.container.Configuration)
+	if err != nil {
+		return err
+	}
+
+	go server.SweepExpiredKeys()
+	go server.TrimWAL()
+	go server.RequestMissingCommands()
+	go server.AntiEntropy()
+
 	err = server.StartGossip()
 	if err != nil {
 		return err
 	}
 
+	go server.ReplayHints()
+	go server.CollectTombstones()
+	go server.MonitorClocks()
+
 	go func() {
-		err := redcon.ListenAndServe(
-			fmt.Sprintf(":%d", server.container.Configuration.RedisPort),
-			server.Redis,
-			func(conn redcon.Conn) bool {
-				logrus.Debugf("Accepted connection from %s", conn.RemoteAddr())
-
-				return true
-			},
-			func(conn redcon.Conn, err error) {},
-		)
+		addr := fmt.Sprintf(":%d", server.container.Configuration.RedisPort)
+
+		accept := func(conn redcon.Conn) bool {
+			logrus.Debugf("Accepted connection from %s", conn.RemoteAddr())
+
+			server.client(conn)
+			server.totalConnections.Add(1)
+
+			return true
+		}
+
+		closed := func(conn redcon.Conn, err error) {
+			server.closeClient(conn)
+		}
+
+		var err error
+		if redisTLS != nil {
+			err = redcon.ListenAndServeTLS(addr, server.Redis, accept, closed, redisTLS)
+		} else {
+			err = redcon.ListenAndServe(addr, server.Redis, accept, closed)
+		}
+
 		if err != nil {
 			logrus.WithError(err).Error("failed to start redis server")
 		}
 	}()
 
-	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", server.container.Configuration.NodePort+10))
+	if bootstrap {
+		go server.Bootstrap()
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", server.container.Configuration.NodePort+websocketPortOffset))
 	if err != nil {
 		return err
 	}
 
+	if server.nodeTLS != nil {
+		l = tls.NewListener(l, server.nodeTLS.ServerConfig())
+	}
+
 	logrus.Infof("Listening on %s", l.Addr().String())
 
 	s := &http.Server{
@@ -118,6 +277,8 @@ func (server *Server) Run(ctx context.Context) error {
 func (server *Server) Close() error {
 	logrus.Info("Shutting down")
 
+	close(server.shutdownCh)
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 	if server.httpServer != nil {
@@ -152,6 +313,41 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err := server.authenticateNode(r)
+	if err != nil {
+		logrus.WithError(err).WithField("addr", r.RemoteAddr).Warn("rejected websocket connection")
+
+		w.WriteHeader(http.StatusForbidden)
+
+		return
+	}
+
+	switch r.URL.Path {
+	case snapshotPath, walPath, walEntriesPath:
+		// The database, including the password hashes of the ACL, is only served to nodes that have authenticated.
+		if !server.nodesAuthenticated() {
+			logrus.WithField("addr", r.RemoteAddr).Warn("rejected bootstrap request without node TLS or a gossip key")
+
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+	}
+
+	switch r.URL.Path {
+	case snapshotPath:
+		server.serveSnapshot(w, r)
+		return
+
+	case walPath:
+		server.serveWALPositions(w, r)
+		return
+
+	case walEntriesPath:
+		server.serveWALEntries(w, r)
+		return
+	}
+
 	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: []string{"default", "stream", "packet"}})
 	if err != nil {
 		logrus.WithError(err).Error("failed to accept websocket")
@@ -165,6 +361,8 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (server *Server) readSocket(c *websocket.Conn) {
+	c.SetReadLimit(maxMessageSize)
+
 	for {
 		t, msg, err := c.Read(context.Background())
 		if err != nil {
@@ -185,6 +383,15 @@ func (server *Server) readSocket(c *websocket.Conn) {
 			case CommandMessage:
 				server.handleCommand(&v)
 
+			case PublishMessage:
+				server.handlePublish(&v)
+
+			case ResendMessage:
+				go server.handleResend(&v)
+
+			case SyncMessage:
+				go server.handleSync(&v)
+
 			default:
 				logrus.Warnf("Unknown message type: %T", v)
 			}
@@ -192,10 +399,39 @@ func (server *Server) readSocket(c *websocket.Conn) {
 	}
 }
 
+// now returns the physical time of the node's clock, in Unix milliseconds, against which keys expire. It is the wall
+// clock time, unless the node has received a message stamped later by a node whose clock is ahead, so that keys do not
+// outlive deadlines that the nodes it has heard from have already passed.
+func (server *Server) now() db.Time {
+	return db.Time(server.clock.Current().Millis())
+}
+
 func (server *Server) handleCommand(cmd *CommandMessage) {
+	// A bootstrapping node is out of the rings, and downloads the commands it would otherwise receive from a peer.
+	if server.loading.Load() {
+		return
+	}
+
 	server.clock.Set(cmd.Time)
 
-	server.processCommand(cmd)
+	// Commands come back to the node that originated them around the rings, and have already been applied there.
+	if cmd.Originator == server.container.Configuration.NodeID {
+		return
+	}
+
+	// A command that reaches the node along more than one path is only applied and forwarded the first time.
+	if cmd.Sequence != 0 && !server.sequences.Receive(cmd.Originator, cmd.Sequence, time.Now()) {
+		return
+	}
+
+	server.replication.Received(cmd, time.Now())
+
+	_, err := server.processCommand(cmd)
+	if err == errFlushed {
+		logrus.WithField("command", cmd.Command).Debug("discarded command made before a flush")
+	} else if err != nil {
+		logrus.WithError(err).WithField("command", cmd.Command).Warn("failed to apply command")
+	}
 
 	cmd.DecrementTTL()
 
@@ -207,26 +443,293 @@ func (server *Server) handleCommand(cmd *CommandMessage) {
 	}
 }
 
-func (server *Server) processCommand(cmd *CommandMessage) {
-	// TODO: Write to log
-
-	switch cmd.Command {
-	case "set":
-		err := server.db.Set(time.Now(), cmd.Arguments[0], cmd.Arguments[1], 0)
+// flushAll flushes every logical database.
+func (server *Server) flushAll(root *db.Database, version db.Version) error {
+	for index := 0; index < db.Databases; index++ {
+		database, err := root.Select(index)
+		if err != nil {
+			return err
+		}
 
+		_, err = database.Flush(version)
 		if err != nil {
-			logrus.WithError(err).Warn("failed to set key")
+			return err
 		}
+	}
+
+	return nil
+}
+
+// processCommand applies a command to the local database and records it in the write-ahead log.
+// Returns the result of the command, which is used to reply to the client on the originating node.
+func (server *Server) processCommand(cmd *CommandMessage) (interface{}, error) {
+	result, err := server.commitCommand(server.db, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	server.invalidate(cmd, nil)
+
+	return result, nil
+}
+
+// applyCommand applies a command to a database handle, which may be bound to a transaction.
+func (server *Server) applyCommand(root *db.Database, cmd *CommandMessage) (interface{}, error) {
+	command := lookupCommand(cmd.Command)
+	if command == nil || (command.Apply == nil && command.applyGlobal == nil) {
+		return nil, fmt.Errorf("unknown command: %s", cmd.Command)
+	}
+
+	if command.applyGlobal != nil {
+		return command.applyGlobal(server, root, cmd)
+	}
+
+	database, err := root.Select(cmd.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	// Writes that were made before the most recent flush of the database are discarded, so that a write still in
+	// flight when the flush is applied does not survive on the nodes it reaches afterwards.
+	flushed, err := database.FlushVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if !cmd.Version().After(flushed) {
+		return nil, errFlushed
+	}
+
+	// Watching clients are notified before the write is applied, so that a transaction that checks its watched keys
+	// after the write has been committed always sees them as modified.
+	server.watches.Touch(cmd.DB, commandKeys(cmd)...)
 
-	case "del":
-		err := server.db.Delete(cmd.Arguments[0])
+	return command.Apply(server, database, cmd)
+}
+
+func applyExec(server *Server, root *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return nil, server.applyBatch(root, cmd.Batch)
+}
 
+func applyFlushAll(server *Server, root *db.Database, cmd *CommandMessage) (interface{}, error) {
+	server.watches.TouchAll()
+
+	return nil, server.flushAll(root, cmd.Version())
+}
+
+func applyACLSetUser(server *Server, root *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return nil, server.acl.Set(root, cmd.Arguments[0], db.User{Rules: cmd.Arguments[1:], Version: cmd.Version()})
+}
+
+func applyACLDelUser(server *Server, root *db.Database, cmd *CommandMessage) (interface{}, error) {
+	for _, name := range cmd.Arguments {
+		// The default user can be redefined but never deleted.
+		if name == "default" {
+			continue
+		}
+
+		err := server.acl.Set(root, name, db.User{Deleted: true, Version: cmd.Version()})
 		if err != nil {
-			logrus.WithError(err).Warn("failed to delete key")
+			return nil, err
 		}
+	}
+
+	return nil, nil
+}
+
+func applyFlushDB(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	server.watches.TouchDatabase(cmd.DB)
+
+	_, err := database.Flush(cmd.Version())
+	return nil, err
+}
+
+func applySet(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	_, err := database.Set(cmd.Arguments[0], cmd.Version(), cmd.Arguments[1], cmd.ExpiresAt)
+	return nil, err
+}
+
+func applyDel(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return database.DeleteKeys(server.now(), cmd.Version(), cmd.Arguments...)
+}
+
+func applyRename(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return nil, database.Rename(server.now(), cmd.Version(), cmd.Arguments[0], cmd.Arguments[1])
+}
+
+func applyPExpireAt(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return database.Expire(server.now(), cmd.Version(), cmd.Arguments[0], cmd.ExpiresAt)
+}
+
+func applyPersist(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return database.Persist(server.now(), cmd.Version(), cmd.Arguments[0])
+}
+
+func applyPush(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	now := server.now()
+	args := cmd.Arguments
+
+	switch cmd.Command {
+	case "lpush":
+		return database.LPush(now, args[0], args[1:]...)
+
+	case "rpush":
+		return database.RPush(now, args[0], args[1:]...)
+
+	case "lpushx":
+		return database.LPushX(now, args[0], args[1:]...)
 
 	default:
-		logrus.Warnf("Unknown command: %s", cmd.Command)
+		return database.RPushX(now, args[0], args[1:]...)
+	}
+}
+
+func applyPop(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	now := server.now()
+	args := cmd.Arguments
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.Command == "lpop" {
+		return database.LPop(now, args[0], count)
+	}
+
+	return database.RPop(now, args[0], count)
+}
+
+func applyLSet(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	args := cmd.Arguments
+
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, database.LSet(server.now(), args[0], index, args[2])
+}
+
+func applyLRem(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	args := cmd.Arguments
+
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return database.LRem(server.now(), args[0], count, args[2])
+}
+
+func applyLTrim(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	args := cmd.Arguments
+
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, database.LTrim(server.now(), args[0], start, stop)
+}
+
+func applyLInsert(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	args := cmd.Arguments
+
+	return database.LInsert(server.now(), args[0], strings.ToLower(args[1]) == "before", args[2], args[3])
+}
+
+func applyLMove(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	args := cmd.Arguments
+
+	return database.LMove(server.now(), args[0], args[1], db.ListEnd(args[2]), db.ListEnd(args[3]))
+}
+
+func applyHSet(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return database.HSet(server.now(), cmd.Arguments[0], cmd.Version(), cmd.Arguments[1:]...)
+}
+
+func applyHSetNX(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	args := cmd.Arguments
+
+	return database.HSetNX(server.now(), args[0], cmd.Version(), args[1], args[2])
+}
+
+func applyHDel(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return database.HDel(server.now(), cmd.Arguments[0], cmd.Version(), cmd.Arguments[1:]...)
+}
+
+func applyHIncrBy(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	args := cmd.Arguments
+
+	increment, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return database.HIncrBy(server.now(), args[0], cmd.Version(), args[1], increment)
+}
+
+func applySAdd(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return database.SAdd(server.now(), cmd.Arguments[0], cmd.Version(), cmd.Arguments[1:]...)
+}
+
+func applySRem(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return database.SRem(server.now(), cmd.Arguments[0], cmd.Tags)
+}
+
+func applyZAdd(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	options, _, members, err := parseZAddArguments(cmd.Arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	return database.ZAdd(server.now(), cmd.Arguments[0], cmd.Version(), options, members...)
+}
+
+func applyZIncrBy(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	args := cmd.Arguments
+
+	increment, err := strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return database.ZIncrBy(server.now(), args[0], cmd.Version(), args[2], increment)
+}
+
+func applyZRem(server *Server, database *db.Database, cmd *CommandMessage) (interface{}, error) {
+	return database.ZRem(server.now(), cmd.Arguments[0], cmd.Version(), cmd.Arguments[1:]...)
+}
+
+// dropSocket discards the socket of a node that has failed, unless it has already been replaced.
+func (server *Server) dropSocket(node *Node, c *websocket.Conn) {
+	server.socketMutex.Lock()
+	if server.sockets[node.NodeID()] == c {
+		delete(server.sockets, node.NodeID())
+	}
+	server.socketMutex.Unlock()
+
+	err := c.Close(websocket.StatusGoingAway, "write failed")
+	if err != nil {
+		logrus.WithError(err).Debug("failed to close websocket")
+	}
+}
+
+// nodeHTTPClient returns the HTTP client for requests to the websocket port of a node, which authenticates both nodes
+// with their certificates if nodes connect to each other with TLS.
+func (server *Server) nodeHTTPClient(node *Node) *http.Client {
+	if server.nodeTLS == nil {
+		return http.DefaultClient
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: server.nodeTLS.ClientConfig(node.NodeID())},
 	}
 }
 
@@ -251,14 +754,36 @@ func (server *Server) GetSocket(node *Node) (*websocket.Conn, error) {
 	m.Lock()
 	defer m.Unlock()
 
-	logrus.WithField("addr", node.Address()).Debug("Dialing websocket")
+	// Another goroutine may have dialed the node while this one waited.
+	server.socketMutex.Lock()
+	c, ok = server.sockets[node.NodeID()]
+	server.socketMutex.Unlock()
+
+	if ok {
+		return c, nil
+	}
+
+	logrus.WithField("addr", node.SocketAddress()).Debug("Dialing websocket")
+
+	options := &websocket.DialOptions{
+		Subprotocols: []string{"default"},
+		HTTPHeader:   server.nodeHeader(),
+	}
+
+	scheme := "ws"
+	if server.nodeTLS != nil {
+		scheme = "wss"
+		options.HTTPClient = server.nodeHTTPClient(node)
+	}
 
-	c, _, err := websocket.Dial(context.Background(), fmt.Sprintf("ws://%s", node.Address()), &websocket.DialOptions{Subprotocols: []string{"default"}})
+	c, _, err := websocket.Dial(context.Background(), fmt.Sprintf("%s://%s", scheme, node.SocketAddress()), options)
 	if err != nil {
 		return nil, err
 	}
 
+	server.socketMutex.Lock()
 	server.sockets[node.NodeID()] = c
+	server.socketMutex.Unlock()
 
 	return c, nil
 }
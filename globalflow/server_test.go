@@ -0,0 +1,163 @@
+package globalflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"globalflow/config"
+	"globalflow/globalflow/db"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a server with a database in a temporary directory.
+func newTestServer(t *testing.T, nodeID string) *Server {
+	configuration := config.NewConfiguration()
+	configuration.NodeID = nodeID
+	configuration.DatabasePath = t.TempDir()
+
+	database, err := db.NewDatabase(filepath.Join(configuration.DatabasePath, nodeID+".db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		database.Close()
+	})
+
+	server := NewServer(&Container{Configuration: configuration})
+	server.db = database
+
+	return server
+}
+
+// permutations returns every order of the integers from 0 to n.
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+
+	orders := make([][]int, 0)
+
+	for _, order := range permutations(n - 1) {
+		for i := 0; i <= len(order); i++ {
+			permuted := append(append(append([]int{}, order[:i]...), n-1), order[i:]...)
+			orders = append(orders, permuted)
+		}
+	}
+
+	return orders
+}
+
+// assertConvergence applies commands to a new node in every order, checks its state, and fails unless every order
+// converges on the same state.
+func assertConvergence(t *testing.T, commands []*CommandMessage, check func(server *Server, now db.Time) error) {
+	now := db.Time(time.Now().UnixMilli())
+
+	var root []byte
+
+	// Every node receives the same commands in a different order.
+	for i, order := range permutations(len(commands)) {
+		server := newTestServer(t, "d")
+
+		for _, index := range order {
+			cmd := *commands[index]
+
+			if _, err := server.processCommand(&cmd); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := check(server, now); err != nil {
+			t.Fatalf("order %v: %v", order, err)
+		}
+
+		tree, err := server.db.MerkleTree(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 0 {
+			root = tree.Hash("")
+		} else if !bytes.Equal(tree.Hash(""), root) {
+			t.Fatalf("order %v: expected every order to converge on the same state", order)
+		}
+	}
+}
+
+func TestServer_Convergence(t *testing.T) {
+	commands := []*CommandMessage{
+		{Time: 2, Originator: "a", Command: "set", Arguments: []string{"k", "from-a"}},
+		{Time: 2, Originator: "b", Command: "set", Arguments: []string{"k", "from-b"}},
+		{Time: 1, Originator: "c", Command: "set", Arguments: []string{"k", "from-c"}},
+		{Time: 3, Originator: "a", Command: "hset", Arguments: []string{"h", "f", "1"}},
+		{Time: 3, Originator: "c", Command: "hset", Arguments: []string{"h", "f", "2"}},
+	}
+
+	assertConvergence(t, commands, func(server *Server, now db.Time) error {
+		// Concurrent writes made at the same time are ordered by node ID.
+		if value, err := server.db.Get(now, "k"); err != nil || value != "from-b" {
+			return fmt.Errorf("expected from-b, got %q, %v", value, err)
+		}
+
+		if value, err := server.db.HGet(now, "h", "f"); err != nil || value != "2" {
+			return fmt.Errorf("expected 2, got %q, %v", value, err)
+		}
+
+		return nil
+	})
+
+	hour := db.Time(time.Now().Add(time.Hour).UnixMilli())
+	later := db.Time(time.Now().Add(2 * time.Hour).UnixMilli())
+
+	commands = []*CommandMessage{
+		// The deadline of the EXPIRE is kept along with the value of the concurrent SET, which is newer than the first.
+		{Time: 1, Originator: "a", Command: "set", Arguments: []string{"k", "first"}, ExpiresAt: hour},
+		{Time: 2, Originator: "c", Command: "set", Arguments: []string{"k", "second"}},
+		{Time: 3, Originator: "b", Command: "pexpireat", Arguments: []string{"k"}, ExpiresAt: later},
+
+		// A PERSIST removes the deadline of an older SET, even if it arrives before it.
+		{Time: 1, Originator: "b", Command: "set", Arguments: []string{"p", "value"}, ExpiresAt: hour},
+		{Time: 2, Originator: "a", Command: "persist", Arguments: []string{"p"}},
+	}
+
+	assertConvergence(t, commands, func(server *Server, now db.Time) error {
+		if value, err := server.db.Get(now, "k"); err != nil || value != "second" {
+			return fmt.Errorf("expected second, got %q, %v", value, err)
+		}
+
+		if ttl, err := server.db.TTL(now, "k"); err != nil || ttl <= hour-now {
+			return fmt.Errorf("expected the deadline of the EXPIRE, got a TTL of %d, %v", ttl, err)
+		}
+
+		if ttl, err := server.db.TTL(now, "p"); err != nil || ttl != db.NoExpiry {
+			return fmt.Errorf("expected no deadline, got a TTL of %d, %v", ttl, err)
+		}
+
+		return nil
+	})
+}
+
+func TestServer_RunRejectsInvalidConfiguration(t *testing.T) {
+	for _, update := range []func(configuration *config.Configuration){
+		func(configuration *config.Configuration) { configuration.ExpirySweepInterval = -time.Second },
+		func(configuration *config.Configuration) { configuration.ExpirySweepBatchSize = 0 },
+		func(configuration *config.Configuration) { configuration.ReplicationGapThreshold = -time.Second },
+		func(configuration *config.Configuration) { configuration.WALMaxSize = -1 },
+		func(configuration *config.Configuration) { configuration.AntiEntropyInterval = -time.Second },
+		func(configuration *config.Configuration) { configuration.TombstoneGracePeriod = -time.Second },
+		func(configuration *config.Configuration) { configuration.HintRetention = -time.Second },
+		func(configuration *config.Configuration) { configuration.ClockSkewLimit = -time.Second },
+		func(configuration *config.Configuration) { configuration.GossipKey = []byte("short") },
+	} {
+		configuration := config.NewConfiguration()
+		configuration.NodeID = "a"
+		configuration.DatabasePath = t.TempDir()
+		update(configuration)
+
+		if err := NewServer(&Container{Configuration: configuration}).Run(context.Background()); err == nil {
+			t.Error("expected an invalid configuration to be rejected")
+		}
+	}
+}
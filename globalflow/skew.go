@@ -0,0 +1,138 @@
+package globalflow
+
+import (
+	"errors"
+	"github.com/hashicorp/memberlist"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Nodes estimate the offset of every peer's wall clock from the wall clock readings that gossip carries in the acks of
+// its probes. The offset of the cluster's clock is the median of the offsets of the live peers and of the node itself,
+// so that a single peer with a skewed clock does not make every other node look skewed. A node whose clock is further
+// from the cluster's than the hard limit refuses writes, which would otherwise be stamped with times that order them
+// wrongly against the writes of the other nodes.
+
+// clockCheckInterval is how often the node checks the offsets of its peers' clocks.
+const clockCheckInterval = 5 * time.Second
+
+// clockOffsetTTL is how long a peer's clock offset is used after its most recent reading.
+const clockOffsetTTL = time.Minute
+
+// errClockSkew is returned for writes while the node's clock is further from the cluster's than the hard limit.
+var errClockSkew = errors.New("READONLY GlobalFlow refuses writes while the node's clock is skewed from the cluster")
+
+// clockSkew contains the skew of the node's clock from the cluster's.
+type clockSkew struct {
+	// skew is how far the cluster's clock is ahead of the node's, in nanoseconds.
+	skew atomic.Int64
+
+	// fenced is true while the node refuses writes because of the skew.
+	fenced atomic.Bool
+
+	// warned contains the peers whose clocks have been reported as skewed.
+	warned map[string]bool
+
+	// mu is a mutex for warned.
+	mu sync.Mutex
+}
+
+// MonitorClocks runs until shutdown, periodically checking the offsets of the peers' clocks.
+func (server *Server) MonitorClocks() {
+	ticker := time.NewTicker(clockCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.shutdownCh:
+			return
+
+		case <-ticker.C:
+			server.checkClocks(time.Now())
+		}
+	}
+}
+
+// clockOffsets returns the offset of the clock of every live peer with a recent reading.
+func (server *Server) clockOffsets(now time.Time) map[string]time.Duration {
+	readings := server.gossip.ClockOffsets()
+	offsets := make(map[string]time.Duration)
+
+	for _, node := range server.Nodes() {
+		reading, ok := readings[node.NodeID()]
+		if !ok || node.node.State != memberlist.StateAlive || now.Sub(reading.Updated) > clockOffsetTTL {
+			continue
+		}
+
+		offsets[node.NodeID()] = reading.Offset
+	}
+
+	return offsets
+}
+
+// checkClocks checks the offsets of the clocks of the live peers.
+func (server *Server) checkClocks(now time.Time) {
+	server.updateSkew(server.clockOffsets(now))
+}
+
+// updateSkew warns about the peers whose clocks are further from the node's than the warning threshold, and fences
+// writes while the node's clock is further from the cluster's than the hard limit.
+func (server *Server) updateSkew(offsets map[string]time.Duration) {
+	configuration := server.container.Configuration
+
+	server.clocks.mu.Lock()
+
+	if server.clocks.warned == nil {
+		server.clocks.warned = make(map[string]bool)
+	}
+
+	for name, offset := range offsets {
+		skewed := configuration.ClockSkewWarning > 0 && offset.Abs() > configuration.ClockSkewWarning
+
+		if skewed && !server.clocks.warned[name] {
+			logrus.WithField("node", name).WithField("offset", offset).Warn("Clock of peer is skewed")
+		} else if !skewed && server.clocks.warned[name] {
+			logrus.WithField("node", name).WithField("offset", offset).Info("Clock of peer is no longer skewed")
+		}
+
+		server.clocks.warned[name] = skewed
+	}
+
+	server.clocks.mu.Unlock()
+
+	skew := clusterSkew(offsets)
+	server.clocks.skew.Store(int64(skew))
+
+	fenced := configuration.ClockSkewLimit > 0 && skew.Abs() > configuration.ClockSkewLimit
+
+	if fenced && !server.clocks.fenced.Load() {
+		logrus.WithField("skew", skew).Error("Clock is skewed from the cluster, refusing writes")
+	} else if !fenced && server.clocks.fenced.Load() {
+		logrus.WithField("skew", skew).Info("Clock is no longer skewed from the cluster, accepting writes")
+	}
+
+	server.clocks.fenced.Store(fenced)
+}
+
+// clusterSkew returns how far the cluster's clock is ahead of the node's, given the offsets of its peers' clocks: the
+// median of the offsets and of the node's own, which is zero.
+func clusterSkew(offsets map[string]time.Duration) time.Duration {
+	values := []time.Duration{0}
+	for _, offset := range offsets {
+		values = append(values, offset)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return values[i] < values[j]
+	})
+
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+
+	return values[middle]
+}
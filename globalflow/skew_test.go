@@ -0,0 +1,67 @@
+package globalflow
+
+import (
+	"github.com/tidwall/redcon"
+	"globalflow/globalflow/db"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClusterSkew(t *testing.T) {
+	tests := []struct {
+		offsets  map[string]time.Duration
+		expected time.Duration
+	}{
+		{map[string]time.Duration{}, 0},
+
+		// With a single peer, the node cannot tell which clock is wrong, and splits the difference.
+		{map[string]time.Duration{"b": 4 * time.Second}, 2 * time.Second},
+
+		// A single skewed peer does not make the node look skewed.
+		{map[string]time.Duration{"b": 10 * time.Millisecond, "c": 5 * time.Second}, 10 * time.Millisecond},
+
+		// A node whose clock is behind every peer's is skewed.
+		{map[string]time.Duration{"b": 3 * time.Second, "c": 3100 * time.Millisecond, "d": 2900 * time.Millisecond}, 2950 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		if skew := clusterSkew(test.offsets); skew != test.expected {
+			t.Errorf("expected a skew of %s for %v, got %s", test.expected, test.offsets, skew)
+		}
+	}
+}
+
+func TestServer_ClockSkewFencing(t *testing.T) {
+	server := newTestServer(t, "a")
+	server.container.Configuration.ClockSkewLimit = time.Second
+
+	if _, err := server.db.Set("k", db.Version{}, "value", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every peer agrees that the node's clock is behind by more than the limit.
+	server.updateSkew(map[string]time.Duration{"b": 3 * time.Second, "c": 3100 * time.Millisecond})
+
+	conn := contextConn{replyRecorder: &replyRecorder{}, client: &Client{Protocol: protocolRESP2}}
+
+	server.Redis(conn, redcon.Command{Args: [][]byte{[]byte("SET"), []byte("k"), []byte("other")}})
+
+	if !strings.HasPrefix(string(conn.buf), "-READONLY ") {
+		t.Errorf("expected the write to be refused with READONLY, got %q", conn.buf)
+	}
+
+	conn.buf = nil
+	server.Redis(conn, redcon.Command{Args: [][]byte{[]byte("GET"), []byte("k")}})
+
+	if string(conn.buf) != "+value\r\n" {
+		t.Errorf("expected the read to be served, got %q", conn.buf)
+	}
+
+	// Writes are accepted again once the clock is corrected.
+	server.updateSkew(map[string]time.Duration{"b": 10 * time.Millisecond, "c": -10 * time.Millisecond})
+
+	if server.clocks.fenced.Load() {
+		t.Error("expected the node to accept writes once its clock is corrected")
+	}
+}
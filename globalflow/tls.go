@@ -0,0 +1,251 @@
+package globalflow
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"globalflow/config"
+	"os"
+	"sync"
+	"time"
+)
+
+// Certificates are read from files, which are checked for changes at most once per certificateCheckInterval and
+// reloaded when they change, so that certificates can be rotated without restarting the node. If a changed file cannot
+// be loaded, for example because it is only partly written, the previous certificate is kept and loading is retried.
+
+// certificateCheckInterval is how often certificate files are checked for changes.
+const certificateCheckInterval = time.Second
+
+// reloadable is a value loaded from files, which is reloaded when the files change.
+type reloadable struct {
+	mutex sync.Mutex
+
+	files []string
+
+	// load loads the value from the files.
+	load func() (interface{}, error)
+
+	value interface{}
+
+	// modified contains the modification times of the files when the value was loaded.
+	modified []time.Time
+
+	// checked is the last time the files were checked for changes.
+	checked time.Time
+
+	// interval is how often the files are checked for changes.
+	interval time.Duration
+}
+
+// newReloadable loads a value from files.
+func newReloadable(load func() (interface{}, error), files ...string) (*reloadable, error) {
+	r := &reloadable{
+		files:    files,
+		load:     load,
+		interval: certificateCheckInterval,
+	}
+
+	err := r.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Get returns the value, reloading it if its files have changed.
+func (r *reloadable) Get() interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if time.Since(r.checked) >= r.interval {
+		err := r.reload()
+		if err != nil {
+			logrus.WithError(err).WithField("files", r.files).Warn("failed to reload certificate files")
+		}
+	}
+
+	return r.value
+}
+
+// reload loads the value if any of the files has changed since it was last loaded.
+func (r *reloadable) reload() error {
+	r.checked = time.Now()
+
+	modified := make([]time.Time, len(r.files))
+	changed := r.value == nil
+
+	for i, file := range r.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		modified[i] = info.ModTime()
+
+		if r.modified == nil || !modified[i].Equal(r.modified[i]) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	value, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	if r.value != nil {
+		logrus.WithField("files", r.files).Info("Reloaded certificate files")
+	}
+
+	r.value, r.modified = value, modified
+
+	return nil
+}
+
+// newCertificate loads a certificate and its private key, which are reloaded when they change.
+func newCertificate(certFile string, keyFile string) (*reloadable, error) {
+	return newReloadable(func() (interface{}, error) {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return &certificate, nil
+	}, certFile, keyFile)
+}
+
+// newCertPool loads a PEM file of CA certificates, which is reloaded when it changes.
+func newCertPool(file string) (*reloadable, error) {
+	return newReloadable(func() (interface{}, error) {
+		encoded, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(encoded) {
+			return nil, fmt.Errorf("no certificates found in %s", file)
+		}
+
+		return pool, nil
+	}, file)
+}
+
+// redisTLSConfig returns the TLS configuration of the Redis listener, or nil if it is not configured.
+func redisTLSConfig(configuration *config.Configuration) (*tls.Config, error) {
+	if configuration.RedisTLSCertFile == "" && configuration.RedisTLSKeyFile == "" {
+		return nil, nil
+	}
+
+	certificate, err := newCertificate(configuration.RedisTLSCertFile, configuration.RedisTLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return certificate.Get().(*tls.Certificate), nil
+		},
+	}, nil
+}
+
+// nodeTLS contains the certificates that nodes use to authenticate each other.
+// Every node presents a certificate for its node name, signed by the cluster's CA.
+type nodeTLS struct {
+	certificate *reloadable
+	ca          *reloadable
+}
+
+// newNodeTLS loads the certificates that nodes use to authenticate each other, or returns nil if they are not
+// configured.
+func newNodeTLS(configuration *config.Configuration) (*nodeTLS, error) {
+	files := []string{configuration.NodeTLSCertFile, configuration.NodeTLSKeyFile, configuration.NodeTLSCAFile}
+
+	configured := 0
+	for _, file := range files {
+		if file != "" {
+			configured++
+		}
+	}
+
+	if configured == 0 {
+		return nil, nil
+	}
+
+	if configured != len(files) {
+		return nil, errors.New("node TLS requires a certificate, a key and a CA")
+	}
+
+	certificate, err := newCertificate(configuration.NodeTLSCertFile, configuration.NodeTLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := newCertPool(configuration.NodeTLSCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nodeTLS{
+		certificate: certificate,
+		ca:          ca,
+	}, nil
+}
+
+func (n *nodeTLS) getCertificate() *tls.Certificate {
+	return n.certificate.Get().(*tls.Certificate)
+}
+
+func (n *nodeTLS) getCA() *x509.CertPool {
+	return n.ca.Get().(*x509.CertPool)
+}
+
+// ServerConfig returns the TLS configuration of the websocket listener, which requires every node that connects to
+// present a certificate signed by the CA.
+func (n *nodeTLS) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			// A configuration is created for every connection, so that it uses the current certificate and CA.
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*n.getCertificate()},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    n.getCA(),
+			}, nil
+		},
+	}
+}
+
+// ClientConfig returns the TLS configuration for connecting to a node, which must present a certificate for its name.
+func (n *nodeTLS) ClientConfig(nodeName string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: nodeName,
+		RootCAs:    n.getCA(),
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return n.getCertificate(), nil
+		},
+	}
+}
+
+// verifyNodeName returns an error unless the peer of a verified TLS connection presented a certificate for a node name.
+func verifyNodeName(state *tls.ConnectionState, name string) error {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return errors.New("no peer certificate")
+	}
+
+	if name == "" {
+		return errors.New("no node name")
+	}
+
+	return state.PeerCertificates[0].VerifyHostname(name)
+}
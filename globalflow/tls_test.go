@@ -0,0 +1,225 @@
+package globalflow
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"globalflow/config"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority generated for a test.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue issues a certificate for a node name, returning the PEM encoded certificate and key.
+func (ca *testCA) issue(t *testing.T, name string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: encodedKey})
+}
+
+// writeFile writes a file with a modification time, so that changes are detected regardless of timestamp resolution.
+func writeFile(t *testing.T, path string, data []byte, modified time.Time) {
+	err := os.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chtimes(path, modified, modified)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testNodeTLS writes the certificates of a node, issued by one CA and trusting another, and loads them.
+func testNodeTLS(t *testing.T, issuer *testCA, ca *testCA, name string) *nodeTLS {
+	dir := t.TempDir()
+	cert, key := issuer.issue(t, name)
+
+	configuration := &config.Configuration{
+		NodeTLSCertFile: filepath.Join(dir, "node.crt"),
+		NodeTLSKeyFile:  filepath.Join(dir, "node.key"),
+		NodeTLSCAFile:   filepath.Join(dir, "ca.crt"),
+	}
+
+	writeFile(t, configuration.NodeTLSCertFile, cert, time.Now())
+	writeFile(t, configuration.NodeTLSKeyFile, key, time.Now())
+	writeFile(t, configuration.NodeTLSCAFile, ca.pem, time.Now())
+
+	n, err := newNodeTLS(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestReloadable_ReloadsChangedCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key")
+
+	cert, key := ca.issue(t, "a")
+	writeFile(t, certFile, cert, time.Now().Add(-time.Minute))
+	writeFile(t, keyFile, key, time.Now().Add(-time.Minute))
+
+	certificate, err := newCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate.interval = 0
+
+	name := func() string {
+		leaf, err := x509.ParseCertificate(certificate.Get().(*tls.Certificate).Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return leaf.Subject.CommonName
+	}
+
+	// A certificate that is only partly written is not loaded.
+	cert, key = ca.issue(t, "b")
+	writeFile(t, certFile, cert, time.Now())
+
+	if n := name(); n != "a" {
+		t.Errorf("expected the previous certificate to be kept, got %s", n)
+	}
+
+	writeFile(t, keyFile, key, time.Now())
+
+	if n := name(); n != "b" {
+		t.Errorf("expected the new certificate to be loaded, got %s", n)
+	}
+}
+
+func TestNodeTLS_VerifiesNodeNames(t *testing.T) {
+	ca := newTestCA(t)
+	a := testNodeTLS(t, ca, ca, "a")
+	b := testNodeTLS(t, ca, ca, "b")
+	stranger := testNodeTLS(t, newTestCA(t), ca, "b")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if verifyNodeName(r.TLS, r.Header.Get(NodeNameHTTPHeader)) != nil {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}),
+	}
+
+	go s.Serve(tls.NewListener(l, a.ServerConfig()))
+	defer s.Close()
+
+	get := func(client *nodeTLS, serverName string, nodeName string) (int, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: client.ClientConfig(serverName)}}
+
+		request, err := http.NewRequest(http.MethodGet, "https://"+l.Addr().String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request.Header.Set(NodeNameHTTPHeader, nodeName)
+
+		response, err := c.Do(request)
+		if err != nil {
+			return 0, err
+		}
+
+		defer response.Body.Close()
+
+		return response.StatusCode, nil
+	}
+
+	if status, err := get(b, "a", "b"); err != nil || status != http.StatusOK {
+		t.Errorf("expected node b to connect, got %d (%v)", status, err)
+	}
+
+	if status, err := get(b, "a", "c"); err != nil || status != http.StatusForbidden {
+		t.Errorf("expected node b to be refused as c, got %d (%v)", status, err)
+	}
+
+	if _, err := get(b, "c", "b"); err == nil {
+		t.Error("expected node a to be refused as c")
+	}
+
+	if _, err := get(stranger, "a", "b"); err == nil {
+		t.Error("expected a certificate from another CA to be refused")
+	}
+}
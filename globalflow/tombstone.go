@@ -0,0 +1,124 @@
+package globalflow
+
+import (
+	"github.com/hashicorp/memberlist"
+	"github.com/sirupsen/logrus"
+	"globalflow/globalflow/db"
+	"time"
+)
+
+// Deleted keys are kept as tombstones until every live node has received the writes they could be overtaken by. Each
+// node advertises its horizon through gossip: the time before which it has received every write, which is the current
+// time unless commands are missing from an origin, in which case it is when the earliest gap was found. A tombstone is
+// purged once it is older than the grace period and than the horizon of every live node.
+
+// tombstoneGCInterval is how often the node advertises its horizon and purges the tombstones that every node has seen
+// past.
+const tombstoneGCInterval = time.Minute
+
+// tombstonePurgeBatch is the most tombstones purged in a single transaction.
+const tombstonePurgeBatch = 1000
+
+// CollectTombstones runs until shutdown, periodically advertising the node's horizon and purging tombstones.
+func (server *Server) CollectTombstones() {
+	ticker := time.NewTicker(tombstoneGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.shutdownCh:
+			return
+
+		case <-ticker.C:
+			now := time.Now()
+
+			err := server.gossip.SetHorizon(server.horizon(now))
+			if err != nil {
+				logrus.WithError(err).Warn("failed to advertise horizon")
+			}
+
+			server.purgeTombstones(now)
+		}
+	}
+}
+
+// horizon returns the time, in Unix milliseconds, before which the node has received every write, or zero while it is
+// bootstrapping its data.
+func (server *Server) horizon(now time.Time) int64 {
+	if server.loading.Load() {
+		return 0
+	}
+
+	horizon := now
+
+	for _, gap := range server.sequences.Outstanding(now, 0) {
+		if gap.Since.Before(horizon) {
+			horizon = gap.Since
+		}
+	}
+
+	return horizon.UnixMilli()
+}
+
+// purgeHorizon returns the time, in Unix milliseconds, before which every live node has received every write, or zero
+// if a live node has not advertised its horizon.
+func (server *Server) purgeHorizon(now time.Time) int64 {
+	horizon := server.horizon(now)
+
+	for _, node := range server.Nodes() {
+		if node.NodeID() == server.container.Configuration.NodeID || node.node.State != memberlist.StateAlive {
+			continue
+		}
+
+		metadata := node.Metadata()
+		if metadata == nil || metadata.Horizon == 0 {
+			return 0
+		}
+
+		if metadata.Horizon < horizon {
+			horizon = metadata.Horizon
+		}
+	}
+
+	return horizon
+}
+
+// purgeTombstones purges the tombstones of every logical database that are older than the grace period and than the
+// horizon of every live node.
+func (server *Server) purgeTombstones(now time.Time) {
+	before := server.purgeHorizon(now)
+
+	if grace := now.Add(-server.container.Configuration.TombstoneGracePeriod).UnixMilli(); grace < before {
+		before = grace
+	}
+
+	if before <= 0 {
+		return
+	}
+
+	for index := 0; index < db.Databases; index++ {
+		database, err := server.db.Select(index)
+		if err != nil {
+			logrus.WithError(err).Warn("failed to select database")
+			return
+		}
+
+		for {
+			purged, err := database.PurgeTombstones(db.Time(before), tombstonePurgeBatch)
+			if err != nil {
+				logrus.WithError(err).Warn("failed to purge tombstones")
+				return
+			}
+
+			server.tombstonesPurged.Add(uint64(purged))
+
+			if purged > 0 {
+				logrus.WithField("db", index).WithField("tombstones", purged).Debug("Purged tombstones")
+			}
+
+			if purged < tombstonePurgeBatch {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package globalflow
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/redcon"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Client-side caching remembers which keys each tracking connection has read, and sends the connection an invalidation
+// message when one of them changes, whether the write was made on this node or replicated from another region.
+// Keys are tracked by name in every logical database, as in Redis.
+
+// invalidationChannel is the channel that RESP2 connections subscribe to in order to receive redirected invalidations.
+const invalidationChannel = "__redis__:invalidate"
+
+// trackingOptions are the options of CLIENT TRACKING ON.
+type trackingOptions struct {
+	// Redirect is the ID of the connection that receives the invalidation messages, or 0 for the tracking connection.
+	Redirect int64
+
+	// BCast is true if the connection is sent every change to keys matching its prefixes, rather than to keys it has read.
+	BCast bool
+
+	// Prefixes contains the key prefixes of a broadcasting connection. No prefixes match every key.
+	Prefixes []string
+
+	// OptIn is true if only keys read right after CLIENT CACHING YES are tracked.
+	OptIn bool
+
+	// OptOut is true if keys read right after CLIENT CACHING NO are not tracked.
+	OptOut bool
+
+	// NoLoop is true if the connection is not sent invalidations for its own writes.
+	NoLoop bool
+}
+
+// invalidation is an invalidation message to be written to a connection.
+type invalidation struct {
+	receiver *pushConn
+
+	// keys contains the keys that have changed, or is nil if every key has.
+	keys []string
+}
+
+// tracking contains the keys tracked for client-side caching.
+type tracking struct {
+	mutex sync.Mutex
+
+	// clients contains the options of each connection with tracking enabled.
+	clients map[*Client]trackingOptions
+
+	// keys contains the connections that have read each key since it last changed.
+	keys map[string]map[*Client]bool
+
+	// clientKeys contains the keys that each connection has read since they last changed.
+	clientKeys map[*Client]map[string]bool
+
+	// receivers contains the detached connections that invalidation messages can be pushed to, by client ID.
+	receivers map[int64]*pushConn
+}
+
+func newTracking() *tracking {
+	return &tracking{
+		clients:    make(map[*Client]trackingOptions),
+		keys:       make(map[string]map[*Client]bool),
+		clientKeys: make(map[*Client]map[string]bool),
+		receivers:  make(map[int64]*pushConn),
+	}
+}
+
+// Enable enables tracking for a connection, replacing its previous options and forgetting the keys it has read.
+func (t *tracking) Enable(client *Client, options trackingOptions) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if options.Redirect != 0 && t.receivers[options.Redirect] == nil {
+		return errors.New("ERR The client ID you want redirect to does not exist")
+	}
+
+	t.forget(client)
+	t.clients[client] = options
+
+	return nil
+}
+
+// Disable disables tracking for a connection.
+func (t *tracking) Disable(client *Client) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.forget(client)
+	delete(t.clients, client)
+}
+
+// Options returns the tracking options of a connection.
+// Returns false if tracking is disabled.
+func (t *tracking) Options(client *Client) (trackingOptions, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	options, ok := t.clients[client]
+
+	return options, ok
+}
+
+// Register registers a detached connection, which can then be sent invalidation messages.
+func (t *tracking) Register(id int64, receiver *pushConn) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.receivers[id] = receiver
+}
+
+// Receiver returns the detached connection of a client, or nil if it is not detached.
+func (t *tracking) Receiver(id int64) *pushConn {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.receivers[id]
+}
+
+// Remove disables tracking for a connection that has closed, and stops sending it invalidation messages.
+func (t *tracking) Remove(client *Client) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.forget(client)
+	delete(t.clients, client)
+	delete(t.receivers, client.ID)
+}
+
+// forget forgets the keys read by a connection.
+// The mutex must be held.
+func (t *tracking) forget(client *Client) {
+	for key := range t.clientKeys[client] {
+		delete(t.keys[key], client)
+
+		if len(t.keys[key]) == 0 {
+			delete(t.keys, key)
+		}
+	}
+
+	delete(t.clientKeys, client)
+}
+
+// Read records that a connection has read keys, if it is tracking them.
+func (t *tracking) Read(client *Client, keys ...string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	options, ok := t.clients[client]
+	if !ok || options.BCast {
+		return
+	}
+
+	if options.OptIn && client.Caching != "yes" || options.OptOut && client.Caching == "no" {
+		return
+	}
+
+	if t.clientKeys[client] == nil {
+		t.clientKeys[client] = make(map[string]bool)
+	}
+
+	for _, key := range keys {
+		if t.keys[key] == nil {
+			t.keys[key] = make(map[*Client]bool)
+		}
+
+		t.keys[key][client] = true
+		t.clientKeys[client][key] = true
+	}
+}
+
+// Invalidate forgets changed keys and returns the invalidation messages to send for them.
+// writer is the connection that made the change, if it was made on this node.
+func (t *tracking) Invalidate(writer *Client, keys ...string) []invalidation {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.clients) == 0 {
+		return nil
+	}
+
+	changed := make(map[*Client][]string)
+	order := make([]*Client, 0)
+
+	add := func(client *Client, key string) {
+		if _, ok := changed[client]; !ok {
+			order = append(order, client)
+		}
+
+		changed[client] = append(changed[client], key)
+	}
+
+	for _, key := range keys {
+		for client := range t.keys[key] {
+			delete(t.clientKeys[client], key)
+			add(client, key)
+		}
+
+		delete(t.keys, key)
+
+		for client, options := range t.clients {
+			if options.BCast && hasAnyPrefix(key, options.Prefixes) {
+				add(client, key)
+			}
+		}
+	}
+
+	invalidations := make([]invalidation, 0, len(order))
+
+	for _, client := range order {
+		if inv, ok := t.message(client, writer, changed[client]); ok {
+			invalidations = append(invalidations, inv)
+		}
+	}
+
+	return invalidations
+}
+
+// InvalidateAll forgets every key and returns the invalidation messages to send to every tracking connection.
+func (t *tracking) InvalidateAll(writer *Client) []invalidation {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	invalidations := make([]invalidation, 0)
+
+	for client := range t.clients {
+		t.forget(client)
+
+		if inv, ok := t.message(client, writer, nil); ok {
+			invalidations = append(invalidations, inv)
+		}
+	}
+
+	return invalidations
+}
+
+// message returns the invalidation message to send for a tracking connection.
+// Returns false if there is no connection to send it to.
+// The mutex must be held.
+func (t *tracking) message(client *Client, writer *Client, keys []string) (invalidation, bool) {
+	options := t.clients[client]
+
+	if options.NoLoop && client == writer {
+		return invalidation{}, false
+	}
+
+	id := client.ID
+	if options.Redirect != 0 {
+		id = options.Redirect
+	}
+
+	receiver := t.receivers[id]
+	if receiver == nil {
+		return invalidation{}, false
+	}
+
+	return invalidation{receiver: receiver, keys: keys}, true
+}
+
+// hasAnyPrefix returns true if a key starts with any of the prefixes, or if there are none.
+func hasAnyPrefix(key string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseTrackingOptions parses the options of CLIENT TRACKING ON.
+func parseTrackingOptions(args []string) (trackingOptions, error) {
+	options := trackingOptions{}
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		default:
+			return options, errSyntax
+
+		case "redirect":
+			if i+1 >= len(args) {
+				return options, errSyntax
+			}
+
+			id, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || id <= 0 {
+				return options, errors.New("ERR Invalid client ID")
+			}
+
+			options.Redirect = id
+			i++
+
+		case "prefix":
+			if i+1 >= len(args) {
+				return options, errSyntax
+			}
+
+			options.Prefixes = append(options.Prefixes, args[i+1])
+			i++
+
+		case "bcast":
+			options.BCast = true
+
+		case "optin":
+			options.OptIn = true
+
+		case "optout":
+			options.OptOut = true
+
+		case "noloop":
+			options.NoLoop = true
+		}
+	}
+
+	if len(options.Prefixes) > 0 && !options.BCast {
+		return options, errors.New("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+
+	if options.OptIn && options.OptOut {
+		return options, errors.New("ERR You can't use both OPTIN and OPTOUT")
+	}
+
+	if options.BCast && (options.OptIn || options.OptOut) {
+		return options, errors.New("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+
+	return options, nil
+}
+
+// appendInvalidation appends an invalidation message for keys, or for every key if keys is nil.
+// RESP3 connections receive a push message, and RESP2 connections a message on the invalidation channel.
+func appendInvalidation(buf []byte, protocol int, keys []string) []byte {
+	if protocol == protocolRESP3 {
+		buf = append(buf, ">2\r\n"...)
+		buf = redcon.AppendBulkString(buf, "invalidate")
+	} else {
+		buf = redcon.AppendArray(buf, 3)
+		buf = redcon.AppendBulkString(buf, "message")
+		buf = redcon.AppendBulkString(buf, invalidationChannel)
+	}
+
+	if keys == nil {
+		if protocol == protocolRESP3 {
+			return append(buf, "_\r\n"...)
+		}
+
+		return redcon.AppendNull(buf)
+	}
+
+	buf = redcon.AppendArray(buf, len(keys))
+	for _, key := range keys {
+		buf = redcon.AppendBulkString(buf, key)
+	}
+
+	return buf
+}
+
+// trackRead records the keys read by a command for client-side caching.
+// The keys of every read-only command are tracked.
+func (server *Server) trackRead(client *Client, command *Command, args []string) {
+	if command.Flags&CommandReadOnly == 0 {
+		return
+	}
+
+	keys := command.ArgumentKeys(args)
+	if len(keys) == 0 {
+		return
+	}
+
+	server.tracking.Read(client, keys...)
+}
+
+// invalidate sends invalidation messages for the keys written by a command once it has been applied.
+// writer is the connection that sent the command, or nil if it was replicated from another node.
+func (server *Server) invalidate(cmd *CommandMessage, writer *Client) {
+	switch cmd.Command {
+	case "exec":
+		for _, c := range cmd.Batch {
+			server.invalidate(c, writer)
+		}
+
+	case "flushdb", "flushall":
+		server.deliver(server.tracking.InvalidateAll(writer))
+
+	default:
+		server.deliver(server.tracking.Invalidate(writer, commandKeys(cmd)...))
+	}
+}
+
+// deliver writes invalidation messages to their connections.
+// A RESP2 connection only receives them while it is subscribed to the invalidation channel.
+func (server *Server) deliver(invalidations []invalidation) {
+	for _, inv := range invalidations {
+		p, keys := inv.receiver, inv.keys
+
+		p.write(func(conn redcon.DetachedConn) {
+			if p.protocol != protocolRESP3 && (p.subscriber == nil || !server.pubsub.Subscribed(p.subscriber, invalidationChannel)) {
+				return
+			}
+
+			conn.WriteRaw(appendInvalidation(nil, p.protocol, keys))
+		})
+	}
+}
+
+// serveTracked runs the commands of a connection that has been detached to receive its own invalidation messages,
+// until it closes.
+// Replies are recorded and written under the connection's lock, so that they are not interleaved with invalidations.
+func (server *Server) serveTracked(p *pushConn, client *Client) {
+	// The reply to the command that enabled tracking has not been flushed yet.
+	p.write(func(conn redcon.DetachedConn) {})
+
+	for {
+		cmd, err := p.conn.ReadCommand()
+		if err != nil {
+			break
+		}
+
+		reply := &replyRecorder{Conn: p.conn}
+
+		server.Redis(reply, cmd)
+
+		// The connection has been handed over to a subscriber.
+		if server.tracking.Receiver(client.ID) != p {
+			return
+		}
+
+		p.write(func(conn redcon.DetachedConn) {
+			conn.WriteRaw(reply.buf)
+
+			// The protocol is updated along with the reply, so that invalidations follow the protocol the client expects.
+			p.protocol = client.Protocol
+		})
+	}
+
+	server.release(client)
+
+	err := p.conn.Close()
+	if err != nil {
+		logrus.WithError(err).Debug("failed to close tracking connection")
+	}
+}
@@ -0,0 +1,136 @@
+package globalflow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTracking_Invalidate(t *testing.T) {
+	tr := newTracking()
+
+	a := &Client{ID: 1}
+	b := &Client{ID: 2}
+	c := &Client{ID: 3}
+
+	ra, rc := &pushConn{}, &pushConn{}
+	tr.Register(a.ID, ra)
+	tr.Register(c.ID, rc)
+
+	if err := tr.Enable(a, trackingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// b's invalidations are redirected to c.
+	if err := tr.Enable(b, trackingOptions{Redirect: c.ID, BCast: true, Prefixes: []string{"user:"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tr.Read(a, "foo", "user:1")
+	tr.Read(b, "foo")
+
+	invalidations := tr.Invalidate(nil, "foo", "user:1", "bar")
+	if len(invalidations) != 2 {
+		t.Fatalf("expected 2 invalidations, got %d", len(invalidations))
+	}
+
+	for _, inv := range invalidations {
+		switch inv.receiver {
+		case ra:
+			if !reflect.DeepEqual(inv.keys, []string{"foo", "user:1"}) {
+				t.Errorf("unexpected keys %v", inv.keys)
+			}
+
+		case rc:
+			if !reflect.DeepEqual(inv.keys, []string{"user:1"}) {
+				t.Errorf("unexpected keys %v", inv.keys)
+			}
+
+		default:
+			t.Errorf("unexpected receiver")
+		}
+	}
+
+	// Keys are forgotten once they have been invalidated.
+	if invalidations := tr.Invalidate(nil, "foo"); len(invalidations) != 0 {
+		t.Errorf("expected no invalidations, got %d", len(invalidations))
+	}
+}
+
+func TestTracking_OptInAndNoLoop(t *testing.T) {
+	tr := newTracking()
+
+	a := &Client{ID: 1}
+	tr.Register(a.ID, &pushConn{})
+
+	if err := tr.Enable(a, trackingOptions{OptIn: true, NoLoop: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	tr.Read(a, "foo")
+
+	a.Caching = "yes"
+	tr.Read(a, "bar")
+
+	if invalidations := tr.Invalidate(nil, "foo"); len(invalidations) != 0 {
+		t.Errorf("expected no invalidation of a key read without CLIENT CACHING YES")
+	}
+
+	if invalidations := tr.Invalidate(a, "bar"); len(invalidations) != 0 {
+		t.Errorf("expected no invalidation of the client's own write")
+	}
+
+	if invalidations := tr.InvalidateAll(nil); len(invalidations) != 1 || invalidations[0].keys != nil {
+		t.Errorf("expected a flush, got %v", invalidations)
+	}
+}
+
+func TestTracking_EnableRequiresRedirectReceiver(t *testing.T) {
+	tr := newTracking()
+
+	if err := tr.Enable(&Client{ID: 1}, trackingOptions{Redirect: 2}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestParseTrackingOptions(t *testing.T) {
+	options, err := parseTrackingOptions([]string{"REDIRECT", "7", "BCAST", "PREFIX", "a", "PREFIX", "b", "NOLOOP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := trackingOptions{Redirect: 7, BCast: true, Prefixes: []string{"a", "b"}, NoLoop: true}
+	if !reflect.DeepEqual(options, expected) {
+		t.Errorf("unexpected options %+v", options)
+	}
+
+	for _, args := range [][]string{
+		{"PREFIX", "a"},
+		{"OPTIN", "OPTOUT"},
+		{"BCAST", "OPTIN"},
+		{"REDIRECT", "x"},
+		{"FOO"},
+	} {
+		_, err := parseTrackingOptions(args)
+		if err == nil {
+			t.Errorf("expected error for %q", args)
+		}
+	}
+}
+
+func TestAppendInvalidation(t *testing.T) {
+	tests := []struct {
+		protocol int
+		keys     []string
+		expected string
+	}{
+		{protocolRESP3, []string{"foo"}, ">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nfoo\r\n"},
+		{protocolRESP3, nil, ">2\r\n$10\r\ninvalidate\r\n_\r\n"},
+		{protocolRESP2, []string{"foo"}, "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$3\r\nfoo\r\n"},
+	}
+
+	for _, test := range tests {
+		if actual := string(appendInvalidation(nil, test.protocol, test.keys)); actual != test.expected {
+			t.Errorf("unexpected message %q", actual)
+		}
+	}
+}
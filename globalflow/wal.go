@@ -0,0 +1,114 @@
+package globalflow
+
+import (
+	"encoding/json"
+	"github.com/sirupsen/logrus"
+	"globalflow/globalflow/db"
+	"time"
+)
+
+// walTrimInterval is how often the write-ahead log is trimmed to its retention.
+const walTrimInterval = time.Minute
+
+// writeWAL records a replicated command in the write-ahead log, in the transaction of the handle if it is bound to one.
+func (server *Server) writeWAL(root *db.Database, cmd *CommandMessage) error {
+	encoded, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+
+	entry := &db.WALEntry{
+		Origin:   cmd.Originator,
+		Sequence: cmd.Sequence,
+		Time:     db.Time(time.Now().UnixMilli()),
+		Command:  encoded,
+	}
+
+	err = root.AppendWAL(entry)
+	if err != nil {
+		return err
+	}
+
+	// A command originated by this node takes its sequence number from the log, so that every command it broadcasts is
+	// numbered in order. The number is rolled back with the transaction if it fails.
+	if cmd.Originator == server.container.Configuration.NodeID {
+		cmd.Sequence = entry.Sequence
+	}
+
+	return nil
+}
+
+// commitCommand applies a command and records it in the write-ahead log in a single transaction, so that the log holds
+// exactly the commands whose writes have been applied.
+// A command discarded by a more recent flush is still recorded, as it was received like any other.
+func (server *Server) commitCommand(root *db.Database, cmd *CommandMessage) (interface{}, error) {
+	var result interface{}
+	var applied error
+
+	err := root.Batch(func(tx *db.Database) error {
+		result, applied = server.applyCommand(tx, cmd)
+		if applied != nil && applied != errFlushed {
+			return applied
+		}
+
+		return server.writeWAL(tx, cmd)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, applied
+}
+
+// WALSince calls fn with the commands originated by a node that were recorded in the write-ahead log after a sequence
+// number, in sequence order, until fn returns an error. Commands that retention has removed are skipped.
+func (server *Server) WALSince(origin string, after uint64, fn func(sequence uint64, cmd *CommandMessage) error) error {
+	return server.db.WALSince(origin, after, func(entry db.WALEntry) error {
+		cmd := &CommandMessage{}
+
+		err := json.Unmarshal(entry.Command, cmd)
+		if err != nil {
+			return err
+		}
+
+		return fn(entry.Sequence, cmd)
+	})
+}
+
+// TrimWAL runs until shutdown, periodically removing the entries of the write-ahead log that fall outside its
+// retention.
+func (server *Server) TrimWAL() {
+	ticker := time.NewTicker(walTrimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.shutdownCh:
+			return
+
+		case <-ticker.C:
+			server.trimWAL(time.Now())
+		}
+	}
+}
+
+// trimWAL removes the entries of the write-ahead log that are older than the retention period, then the oldest entries
+// until the log fits in its maximum size.
+func (server *Server) trimWAL(now time.Time) {
+	configuration := server.container.Configuration
+
+	before := db.Time(0)
+	if configuration.WALRetention > 0 {
+		before = db.Time(now.Add(-configuration.WALRetention).UnixMilli())
+	}
+
+	removed, err := server.db.TrimWAL(before, configuration.WALMaxSize)
+	if err != nil {
+		logrus.WithError(err).Warn("failed to trim write-ahead log")
+		return
+	}
+
+	if removed > 0 {
+		logrus.WithField("entries", removed).Debug("Trimmed write-ahead log")
+	}
+}
@@ -0,0 +1,49 @@
+package globalflow
+
+import (
+	"globalflow/config"
+	"globalflow/globalflow/db"
+	"path/filepath"
+	"testing"
+)
+
+func TestServer_CommitCommand(t *testing.T) {
+	database, err := db.NewDatabase(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer database.Close()
+
+	server := &Server{db: database, clock: NewClock(0), watches: newWatches(), container: &Container{Configuration: config.NewConfiguration()}}
+
+	set := &CommandMessage{Time: 1, Command: "set", Arguments: []string{"a", "value"}, Originator: "b", DB: 2}
+	if _, err := server.commitCommand(database, set); err != nil {
+		t.Fatal(err)
+	}
+
+	// A command that fails to apply is rolled back along with its log entry.
+	push := &CommandMessage{Time: 2, Command: "lpush", Arguments: []string{"a", "x"}, Originator: "b", DB: 2}
+	if _, err := server.commitCommand(database, push); err == nil {
+		t.Fatal("expected a wrong type error")
+	}
+
+	logged := make([]*CommandMessage, 0)
+
+	err = server.WALSince("b", 0, func(sequence uint64, cmd *CommandMessage) error {
+		if sequence != uint64(len(logged)+1) {
+			t.Errorf("unexpected sequence %d", sequence)
+		}
+
+		logged = append(logged, cmd)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(logged) != 1 || logged[0].Command != "set" || logged[0].DB != 2 || logged[0].Arguments[1] != "value" {
+		t.Errorf("unexpected log %+v", logged)
+	}
+}